@@ -59,12 +59,30 @@ func (p *DAGConfig) DumpDot() string {
 	return builder.String()
 }
 
+// DumpResultDot dump dot graph annotated by execution result
+func (p *DAGConfig) DumpResultDot(result *graph.ExecuteResult) string {
+	builder := &strings.Builder{}
+	p.graph.DumpResultDot(builder, result)
+	return builder.String()
+}
+
 // GenPng generate png from file
 func (p *DAGConfig) GenPng(filePath string) error {
 	if len(filePath) > 0 {
 		p.scriptPath = filePath
 	}
-	dot := p.DumpDot()
+	return p.genPng(p.DumpDot())
+}
+
+// GenResultPng generate png of execution result from file
+func (p *DAGConfig) GenResultPng(filePath string, result *graph.ExecuteResult) error {
+	if len(filePath) > 0 {
+		p.scriptPath = filePath
+	}
+	return p.genPng(p.DumpResultDot(result))
+}
+
+func (p *DAGConfig) genPng(dot string) error {
 	if strings.Contains(p.scriptPath, "&") ||
 		strings.Contains(p.scriptPath, "|") ||
 		strings.Contains(p.scriptPath, ";") {
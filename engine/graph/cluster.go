@@ -4,49 +4,57 @@ import (
 	"fmt"
 	"strings"
 
+	"xxxx/dagengine/engine/param"
 	"xxxx/dagengine/engine/processor"
+
+	"github.com/antonmedv/expr/vm"
 )
 
 const defaultContextPoolSize = 10
 
-// ConfigSetting some expr
+// ConfigSetting some expr, or a processor returning a bool or flags
 type ConfigSetting struct {
-	Name      string `toml:"name"`
-	Cond      string `toml:"cond"`
-	Processor string `toml:"processor"`
+	Name      string       `toml:"name"`
+	Cond      string       `toml:"cond"`
+	Processor string       `toml:"processor"`
+	Args      param.Params `toml:"args"`
+
+	program *vm.Program
+	// deps other config settings referenced by cond
+	deps []string
+	// processors initialized processors of the setting, reused by requests
+	processors *settingProcessors
 }
 
 // Cluster multi graph cluster
 type Cluster struct {
-	Desc                   string          `toml:"desc" json:"desc"`
-	StrictDsl              bool            `toml:"strict_dsl" json:"strict_dsl"`
-	DefaultContextPoolSize int             `toml:"default_context_pool_size" json:"default_context_pool_size"`
-	Graph                  []Graph         `toml:"graph" json:"graph"`
-	ConfigSetting          []ConfigSetting `toml:"config_setting" json:"config_setting"`
+	Desc                   string             `toml:"desc" json:"desc"`
+	StrictDsl              bool               `toml:"strict_dsl" json:"strict_dsl"`
+	DefaultContextPoolSize int                `toml:"default_context_pool_size" json:"default_context_pool_size"`
+	ContextPool            *ContextPoolConfig `toml:"context_pool" json:"context_pool"`
+	Graph                  []Graph            `toml:"graph" json:"graph"`
+	ConfigSetting          []ConfigSetting    `toml:"config_setting" json:"config_setting"`
+	Params                 map[string]string  `toml:"params" json:"params"`
 
 	ClusterContextPool *ClusterContextPool
 	GraphManager       *Manager
 	Name               string
 
 	graphMap map[string]*Graph
+	versions map[string]*graphVersions
 	opsMap   map[string]processor.OperatorMeta
+	// paramEnv zero values of declared params
+	paramEnv map[string]interface{}
 }
 
-// ContainsConfigSetting if cluster contains configsetting
+// ContainsConfigSetting if cluster contains configsetting, flags of processor setting are referred as 'name.flag'
 func (c *Cluster) ContainsConfigSetting(name string) bool {
-	for _, c := range c.ConfigSetting {
-		if len(name) > 0 && name[0] == '!' {
-			if c.Name == name[1:] {
-				return true
-			}
-		} else {
-			if c.Name == name {
-				return true
-			}
-		}
-
+	name, flag, _ := parseSettingRef(name)
+	cs := c.getConfigSetting(name)
+	if cs == nil {
+		return false
 	}
-	return false
+	return len(flag) == 0 || len(cs.Processor) > 0
 }
 
 func (c *Cluster) getOpMeta(name string) *processor.OperatorMeta {
@@ -58,15 +66,12 @@ func (c *Cluster) getOpMeta(name string) *processor.OperatorMeta {
 }
 
 func (c *Cluster) initClusterContext() error {
-	c.ClusterContextPool = NewClusterContextPool()
-	for i := 0; i < c.DefaultContextPoolSize; i++ {
-		cc, err := NewClusterContext(c)
-		if err != nil {
-			return err
-		}
-		c.ClusterContextPool.Put(cc)
+	pool, err := newClusterContextPool(c.DefaultContextPoolSize, c.ContextPool)
+	if err != nil {
+		return err
 	}
-	return nil
+	c.ClusterContextPool = pool
+	return pool.warmUp(c)
 }
 
 // Build build graph cluster
@@ -78,25 +83,54 @@ func (c *Cluster) Build(ops []processor.OperatorMeta) error {
 	for _, op := range ops {
 		c.opsMap[op.Name] = op
 	}
-	c.graphMap = make(map[string]*Graph)
+	errs := newBuildErrors(c.StrictDsl)
+	if errs.add(c.buildParams()) {
+		return errs.err()
+	}
+	if errs.add(c.buildConfigSettings()) {
+		return errs.err()
+	}
+	c.versions = make(map[string]*graphVersions)
 	for i := range c.Graph {
 		g := &c.Graph[i]
 		g.cluster = c
-		if existg, exist := c.graphMap[g.Name]; exist {
+		gv, exist := c.versions[g.Name]
+		if !exist {
+			gv = &graphVersions{}
+			c.versions[g.Name] = gv
+		}
+		duplicate := false
+		for _, existg := range gv.graphs {
 			if existg.ExpectVersion == g.ExpectVersion {
-				return fmt.Errorf("Duplicate graph name:%v", g.Name)
+				duplicate = true
 			}
-			if g.Priority <= existg.Priority {
-				continue
+		}
+		if duplicate {
+			// the first graph of the name is built and checked
+			if errs.add(fmt.Errorf("Duplicate graph name:%v", g.Name)) {
+				return errs.err()
 			}
+			continue
+		}
+		gv.graphs = append(gv.graphs, g)
+	}
+	c.graphMap = make(map[string]*Graph)
+	for _, g := range c.sortedGraphs() {
+		if errs.add(g.Build()) {
+			return errs.err()
 		}
-		c.graphMap[g.Name] = g
 	}
-	for _, g := range c.graphMap {
-		err := g.Build()
-		if nil != err {
-			return err
+	for name, gv := range c.versions {
+		if errs.add(gv.build()) {
+			return errs.err()
 		}
+		c.graphMap[name] = gv.getDefault()
+	}
+	if c.StrictDsl {
+		errs.add(c.checkStrictDsl())
+	}
+	if len(errs.errs) > 0 {
+		return errs.err()
 	}
 	return c.initClusterContext()
 }
@@ -3,108 +3,414 @@ package graph
 import (
 	"container/list"
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"sync"
+	"time"
 
 	"xxxx/dagengine/engine/param"
+	"xxxx/util/safe"
+)
+
+// ErrContextPoolExhausted pool with on_full = "reject" reaches its max size
+var ErrContextPoolExhausted = errors.New("cluster context pool exhausted")
+
+// behaviours of full pool
+const (
+	ContextPoolBlock  = "block"
+	ContextPoolReject = "reject"
+)
+
+// ContextPoolConfig limits of cluster context pool, default_context_pool_size contexts are
+// created on load and never evicted
+type ContextPoolConfig struct {
+	// Max contexts in use and idle, 0 means unlimited
+	Max int `toml:"max" json:"max"`
+	// MaxIdle idle contexts kept, more contexts put back are dropped, 0 means unlimited
+	MaxIdle int `toml:"max_idle" json:"max_idle"`
+	// IdleTimeout contexts idle longer are evicted, empty means never
+	IdleTimeout string `toml:"idle_timeout" json:"idle_timeout"`
+	// OnFull block(default) waits for a context until ctx done, reject returns ErrContextPoolExhausted
+	OnFull string `toml:"on_full" json:"on_full"`
+}
 
-	"github.com/antonmedv/expr"
+// idleContext cluster context in pool and when it was put back
+type idleContext struct {
+	cc    *ClusterContext
+	since time.Time
+}
+
+// idle sweep interval bounds, the sweeper runs at half of the shortest idle_timeout
+const (
+	minIdleSweepInterval = 10 * time.Millisecond
+	maxIdleSweepInterval = time.Minute
 )
 
 // ClusterContextPool cluster context pool
 type ClusterContextPool struct {
-	l    *list.List
-	size int
-	lock sync.Mutex
+	// idle contexts, the latest put back at front
+	l *list.List
+	// waiters chans of Get blocked by full pool
+	waiters     *list.List
+	min         int
+	max         int
+	maxIdle     int
+	idleTimeout time.Duration
+	reject      bool
+	// total contexts in use and idle
+	total    int
+	hits     uint64
+	misses   uint64
+	waits    uint64
+	rejected uint64
+	evicted  uint64
+	overflow uint64
+	lock     sync.Mutex
+}
+
+// ClusterContextPoolStats usage of cluster context pool
+type ClusterContextPoolStats struct {
+	// Hits Misses gets reusing a pooled context and creating a new one
+	Hits   uint64
+	Misses uint64
+	// Waits Rejected gets blocked and rejected by full pool
+	Waits    uint64
+	Rejected uint64
+	// Evicted idle contexts dropped by max_idle or idle_timeout
+	Evicted uint64
+	// Overflow contexts created above max for re-entrant subgraph calls
+	Overflow uint64
+	// Size idle contexts in pool
+	Size  int
+	InUse int
 }
 
-// NewClusterContextPool create cluster context pool
+// NewClusterContextPool create unlimited cluster context pool
 func NewClusterContextPool() *ClusterContextPool {
-	return &ClusterContextPool{l: list.New()}
+	return &ClusterContextPool{l: list.New(), waiters: list.New()}
 }
 
-// Get get one cluster context from pool
+// newClusterContextPool create pool limited by config, min contexts are never evicted
+func newClusterContextPool(min int, config *ContextPoolConfig) (*ClusterContextPool, error) {
+	cp := NewClusterContextPool()
+	cp.min = min
+	if config == nil {
+		return cp, nil
+	}
+	if config.Max < 0 || (config.Max > 0 && config.Max < min) {
+		return nil, fmt.Errorf("invalid context_pool max:%d with default_context_pool_size:%d", config.Max, min)
+	}
+	if config.MaxIdle < 0 || (config.MaxIdle > 0 && config.MaxIdle < min) {
+		return nil, fmt.Errorf("invalid context_pool max_idle:%d with default_context_pool_size:%d",
+			config.MaxIdle, min)
+	}
+	if len(config.IdleTimeout) > 0 {
+		timeout, err := time.ParseDuration(config.IdleTimeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid context_pool idle_timeout:%s", config.IdleTimeout)
+		}
+		cp.idleTimeout = timeout
+	}
+	switch config.OnFull {
+	case "", ContextPoolBlock:
+	case ContextPoolReject:
+		cp.reject = true
+	default:
+		return nil, fmt.Errorf("invalid context_pool on_full:%s", config.OnFull)
+	}
+	cp.max = config.Max
+	cp.maxIdle = config.MaxIdle
+	return cp, nil
+}
+
+// warmUp create min contexts with all graph contexts built
+func (cp *ClusterContextPool) warmUp(c *Cluster) error {
+	for i := 0; i < cp.min; i++ {
+		cc, err := NewClusterContext(c)
+		if err != nil {
+			return err
+		}
+		cp.lock.Lock()
+		cp.total++
+		cp.lock.Unlock()
+		cp.Put(cc)
+	}
+	return nil
+}
+
+// Get get one cluster context from pool, block without deadline if pool is full
 func (cp *ClusterContextPool) Get(c *Cluster) (*ClusterContext, error) {
+	return cp.GetContext(context.Background(), c)
+}
+
+// GetContext get one cluster context from pool, contexts created on demand build graph contexts
+// when used. A full pool blocks until a context is put back or ctx done, or rejects by config.
+func (cp *ClusterContextPool) GetContext(ctx context.Context, c *Cluster) (*ClusterContext, error) {
+	return cp.getContext(ctx, c, false)
+}
+
+// getContext get one cluster context, reentrant gets of subgraph calls whose callers hold a context of
+// the pool never wait or get rejected, a context above max is created for them and dropped when put back
+func (cp *ClusterContextPool) getContext(ctx context.Context, c *Cluster, reentrant bool) (*ClusterContext, error) {
 	cp.lock.Lock()
-	f := cp.l.Front()
-	if f != nil {
+	cp.evictIdle(time.Now())
+	if f := cp.l.Front(); f != nil {
 		cp.l.Remove(f)
-		cp.size--
+		cp.hits++
+		cp.lock.Unlock()
+		return f.Value.(*idleContext).cc, nil
+	}
+	if cp.max <= 0 || cp.total < cp.max || reentrant {
+		if cp.max > 0 && cp.total >= cp.max {
+			cp.overflow++
+		}
+		cp.total++
+		cp.misses++
+		cp.lock.Unlock()
+		return newClusterContext(c), nil
+	}
+	if cp.reject {
+		cp.rejected++
 		cp.lock.Unlock()
-		return f.Value.(*ClusterContext), nil
+		return nil, fmt.Errorf("cluster:%s max:%d %w", c.Name, cp.max, ErrContextPoolExhausted)
 	}
+	cp.waits++
+	w := make(chan *ClusterContext, 1)
+	e := cp.waiters.PushBack(w)
 	cp.lock.Unlock()
-	return NewClusterContext(c)
+	select {
+	case cc := <-w:
+		return cc, nil
+	case <-ctx.Done():
+	}
+	cp.lock.Lock()
+	select {
+	case cc := <-w:
+		// handed over while ctx done
+		cp.lock.Unlock()
+		cp.Put(cc)
+	default:
+		cp.waiters.Remove(e)
+		cp.lock.Unlock()
+	}
+	return nil, fmt.Errorf("cluster:%s wait context err:%w", c.Name, ctx.Err())
 }
 
-// Put put cluster context into pool
+// Put put cluster context into pool, hand it to a blocked get first
 func (cp *ClusterContextPool) Put(c *ClusterContext) {
 	cp.lock.Lock()
 	defer cp.lock.Unlock()
-	cp.l.PushBack(c)
-	cp.size++
+	if f := cp.waiters.Front(); f != nil {
+		cp.waiters.Remove(f)
+		cp.hits++
+		f.Value.(chan *ClusterContext) <- c
+		return
+	}
+	now := time.Now()
+	cp.evictIdle(now)
+	if (cp.maxIdle > 0 && cp.l.Len() >= cp.maxIdle) || (cp.max > 0 && cp.total > cp.max) {
+		cp.total--
+		cp.evicted++
+		return
+	}
+	cp.l.PushFront(&idleContext{cc: c, since: now})
 }
 
-// NewClusterContext new cluster context
-func NewClusterContext(c *Cluster) (*ClusterContext, error) {
-	cp := &ClusterContext{
-		GraphContextTable: make(map[string]*Context),
-		ConfigSetting:     c.ConfigSetting,
+// EvictIdle evict contexts idle longer than idle_timeout, also done by every Get and Put
+// and by the idle sweeper of manager
+func (cp *ClusterContextPool) EvictIdle() {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	cp.evictIdle(time.Now())
+}
+
+// evictIdle drop the oldest idle contexts above min which timed out
+func (cp *ClusterContextPool) evictIdle(now time.Time) {
+	if cp.idleTimeout <= 0 {
+		return
 	}
-	cp.Cluster = c
-	for i, cfg := range c.Graph {
-		var err error
-		cp.GraphContextTable[cfg.Name], err = NewContext(cp, &c.Graph[i])
-		if err != nil {
-			return nil, fmt.Errorf("new context err:%w", err)
+	for cp.l.Len() > cp.min {
+		b := cp.l.Back()
+		if now.Sub(b.Value.(*idleContext).since) < cp.idleTimeout {
+			return
+		}
+		cp.l.Remove(b)
+		cp.total--
+		cp.evicted++
+	}
+}
+
+// Stats usage of pool
+func (cp *ClusterContextPool) Stats() ClusterContextPoolStats {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return ClusterContextPoolStats{Hits: cp.hits, Misses: cp.misses, Waits: cp.waits, Rejected: cp.rejected,
+		Evicted: cp.evicted, Overflow: cp.overflow, Size: cp.l.Len(), InUse: cp.total - cp.l.Len()}
+}
+
+// startIdleSweeper start the idle sweeper of manager once a cluster with idle_timeout is loaded,
+// so pools grown by a spike shrink when traffic stops
+func (m *Manager) startIdleSweeper(clusters map[string]*Cluster) {
+	for _, c := range clusters {
+		if c.ClusterContextPool != nil && c.ClusterContextPool.idleTimeout > 0 {
+			m.sweepOnce.Do(func() {
+				m.sweeping.Add(1)
+				safe.Go(func() {
+					defer m.sweeping.Done()
+					m.sweepIdle()
+				})
+			})
+			return
+		}
+	}
+}
+
+// sweepIdle evict idle contexts of the current clusters at half of the shortest idle_timeout
+// until manager closed, clusters replaced by reload are no longer swept
+func (m *Manager) sweepIdle() {
+	for {
+		interval := maxIdleSweepInterval
+		for _, c := range m.snapshot() {
+			if cp := c.ClusterContextPool; cp != nil && cp.idleTimeout > 0 {
+				cp.EvictIdle()
+				if cp.idleTimeout/2 < interval {
+					interval = cp.idleTimeout / 2
+				}
+			}
+		}
+		if interval < minIdleSweepInterval {
+			interval = minIdleSweepInterval
+		}
+		select {
+		case <-m.done:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// NewClusterContext new cluster context with contexts of all graphs
+func NewClusterContext(c *Cluster) (*ClusterContext, error) {
+	cp := newClusterContext(c)
+	for i := range c.Graph {
+		if _, err := cp.getGraphContext(&c.Graph[i]); err != nil {
+			return nil, err
 		}
 	}
 	return cp, nil
 }
 
+// newClusterContext new cluster context building graph contexts when used
+func newClusterContext(c *Cluster) *ClusterContext {
+	return &ClusterContext{
+		Cluster:           c,
+		GraphContextTable: make(map[*Graph]*Context),
+		ConfigSetting:     c.ConfigSetting,
+	}
+}
+
+// getGraphContext context of graph, built on first use
+func (c *ClusterContext) getGraphContext(g *Graph) (*Context, error) {
+	if graphContext, ok := c.GraphContextTable[g]; ok {
+		return graphContext, nil
+	}
+	graphContext, err := NewContext(c, g)
+	if err != nil {
+		return nil, fmt.Errorf("new context err:%w", err)
+	}
+	c.GraphContextTable[g] = graphContext
+	return graphContext, nil
+}
+
 // ClusterContext cluster execute context
 type ClusterContext struct {
 	Cluster           *Cluster
-	GraphContextTable map[string]*Context
+	GraphContextTable map[*Graph]*Context
 	ExternDataContext *DataContext
 	ExecuteParams     *param.Params
 	ConfigSetting     []ConfigSetting
+	// Scope args of the calling subgraph vertex, used to resolve '$' data ids
+	Scope *param.Params
+
+	// clusters generation this execution started with, subgraphs are resolved in it
+	clusters map[string]*Cluster
+	// depth nesting depth of subgraph calls
+	depth int
+	// version graph version requested explicitly
+	version string
+	// step step mode state, nil if vertexes run concurrently
+	step *stepper
+	// trace records the execution if not nil
+	trace *Trace
+	// replay replaces processors by recorded outputs if not nil
+	replay *replayer
+	// executionID parentID parentVertex linkage of events
+	executionID  uint64
+	parentID     uint64
+	parentVertex string
+	// caller cluster context of the calling subgraph vertex
+	caller *ClusterContext
+	// callPath subgraph vertexes calling this execution, empty for the entry graph
+	callPath string
+}
+
+// holds the context or one of its callers is taken from pool
+func (c *ClusterContext) holds(pool *ClusterContextPool) bool {
+	for cc := c; cc != nil; cc = cc.caller {
+		if cc.Cluster.ClusterContextPool == pool {
+			return true
+		}
+	}
+	return false
 }
 
 // Execute cluster execute with datacontext and params
 func (c *ClusterContext) Execute(ctx context.Context, graphName string,
 	dataContext *DataContext, params *param.Params) error {
+	_, err := c.ExecuteWithResult(ctx, graphName, dataContext, params)
+	return err
+}
+
+// ExecuteWithResult cluster execute with datacontext and params, return execution report
+func (c *ClusterContext) ExecuteWithResult(ctx context.Context, graphName string,
+	dataContext *DataContext, params *param.Params) (*ExecuteResult, error) {
 	c.ExternDataContext = dataContext
 	c.ExecuteParams = params
 	return c.execute(ctx, graphName)
 }
 
 // Execute cluster execute by graph name
-func (c *ClusterContext) execute(ctx context.Context, graphName string) error {
-	graphContext, ok := c.GraphContextTable[graphName]
-	if !ok {
-		return fmt.Errorf("not find graph:%v", graphName)
-	}
-	if c.ExecuteParams != nil {
-		for _, cs := range c.ConfigSetting {
-			output, err := expr.Eval(cs.Cond, *c.ExecuteParams)
-			if err != nil {
-				log.Printf("expr name:%v expect:%v err:%v", cs.Name, output, err)
-			} else {
-				if expect, ok := output.(bool); ok {
-					c.ExternDataContext.SetConfigSetting(cs.Name, expect)
-				}
-			}
-		}
+func (c *ClusterContext) execute(ctx context.Context, graphName string) (*ExecuteResult, error) {
+	g, err := c.Cluster.selectGraph(graphName, c.version, c.ExecuteParams)
+	if err != nil {
+		return nil, err
+	}
+	graphContext, err := c.getGraphContext(g)
+	if err != nil {
+		return nil, err
+	}
+	if c.trace != nil && c.depth == 0 {
+		c.trace.begin(c.Cluster.Name, g, c.ExecuteParams)
 	}
-	return graphContext.Execute(ctx, c.ExternDataContext)
+	return graphContext.ExecuteWithResult(ctx, c.ExternDataContext)
 }
 
 // Reset cluster context reset
 func (c *ClusterContext) Reset() {
 	c.ExternDataContext = nil
 	c.ExecuteParams = nil
+	c.Scope = nil
+	c.clusters = nil
+	c.depth = 0
+	c.version = ""
+	c.step = nil
+	c.trace = nil
+	c.replay = nil
+	c.executionID = 0
+	c.parentID = 0
+	c.parentVertex = ""
+	c.callPath = ""
+	c.caller = nil
 	for _, g := range c.GraphContextTable {
 		g.Reset()
 	}
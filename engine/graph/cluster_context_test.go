@@ -0,0 +1,215 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/dagengine/engine/processor"
+)
+
+const contextPoolTestGraphs = `
+[[graph]]
+name = "a"
+[[graph.vertex]]
+start = true
+processor = "phase7"
+[[graph]]
+name = "b"
+[[graph.vertex]]
+start = true
+processor = "phase7"
+`
+
+func TestClusterContextPool_Limits(t *testing.T) {
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	load := func(t *testing.T, pool string) (*Manager, *Cluster) {
+		m := New()
+		t.Cleanup(m.Close)
+		if err := m.load("pool_test.toml", []byte(pool+contextPoolTestGraphs), &TomlCodec{}); err != nil {
+			t.Fatalf("Manager.load() error = %v", err)
+		}
+		return m, m.snapshot()["pool_test.toml"]
+	}
+	execute := func(ctx context.Context, m *Manager) error {
+		_, err := m.execute(ctx, "pool_test.toml", "a", NewDataContext(), &param.Params{}, nil)
+		return err
+	}
+	t.Run("reject", func(t *testing.T) {
+		m, c := load(t, "default_context_pool_size = 1\ncontext_pool = {max = 1, on_full = \"reject\"}\n")
+		held, err := c.ClusterContextPool.GetContext(context.Background(), c)
+		if err != nil {
+			t.Fatalf("ClusterContextPool.GetContext() error = %v", err)
+		}
+		if err := execute(context.Background(), m); !errors.Is(err, ErrContextPoolExhausted) {
+			t.Errorf("Manager.execute() error = %v, want %v", err, ErrContextPoolExhausted)
+		}
+		c.ClusterContextPool.Put(held)
+		if err := execute(context.Background(), m); err != nil {
+			t.Errorf("Manager.execute() error = %v", err)
+		}
+		if got := c.ClusterContextPool.Stats(); got.Rejected != 1 || got.Hits != 2 || got.Misses != 0 {
+			t.Errorf("ClusterContextPool.Stats() = %+v, want 1 rejected 2 hits", got)
+		}
+	})
+	t.Run("block", func(t *testing.T) {
+		m, c := load(t, "default_context_pool_size = 1\ncontext_pool = {max = 1}\n")
+		held, err := c.ClusterContextPool.GetContext(context.Background(), c)
+		if err != nil {
+			t.Fatalf("ClusterContextPool.GetContext() error = %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := execute(ctx, m); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Manager.execute() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+		time.AfterFunc(10*time.Millisecond, func() {
+			c.ClusterContextPool.Put(held)
+		})
+		if err := execute(context.Background(), m); err != nil {
+			t.Errorf("Manager.execute() error = %v", err)
+		}
+		if got := c.ClusterContextPool.Stats(); got.Waits != 2 || got.Size != 1 || got.InUse != 0 {
+			t.Errorf("ClusterContextPool.Stats() = %+v, want 2 waits 1 idle", got)
+		}
+	})
+	t.Run("evict", func(t *testing.T) {
+		_, c := load(t, "default_context_pool_size = 1\ncontext_pool = {max_idle = 2, idle_timeout = \"10ms\"}\n")
+		pool := c.ClusterContextPool
+		var held []*ClusterContext
+		for i := 0; i < 3; i++ {
+			cc, err := pool.GetContext(context.Background(), c)
+			if err != nil {
+				t.Fatalf("ClusterContextPool.GetContext() error = %v", err)
+			}
+			held = append(held, cc)
+		}
+		if len(held[0].GraphContextTable) != 2 || len(held[1].GraphContextTable) != 0 {
+			t.Errorf("GraphContextTable = %d/%d, want warmed up 2, on demand 0",
+				len(held[0].GraphContextTable), len(held[1].GraphContextTable))
+		}
+		if _, err := held[1].ExecuteWithResult(context.Background(), "b", NewDataContext(), &param.Params{}); err != nil {
+			t.Errorf("ClusterContext.ExecuteWithResult() error = %v", err)
+		}
+		if len(held[1].GraphContextTable) != 1 {
+			t.Errorf("GraphContextTable = %d, want only the executed graph", len(held[1].GraphContextTable))
+		}
+		held[1].Reset()
+		for _, cc := range held {
+			pool.Put(cc)
+		}
+		if got := pool.Stats(); got.Size != 2 || got.Evicted != 1 {
+			t.Errorf("ClusterContextPool.Stats() = %+v, want 2 idle 1 evicted by max_idle", got)
+		}
+		time.Sleep(20 * time.Millisecond)
+		pool.EvictIdle()
+		if got := pool.Stats(); got.Size != 1 || got.Evicted != 2 || got.InUse != 0 {
+			t.Errorf("ClusterContextPool.Stats() = %+v, want min 1 idle after idle timeout", got)
+		}
+	})
+	t.Run("sweep", func(t *testing.T) {
+		_, c := load(t, "default_context_pool_size = 1\ncontext_pool = {idle_timeout = \"20ms\"}\n")
+		pool := c.ClusterContextPool
+		var held []*ClusterContext
+		for i := 0; i < 3; i++ {
+			cc, err := pool.GetContext(context.Background(), c)
+			if err != nil {
+				t.Fatalf("ClusterContextPool.GetContext() error = %v", err)
+			}
+			held = append(held, cc)
+		}
+		for _, cc := range held {
+			pool.Put(cc)
+		}
+		// no more gets and puts, the sweeper of manager shrinks the pool
+		time.Sleep(200 * time.Millisecond)
+		if got := pool.Stats(); got.Size != 1 || got.Evicted != 2 {
+			t.Errorf("ClusterContextPool.Stats() = %+v, want min 1 idle after sweep", got)
+		}
+	})
+	t.Run("close", func(t *testing.T) {
+		m, c := load(t, "default_context_pool_size = 1\ncontext_pool = {idle_timeout = \"20ms\"}\n")
+		m.Close()
+		pool := c.ClusterContextPool
+		var held []*ClusterContext
+		for i := 0; i < 3; i++ {
+			cc, err := pool.GetContext(context.Background(), c)
+			if err != nil {
+				t.Fatalf("ClusterContextPool.GetContext() error = %v", err)
+			}
+			held = append(held, cc)
+		}
+		for _, cc := range held {
+			pool.Put(cc)
+		}
+		// the sweeper stopped by Close, idle contexts stay until the next get or put
+		time.Sleep(100 * time.Millisecond)
+		if got := pool.Stats(); got.Size != 3 || got.Evicted != 0 {
+			t.Errorf("ClusterContextPool.Stats() = %+v, want 3 idle after close", got)
+		}
+		m.Close()
+	})
+	t.Run("reentrant", func(t *testing.T) {
+		content := `
+name = "pool_reentrant_test.toml"
+default_context_pool_size = 1
+context_pool = {max = 1}
+[[graph]]
+name = "a"
+[[graph.vertex]]
+id = "call_b"
+start = true
+cluster = "pool_reentrant_test.toml"
+graph = "b"
+[[graph]]
+name = "b"
+[[graph.vertex]]
+start = true
+processor = "phase7"
+`
+		m := New()
+		if err := m.load("pool_reentrant_test.toml", []byte(content), &TomlCodec{}); err != nil {
+			t.Fatalf("Manager.load() error = %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		result, err := m.execute(ctx, "pool_reentrant_test.toml", "a", NewDataContext(), &param.Params{}, nil)
+		if err != nil {
+			t.Fatalf("Manager.execute() error = %v", err)
+		}
+		if vr := result.Vertexes["call_b"]; vr.Status != VertexOk {
+			t.Errorf("subgraph vertex status = %v, want ok", vr.Status)
+		}
+		pool := m.snapshot()["pool_reentrant_test.toml"].ClusterContextPool
+		if got := pool.Stats(); got.Overflow != 1 || got.Size != 1 || got.InUse != 0 || got.Evicted != 1 {
+			t.Errorf("ClusterContextPool.Stats() = %+v, want 1 overflow dropped when put back", got)
+		}
+	})
+	for _, tt := range []struct {
+		name    string
+		pool    string
+		wantErr string
+	}{
+		{name: "max_below_min", pool: "default_context_pool_size = 4\ncontext_pool = {max = 2}\n",
+			wantErr: "invalid context_pool max:2"},
+		{name: "on_full", pool: "context_pool = {on_full = \"drop\"}\n", wantErr: "invalid context_pool on_full:drop"},
+		{name: "idle_timeout", pool: "context_pool = {idle_timeout = \"1x\"}\n",
+			wantErr: "invalid context_pool idle_timeout:1x"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New().load("pool_test.toml", []byte(tt.pool+contextPoolTestGraphs), &TomlCodec{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Manager.load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	t.Run("default_size_within_max", func(t *testing.T) {
+		_, c := load(t, "context_pool = {max = 2}\n")
+		if got := c.ClusterContextPool.Stats(); got.Size != 2 {
+			t.Errorf("ClusterContextPool.Stats() = %+v, want warmed up to max", got)
+		}
+	})
+}
@@ -0,0 +1,338 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+
+	"xxxx/dagengine/engine/processor"
+
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/parser"
+)
+
+// configSettingKey data context key of config setting value evaluated in one request
+type configSettingKey struct {
+	cluster *Cluster
+	name    string
+}
+
+// settingValue config setting value, evaluated once per request
+type settingValue struct {
+	once  sync.Once
+	value bool
+	flags map[string]bool
+}
+
+// parseSettingRef split config setting reference like '!name.flag' into name, flag and negation
+func parseSettingRef(ref string) (name string, flag string, negate bool) {
+	if len(ref) > 0 && ref[0] == '!' {
+		ref = ref[1:]
+		negate = true
+	}
+	if idx := strings.IndexByte(ref, '.'); idx >= 0 {
+		return ref[:idx], ref[idx+1:], negate
+	}
+	return ref, "", negate
+}
+
+// getConfigSetting get config setting by name
+func (c *Cluster) getConfigSetting(name string) *ConfigSetting {
+	for i := range c.ConfigSetting {
+		if c.ConfigSetting[i].Name == name {
+			return &c.ConfigSetting[i]
+		}
+	}
+	return nil
+}
+
+type identVisitor struct {
+	idents []string
+}
+
+func (v *identVisitor) Visit(node *ast.Node) {
+	if n, ok := (*node).(*ast.IdentifierNode); ok {
+		v.idents = append(v.idents, n.Value)
+	}
+}
+
+// build compile cond and check processor of config setting
+func (cs *ConfigSetting) build(c *Cluster) error {
+	if strings.ContainsAny(cs.Name, ".!") {
+		return fmt.Errorf("config_setting:%s name must not contain '.' or '!'", cs.Name)
+	}
+	if len(cs.Cond) > 0 && len(cs.Processor) > 0 {
+		return fmt.Errorf("config_setting:%s has both cond and processor", cs.Name)
+	}
+	if len(cs.Processor) > 0 {
+		return cs.buildProcessor(c)
+	}
+	if len(cs.Cond) == 0 {
+		return nil
+	}
+	program, err := compileExpr(cs.Cond)
+	if err != nil {
+		return fmt.Errorf("config_setting:%s invalid cond:%v", cs.Name, err)
+	}
+	cs.program = program
+	tree, err := parser.Parse(cs.Cond)
+	if err != nil {
+		return fmt.Errorf("config_setting:%s invalid cond:%v", cs.Name, err)
+	}
+	visitor := &identVisitor{}
+	ast.Walk(&tree.Node, visitor)
+	cs.deps = nil
+	for _, ident := range visitor.idents {
+		if ident != cs.Name && c.getConfigSetting(ident) != nil {
+			cs.deps = append(cs.deps, ident)
+		}
+	}
+	return nil
+}
+
+// buildProcessor check processor of config setting outputs one bool or flags
+func (cs *ConfigSetting) buildProcessor(c *Cluster) error {
+	op := c.getOpMeta(cs.Processor)
+	if op == nil {
+		return fmt.Errorf("config_setting:%s processor:%s not found", cs.Name, cs.Processor)
+	}
+	// init once at build, fails if processor is not registered
+	di, err := cs.newProcessor()
+	if err != nil {
+		return err
+	}
+	cs.processors = new(settingProcessors)
+	cs.processors.put(di)
+	var values, flags int
+	for _, output := range op.Output {
+		t := output.Type
+		if t == nil {
+			// meta loaded from file, use the field of the registered processor
+			f, ok := di.getPlan().byName[output.Name]
+			if !ok {
+				continue
+			}
+			t = f.typ
+		}
+		if isSettingValue(t) {
+			values++
+		} else if isSettingFlags(t) {
+			flags++
+		}
+	}
+	if values+flags != 1 {
+		return fmt.Errorf("config_setting:%s processor:%s must have exactly one bool or map[string]bool output",
+			cs.Name, cs.Processor)
+	}
+	return nil
+}
+
+// newProcessor create and init processor of config setting
+func (cs *ConfigSetting) newProcessor() (*ProcessorDI, error) {
+	p := processor.Get(cs.Processor)
+	if p == nil {
+		return nil, fmt.Errorf("config_setting:%s processor:%s not registered", cs.Name, cs.Processor)
+	}
+	di := &ProcessorDI{Processor: p}
+	if err := di.PrepareInput(nil); err != nil {
+		return nil, fmt.Errorf("config_setting:%s processor:%s err:%v", cs.Name, cs.Processor, err)
+	}
+	p.OnInit()
+	return di, nil
+}
+
+// settingProcessors initialized processors of a config setting, one is taken by each evaluation
+type settingProcessors struct {
+	lock  sync.Mutex
+	items []*ProcessorDI
+}
+
+func (s *settingProcessors) get() *ProcessorDI {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.items) == 0 {
+		return nil
+	}
+	di := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return di
+}
+
+func (s *settingProcessors) put(di *ProcessorDI) {
+	s.lock.Lock()
+	s.items = append(s.items, di)
+	s.lock.Unlock()
+}
+
+func isSettingValue(t reflect.Type) bool {
+	return GetNoPtrType(t).Kind() == reflect.Bool
+}
+
+func isSettingFlags(t reflect.Type) bool {
+	t = GetNoPtrType(t)
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.Bool
+}
+
+// buildConfigSettings build config settings and check cond dependencies have no cycle
+func (c *Cluster) buildConfigSettings() error {
+	for i := range c.ConfigSetting {
+		if err := c.ConfigSetting[i].build(c); err != nil {
+			return err
+		}
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(cs *ConfigSetting, path []string) error
+	visit = func(cs *ConfigSetting, path []string) error {
+		state[cs.Name] = visiting
+		path = append(path, cs.Name)
+		for _, dep := range cs.deps {
+			switch state[dep] {
+			case visiting:
+				return fmt.Errorf("config_setting cycle:%s -> %s", strings.Join(path, " -> "), dep)
+			case visited:
+				continue
+			}
+			if err := visit(c.getConfigSetting(dep), path); err != nil {
+				return err
+			}
+		}
+		state[cs.Name] = visited
+		return nil
+	}
+	for i := range c.ConfigSetting {
+		if state[c.ConfigSetting[i].Name] == 0 {
+			if err := visit(&c.ConfigSetting[i], nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// evalConfigSetting evaluate config setting reference of expect_config or select_args
+func (c *ClusterContext) evalConfigSetting(ctx context.Context, ref string) bool {
+	name, flag, negate := parseSettingRef(ref)
+	var value bool
+	if cs := c.Cluster.getConfigSetting(name); cs != nil {
+		sv := c.getSettingValue(ctx, cs)
+		if len(flag) > 0 {
+			value = sv.flags[flag]
+		} else {
+			value = sv.value
+		}
+	} else {
+		// set by caller directly
+		value = c.ExternDataContext.GetConfigSetting(trimNot(ref))
+	}
+	if negate {
+		return !value
+	}
+	return value
+}
+
+// getSettingValue evaluate config setting lazily, memoized in data context of the request
+func (c *ClusterContext) getSettingValue(ctx context.Context, cs *ConfigSetting) *settingValue {
+	key := configSettingKey{cluster: c.Cluster, name: cs.Name}
+	// foreach elements run with child data contexts, settings are shared by the whole request
+	dataContext := c.ExternDataContext.root()
+	v, _ := dataContext.Data.LoadOrStore(key, &settingValue{})
+	sv := v.(*settingValue)
+	sv.once.Do(func() {
+		switch {
+		case c.replaySetting(cs, sv):
+			// recorded value of the replayed request
+		case len(cs.Processor) > 0:
+			c.runSettingProcessor(ctx, cs, sv)
+		default:
+			c.runSettingExpr(ctx, cs, sv)
+		}
+		dataContext.SetConfigSetting(cs.Name, sv.value)
+		for flag, value := range sv.flags {
+			dataContext.SetConfigSetting(cs.Name+"."+flag, value)
+		}
+		if c.trace != nil {
+			c.trace.setConfigSetting(cs.Name, sv.value)
+			for flag, value := range sv.flags {
+				c.trace.setConfigSetting(cs.Name+"."+flag, value)
+			}
+		}
+	})
+	return sv
+}
+
+func (c *ClusterContext) runSettingExpr(ctx context.Context, cs *ConfigSetting, sv *settingValue) {
+	if cs.program == nil || c.ExecuteParams == nil {
+		return
+	}
+	env := c.ExecuteParams
+	if len(cs.deps) > 0 {
+		env = env.Clone()
+		for _, dep := range cs.deps {
+			depValue := c.getSettingValue(ctx, c.Cluster.getConfigSetting(dep))
+			if depValue.flags != nil {
+				(*env)[dep] = depValue.flags
+			} else {
+				env.SetBool(dep, depValue.value)
+			}
+		}
+	}
+	output, err := runExpr(cs.program, env)
+	if err != nil {
+		log.Printf("expr name:%v expect:%v err:%v", cs.Name, output, err)
+	} else if expect, ok := output.(bool); ok {
+		sv.value = expect
+	}
+}
+
+func (c *ClusterContext) runSettingProcessor(ctx context.Context, cs *ConfigSetting, sv *settingValue) {
+	di := cs.processors.get()
+	if di == nil {
+		var err error
+		if di, err = cs.newProcessor(); err != nil {
+			log.Printf("config_setting name:%v processor:%v err:%v", cs.Name, cs.Processor, err)
+			return
+		}
+	}
+	defer func() {
+		di.Reset()
+		cs.processors.put(di)
+	}()
+	di.InjectInput(c.ExternDataContext, nil)
+	params := cs.Args.Clone()
+	if c.ExecuteParams != nil {
+		params.Set("GLOBAL", *c.ExecuteParams)
+	}
+	if err := di.Processor.OnExecute(ctx, params); err != nil {
+		log.Printf("config_setting name:%v processor:%v err:%v", cs.Name, cs.Processor, err)
+		return
+	}
+	rVal := di.processorValue()
+	for _, out := range di.getPlan().fields {
+		if out.tag != cOutput {
+			continue
+		}
+		f := rVal.FieldByIndex(out.index)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				continue
+			}
+			f = f.Elem()
+		}
+		if isSettingValue(out.typ) {
+			sv.value = f.Bool()
+		} else if isSettingFlags(out.typ) {
+			sv.flags = make(map[string]bool, f.Len())
+			iter := f.MapRange()
+			for iter.Next() {
+				sv.flags[iter.Key().String()] = iter.Value().Bool()
+			}
+		}
+	}
+}
@@ -8,6 +8,9 @@ import (
 // DataContext data for run cluster
 type DataContext struct {
 	Data sync.Map
+
+	// parent data missing in this context is read from parent
+	parent *DataContext
 }
 
 // GlobalDataContext use as global di container
@@ -18,9 +21,28 @@ func NewDataContext() *DataContext {
 	return &DataContext{}
 }
 
+// NewChildDataContext new datacontext reading missing data from parent, data set in child is invisible to parent
+func NewChildDataContext(parent *DataContext) *DataContext {
+	return &DataContext{parent: parent}
+}
+
+// root the top datacontext of a request
+func (d *DataContext) root() *DataContext {
+	for d.parent != nil {
+		d = d.parent
+	}
+	return d
+}
+
 // Get get by key
 func (d *DataContext) Get(key DIObjectKey) (interface{}, bool) {
-	return d.Data.Load(key)
+	if v, ok := d.Data.Load(key); ok {
+		return v, ok
+	}
+	if d.parent != nil {
+		return d.parent.Get(key)
+	}
+	return nil, false
 }
 
 // Set set value
@@ -28,6 +50,38 @@ func (d *DataContext) Set(key DIObjectKey, value interface{}) {
 	d.Data.Store(key, value)
 }
 
+// Delete delete by key
+func (d *DataContext) Delete(key DIObjectKey) {
+	d.Data.Delete(key)
+}
+
+// getByName get non nil data by name of any type, the first one found if there are multiple types
+func (d *DataContext) getByName(name string) (reflect.Value, bool) {
+	for ; d != nil; d = d.parent {
+		if rv, ok := d.getLocalByName(name); ok {
+			return rv, ok
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// getLocalByName get non nil data by name set in this context, parent is not read
+func (d *DataContext) getLocalByName(name string) (reflect.Value, bool) {
+	var found reflect.Value
+	d.Data.Range(func(key interface{}, value interface{}) bool {
+		k, ok := key.(DIObjectKey)
+		if !ok || k.Name != name {
+			return true
+		}
+		if rv, ok := value.(reflect.Value); ok && rv.IsValid() {
+			found = rv
+			return false
+		}
+		return true
+	})
+	return found, found.IsValid()
+}
+
 // SetConfigSetting set configsetting
 func (d *DataContext) SetConfigSetting(key string, value bool) {
 	d.Data.Store(key, value)
@@ -38,6 +92,9 @@ func (d *DataContext) GetConfigSetting(key string) bool {
 	if configSetting, ok := d.Data.Load(key); ok {
 		return configSetting.(bool)
 	}
+	if d.parent != nil {
+		return d.parent.GetConfigSetting(key)
+	}
 	return false
 }
 
@@ -1,18 +1,77 @@
 package graph
 
-import "time"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xxxx/innererror"
+)
 
 var defaultEventChan = make(chan *Event, 10000)
 
+// defaultEventDropped events dropped by full default event chan
+var defaultEventDropped uint64
+
 // GetDefaultEventChan GetDefaultEventChan
 func GetDefaultEventChan() chan *Event {
 	return defaultEventChan
 }
 
-// Event stat processor execute status
+// EventKind kind of event
+type EventKind int
+
+// event kinds
+const (
+	// EventVertex vertex finished, executed or skipped
+	EventVertex EventKind = iota
+	// EventGraphStart graph starts, subgraph calls carry the calling vertex
+	EventGraphStart
+	// EventGraphFinish graph finished
+	EventGraphFinish
+)
+
+var eventKindNames = map[EventKind]string{
+	EventVertex:      "vertex",
+	EventGraphStart:  "graph_start",
+	EventGraphFinish: "graph_finish",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Event stat graph and vertex execute status
 type Event struct {
+	Kind    EventKind
+	Time    time.Time
+	Cluster string
+	Graph   string
+	Version string
+	// Vertex Processor SubGraph of vertex event, SubGraph is 'cluster::graph' called by vertex
+	Vertex    string
 	Processor string
-	Duration  time.Duration
+	SubGraph  string
+	// Status of vertex event, skip reason of skipped vertex
+	Status VertexStatus
+	// Err error of failed vertex or graph, condition error of skipped vertex
+	Err      error
+	Code     int32
+	Duration time.Duration
+	// QueueWait time from vertex ready to vertex start
+	QueueWait time.Duration
+	Attempts  int
+	Fallback  bool
+	// ExecutionID id of the graph execution, unique in process
+	ExecutionID uint64
+	// ParentID ParentVertex execution and vertex calling the subgraph, 0 and empty for the entry graph
+	ParentID     uint64
+	ParentVertex string
+	// Depth nesting depth of subgraph calls
+	Depth int
 }
 
 // AddEvent add event no block
@@ -20,5 +79,189 @@ func AddEvent(e *Event) {
 	select {
 	case defaultEventChan <- e:
 	default:
+		atomic.AddUint64(&defaultEventDropped, 1)
+	}
+}
+
+// DroppedEvents number of events dropped by full default event chan
+func DroppedEvents() uint64 {
+	return atomic.LoadUint64(&defaultEventDropped)
+}
+
+// EventSink receive events of a manager, OnEvent is called on the executing goroutine and must not block
+type EventSink interface {
+	OnEvent(e *Event)
+}
+
+// droppedCounter sink dropping events
+type droppedCounter interface {
+	Dropped() uint64
+}
+
+// EventSinkFunc callback as event sink
+type EventSinkFunc func(e *Event)
+
+// OnEvent call f
+func (f EventSinkFunc) OnEvent(e *Event) {
+	f(e)
+}
+
+// ChannelSink send events to a buffered chan, drop when full
+type ChannelSink struct {
+	c       chan *Event
+	dropped uint64
+}
+
+// NewChannelSink create channel sink with buffer size
+func NewChannelSink(size int) *ChannelSink {
+	return &ChannelSink{c: make(chan *Event, size)}
+}
+
+// C events chan
+func (s *ChannelSink) C() <-chan *Event {
+	return s.c
+}
+
+// OnEvent send event no block
+func (s *ChannelSink) OnEvent(e *Event) {
+	select {
+	case s.c <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped number of events dropped by full chan
+func (s *ChannelSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// RingBufferSink keep the latest events, older ones are overwritten
+type RingBufferSink struct {
+	events  []*Event
+	next    int
+	full    bool
+	dropped uint64
+	lock    sync.Mutex
+}
+
+// NewRingBufferSink create ring buffer keeping size latest events
+func NewRingBufferSink(size int) *RingBufferSink {
+	return &RingBufferSink{events: make([]*Event, size)}
+}
+
+// OnEvent keep event, overwrite the oldest one when full
+func (s *RingBufferSink) OnEvent(e *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.events) == 0 {
+		s.dropped++
+		return
+	}
+	if s.full {
+		s.dropped++
+	}
+	s.events[s.next] = e
+	s.next = (s.next + 1) % len(s.events)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// Events kept events from the oldest to the latest
+func (s *RingBufferSink) Events() []*Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.full {
+		return append([]*Event(nil), s.events[:s.next]...)
+	}
+	return append(append([]*Event(nil), s.events[s.next:]...), s.events[:s.next]...)
+}
+
+// Dropped number of events overwritten
+func (s *RingBufferSink) Dropped() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dropped
+}
+
+// AddEventSink add sinks receiving all events of the manager
+func (m *Manager) AddEventSink(sinks ...EventSink) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	// copy on write, emitting reads sinks without lock
+	m.sinks = append(append([]EventSink(nil), m.sinks...), sinks...)
+}
+
+// DroppedEvents number of events dropped by sinks of the manager
+func (m *Manager) DroppedEvents() uint64 {
+	var dropped uint64
+	for _, s := range m.getEventSinks() {
+		if c, ok := s.(droppedCounter); ok {
+			dropped += c.Dropped()
+		}
+	}
+	return dropped
+}
+
+func (m *Manager) getEventSinks() []EventSink {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.sinks
+}
+
+// executionSeq last execution id
+var executionSeq uint64
+
+// emitEvent send event of cluster context to sinks of manager, processor events also to default chan
+func (c *ClusterContext) emitEvent(e *Event) {
+	e.Time = time.Now()
+	e.Cluster = c.Cluster.Name
+	e.ExecutionID = c.executionID
+	e.ParentID = c.parentID
+	e.ParentVertex = c.parentVertex
+	e.Depth = c.depth
+	if e.Kind == EventVertex && len(e.Processor) > 0 && !e.Status.IsSkipped() {
+		AddEvent(e)
+	}
+	if m := c.Cluster.GraphManager; m != nil {
+		for _, s := range m.getEventSinks() {
+			s.OnEvent(e)
+		}
+	}
+}
+
+// emitGraphEvent send graph start or finish event
+func (c *Context) emitGraphEvent(kind EventKind, duration time.Duration, err error) {
+	c.ClusterContext.emitEvent(&Event{
+		Kind:     kind,
+		Graph:    c.Graph.Name,
+		Version:  c.Graph.ExpectVersion,
+		Err:      err,
+		Code:     innererror.Code(err),
+		Duration: duration,
+	})
+}
+
+// emitVertexEvent send event of finished vertex
+func (v *VertexContext) emitVertexEvent() {
+	vr := v.getResult()
+	e := &Event{
+		Kind:      EventVertex,
+		Graph:     v.GraphContext.Graph.Name,
+		Version:   v.GraphContext.Graph.ExpectVersion,
+		Vertex:    v.Vertex.ID,
+		Processor: v.Vertex.Processor,
+		Status:    vr.Status,
+		Err:       vr.Err,
+		Code:      vr.Code,
+		Duration:  vr.Duration,
+		QueueWait: v.result.queueWait,
+		Attempts:  vr.Attempts,
+		Fallback:  vr.Fallback,
+	}
+	if len(v.Vertex.Graph) > 0 {
+		e.SubGraph = v.Vertex.Cluster + "::" + v.Vertex.Graph
 	}
+	v.GraphContext.ClusterContext.emitEvent(e)
 }
@@ -1,6 +1,12 @@
 package graph
 
-import "testing"
+import (
+	"context"
+	"testing"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/dagengine/engine/processor"
+)
 
 func TestAddEvent(t *testing.T) {
 	type args struct {
@@ -24,3 +30,90 @@ func TestAddEvent(t *testing.T) {
 		})
 	}
 }
+
+func TestManager_EventSinks(t *testing.T) {
+	type wantEvent struct {
+		kind         EventKind
+		graph        string
+		vertex       string
+		status       VertexStatus
+		subGraph     string
+		parentVertex string
+		depth        int
+	}
+	want := []wantEvent{
+		{kind: EventGraphStart, graph: "enter"},
+		{kind: EventVertex, graph: "enter", vertex: "a", status: VertexOk},
+		{kind: EventVertex, graph: "enter", vertex: "b", status: VertexSkippedByDeps},
+		{kind: EventGraphStart, graph: "sub", parentVertex: "c", depth: 1},
+		{kind: EventVertex, graph: "sub", vertex: "x", status: VertexOk, parentVertex: "c", depth: 1},
+		{kind: EventGraphFinish, graph: "sub", parentVertex: "c", depth: 1},
+		{kind: EventVertex, graph: "enter", vertex: "c", status: VertexOk, subGraph: "event_test.toml::sub"},
+		{kind: EventGraphFinish, graph: "enter"},
+	}
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	m := New()
+	if err := m.loadFile("../../cmd/event_test.toml", &TomlCodec{}); err != nil {
+		t.Fatalf("Manager.loadFile() error = %v", err)
+	}
+	var events []*Event
+	channel := NewChannelSink(1)
+	ring := NewRingBufferSink(3)
+	m.AddEventSink(EventSinkFunc(func(e *Event) {
+		events = append(events, e)
+	}), channel)
+	m.AddEventSink(ring)
+	for len(GetDefaultEventChan()) > 0 {
+		<-GetDefaultEventChan()
+	}
+	// step mode emits events in deterministic order
+	if _, err := m.execute(context.Background(), "event_test.toml", "enter", NewDataContext(),
+		&param.Params{}, nil, WithStep(&StepHooks{})); err != nil {
+		t.Fatalf("Manager.execute() error = %v", err)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("EventSinkFunc events = %d, want %d", len(events), len(want))
+	}
+	entryID := events[0].ExecutionID
+	for i, e := range events {
+		w := want[i]
+		got := wantEvent{kind: e.Kind, graph: e.Graph, vertex: e.Vertex, status: e.Status,
+			subGraph: e.SubGraph, parentVertex: e.ParentVertex, depth: e.Depth}
+		if got != w {
+			t.Errorf("event %d = %+v, want %+v", i, got, w)
+		}
+		if e.Cluster != "event_test.toml" || e.Time.IsZero() {
+			t.Errorf("event %d = %+v, want cluster and time", i, e)
+		}
+		if w.depth == 0 && (e.ExecutionID != entryID || e.ParentID != 0) {
+			t.Errorf("event %d execution = %d/%d, want %d/0", i, e.ExecutionID, e.ParentID, entryID)
+		}
+		if w.depth == 1 && (e.ExecutionID == entryID || e.ParentID != entryID) {
+			t.Errorf("event %d execution = %d/%d, want child of %d", i, e.ExecutionID, e.ParentID, entryID)
+		}
+	}
+	if events[1].Duration <= 0 || events[7].Duration < events[1].Duration {
+		t.Errorf("event durations = %v/%v, want vertex within graph", events[1].Duration, events[7].Duration)
+	}
+	if events[2].Err == nil || events[2].Code == 0 {
+		t.Errorf("skipped event = %+v, want skip reason", events[2])
+	}
+	if got := <-channel.C(); got != events[0] || channel.Dropped() != uint64(len(want)-1) {
+		t.Errorf("ChannelSink first = %+v dropped = %d, want %d", got, channel.Dropped(), len(want)-1)
+	}
+	kept := ring.Events()
+	if len(kept) != 3 || kept[0] != events[5] || kept[2] != events[7] || ring.Dropped() != uint64(len(want)-3) {
+		t.Errorf("RingBufferSink events = %v dropped = %d, want latest 3", kept, ring.Dropped())
+	}
+	if got := m.DroppedEvents(); got != channel.Dropped()+ring.Dropped() {
+		t.Errorf("Manager.DroppedEvents() = %d, want %d", got, channel.Dropped()+ring.Dropped())
+	}
+	// default chan keeps processor events only
+	var processors []string
+	for len(GetDefaultEventChan()) > 0 {
+		processors = append(processors, (<-GetDefaultEventChan()).Vertex)
+	}
+	if len(processors) != 2 || processors[0] != "a" || processors[1] != "x" {
+		t.Errorf("GetDefaultEventChan() vertexes = %v, want [a x]", processors)
+	}
+}
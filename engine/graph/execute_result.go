@@ -0,0 +1,166 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// VertexStatus vertex execute status
+type VertexStatus int
+
+// vertex execute status
+const (
+	VertexNotRun VertexStatus = iota
+	VertexSkippedByDeps
+	VertexSkippedByExpect
+	VertexSkippedByExpectConfig
+	VertexSkippedByCond
+	VertexSkippedByRequired
+	VertexSkippedByCancel
+	VertexOk
+	VertexErr
+	VertexPanic
+	VertexTimeout
+	VertexCancelled
+)
+
+// result codes of vertexes interrupted by context
+const (
+	ResultCodeTimeout   = -10001
+	ResultCodeCancelled = -10002
+	// ResultCodeRequiredMissing vertex skipped because a required input is not produced
+	ResultCodeRequiredMissing = -10003
+)
+
+var vertexStatusNames = map[VertexStatus]string{
+	VertexNotRun:                "not_run",
+	VertexSkippedByDeps:         "skipped_by_deps",
+	VertexSkippedByExpect:       "skipped_by_expect",
+	VertexSkippedByExpectConfig: "skipped_by_expect_config",
+	VertexSkippedByCond:         "skipped_by_cond",
+	VertexSkippedByRequired:     "skipped_by_required",
+	VertexSkippedByCancel:       "skipped_by_cancel",
+	VertexOk:                    "ok",
+	VertexErr:                   "err",
+	VertexPanic:                 "panic",
+	VertexTimeout:               "timeout",
+	VertexCancelled:             "cancelled",
+}
+
+func (s VertexStatus) String() string {
+	if name, ok := vertexStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// parseVertexStatus status by name, VertexNotRun if unknown
+func parseVertexStatus(name string) VertexStatus {
+	for s, n := range vertexStatusNames {
+		if n == name {
+			return s
+		}
+	}
+	return VertexNotRun
+}
+
+// IsSkipped vertex not executed because of condition
+func (s VertexStatus) IsSkipped() bool {
+	return s >= VertexSkippedByDeps && s <= VertexSkippedByCancel
+}
+
+// IsFailed vertex executed but failed
+func (s VertexStatus) IsFailed() bool {
+	return s >= VertexErr && s <= VertexCancelled
+}
+
+// graph fail policy
+const (
+	FailPolicyNever    = "never"
+	FailPolicyAny      = "any"
+	FailPolicyCritical = "critical"
+)
+
+func isValidFailPolicy(policy string) bool {
+	switch policy {
+	case "", FailPolicyNever, FailPolicyAny, FailPolicyCritical:
+		return true
+	}
+	return false
+}
+
+// VertexResult one vertex result of an execution
+type VertexResult struct {
+	ID        string
+	Processor string
+	Status    VertexStatus
+	Code      int32
+	Err       error
+	Duration  time.Duration
+	Critical  bool
+	SubGraph  *ExecuteResult
+	Attempts  int
+	Fallback  bool
+	// Elements number of elements of a foreach vertex, FailedElements number of failed ones
+	Elements       int
+	FailedElements int
+	// DroppedElements indexes of elements dropped by fail_policy drop, zero values in the gathered slice
+	DroppedElements []int
+}
+
+// ExecuteResult graph execution report
+type ExecuteResult struct {
+	Cluster   string
+	Graph     string
+	Version   string
+	Vertexes  map[string]*VertexResult
+	Terminals []string
+	Err       error
+}
+
+// SubGraphName 'cluster::graph' of subgraph called by vertex, empty if not called
+func (vr *VertexResult) SubGraphName() string {
+	if vr.SubGraph == nil {
+		return ""
+	}
+	return vr.SubGraph.Cluster + "::" + vr.SubGraph.Graph
+}
+
+// Failed return failed vertexes sorted by id
+func (r *ExecuteResult) Failed() []*VertexResult {
+	var failed []*VertexResult
+	for _, vr := range r.Vertexes {
+		if vr.Status.IsFailed() {
+			failed = append(failed, vr)
+		}
+	}
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].ID < failed[j].ID
+	})
+	return failed
+}
+
+func (r *ExecuteResult) checkFailPolicy(policy string) error {
+	var failed []*VertexResult
+	switch policy {
+	case FailPolicyAny:
+		failed = r.Failed()
+	case FailPolicyCritical:
+		for _, vr := range r.Failed() {
+			if vr.Critical {
+				failed = append(failed, vr)
+			}
+		}
+	default:
+		return nil
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(failed))
+	for _, vr := range failed {
+		ids = append(ids, vr.ID)
+	}
+	return fmt.Errorf("graph:%s/%s failed vertexes:%v first err:%w", r.Cluster, r.Graph, ids, failed[0].Err)
+}
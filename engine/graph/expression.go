@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"xxxx/dagengine/engine/param"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+)
+
+// compileExpr compile expect/cond/config_setting expression once at build time,
+// variables are execute params and RET_CODE_ variables which are only known at runtime,
+// strict_dsl clusters check them against declared params by checkExpr
+func compileExpr(code string) (*vm.Program, error) {
+	return expr.Compile(code, expr.Env(param.Params{}), expr.AllowUndefinedVariables())
+}
+
+// paramTypes zero values of types declared by cluster params
+var paramTypes = map[string]interface{}{
+	"bool":   false,
+	"int":    int64(0),
+	"float":  float64(0),
+	"string": "",
+	"list":   []interface{}{},
+	"map":    map[string]interface{}{},
+}
+
+// checkExpr type check expression against declared variables, undefined variables are errors
+func checkExpr(code string, env map[string]interface{}) error {
+	if _, err := expr.Compile(code, expr.Env(env)); err != nil {
+		// keep the position only, strict_dsl reports one problem per line
+		msg, _, _ := strings.Cut(err.Error(), "\n")
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// buildParams check types of declared params
+func (c *Cluster) buildParams() error {
+	c.paramEnv = make(map[string]interface{}, len(c.Params))
+	for name, typ := range c.Params {
+		zero, ok := paramTypes[typ]
+		if !ok {
+			return fmt.Errorf("param:%s invalid type:%s", name, typ)
+		}
+		c.paramEnv[name] = zero
+	}
+	return nil
+}
+
+// exprEnv declared params with variables set by engine
+func (c *Cluster) exprEnv(vars map[string]interface{}) map[string]interface{} {
+	env := make(map[string]interface{}, len(c.paramEnv)+len(vars))
+	for name, zero := range c.paramEnv {
+		env[name] = zero
+	}
+	for name, zero := range vars {
+		env[name] = zero
+	}
+	return env
+}
+
+// runExpr run compiled expression with params
+func runExpr(program *vm.Program, params *param.Params) (interface{}, error) {
+	return expr.Run(program, *params)
+}
@@ -0,0 +1,408 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/innererror"
+)
+
+// foreach fail policy
+const (
+	ForeachFailAll = "fail_all"
+	ForeachDrop    = "drop"
+)
+
+// foreach gather type of per element outputs
+const (
+	ForeachGatherSlice = "slice"
+	ForeachGatherMap   = "map"
+)
+
+const defaultForeachConcurrency = 4
+
+func (f *ForeachPolicy) getConcurrency() int {
+	if f.Concurrency > 0 {
+		return f.Concurrency
+	}
+	return defaultForeachConcurrency
+}
+
+// gatherType type of gathered output with per element output type t, nil if the map key type is unknown
+func (f *ForeachPolicy) gatherType(keyType reflect.Type, t reflect.Type) reflect.Type {
+	if f.Gather != ForeachGatherMap {
+		return reflect.SliceOf(t)
+	}
+	if keyType == nil {
+		return nil
+	}
+	return reflect.MapOf(keyType, t)
+}
+
+// keyType map key type of elements of elemType, the element itself or its key field
+func (f *ForeachPolicy) keyType(elemType reflect.Type) (reflect.Type, error) {
+	t := elemType
+	if len(f.Key) > 0 {
+		st := GetNoPtrType(elemType)
+		if st.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("foreach key:%s element type:%v is not a struct", f.Key, elemType)
+		}
+		field, ok := st.FieldByName(f.Key)
+		if !ok || !field.IsExported() {
+			return nil, fmt.Errorf("foreach key:%s is not an exported field of element type:%v", f.Key, elemType)
+		}
+		t = field.Type
+	}
+	if !t.Comparable() {
+		return nil, fmt.Errorf("foreach gather key type:%v is not comparable", t)
+	}
+	return t, nil
+}
+
+// keyOf map key of element
+func (f *ForeachPolicy) keyOf(element reflect.Value) (reflect.Value, error) {
+	if len(f.Key) > 0 {
+		for element.Kind() == reflect.Ptr || element.Kind() == reflect.Interface {
+			if element.IsNil() {
+				return reflect.Value{}, fmt.Errorf("foreach key:%s of nil element", f.Key)
+			}
+			element = element.Elem()
+		}
+		element = element.FieldByName(f.Key)
+	}
+	if !element.Comparable() {
+		return reflect.Value{}, fmt.Errorf("foreach gather key:%v is not comparable", element)
+	}
+	return element, nil
+}
+
+// buildForeach check foreach policy and depend on the producer of foreach input
+func (v *Vertex) buildForeach() error {
+	f := v.Foreach
+	if f == nil {
+		return nil
+	}
+	if len(v.Cond) > 0 || (len(v.Processor) == 0 && len(v.Graph) == 0) {
+		return fmt.Errorf("Vertex:%s/%s foreach only support processor or subgraph vertex", v.g.Name, v.getDotLabel())
+	}
+	if len(f.Input) == 0 {
+		return fmt.Errorf("Vertex:%s/%s foreach input is empty", v.g.Name, v.ID)
+	}
+	if f.Concurrency < 0 {
+		return fmt.Errorf("Vertex:%s/%s invalid foreach concurrency:%d", v.g.Name, v.ID, f.Concurrency)
+	}
+	switch f.FailPolicy {
+	case "", ForeachFailAll, ForeachDrop:
+	default:
+		return fmt.Errorf("Vertex:%s/%s invalid foreach fail_policy:%s", v.g.Name, v.ID, f.FailPolicy)
+	}
+	switch f.Gather {
+	case "", ForeachGatherSlice, ForeachGatherMap:
+	default:
+		return fmt.Errorf("Vertex:%s/%s invalid foreach gather:%s", v.g.Name, v.ID, f.Gather)
+	}
+	if len(f.Key) > 0 && f.Gather != ForeachGatherMap {
+		return fmt.Errorf("Vertex:%s/%s foreach key is only supported by gather map", v.g.Name, v.ID)
+	}
+	if v.fallbackVertex != nil || len(v.FallbackArgs) > 0 {
+		return fmt.Errorf("Vertex:%s/%s foreach vertex can NOT config fallback", v.g.Name, v.ID)
+	}
+	dep := v.g.getVertexByData(f.Input)
+	if dep == nil {
+		// read from data context
+		return nil
+	}
+	if dep == v {
+		return fmt.Errorf("Vertex:%s/%s foreach input:%s is produced by itself", v.g.Name, v.ID, f.Input)
+	}
+	outType := dep.getFieldType(dep.getOutputField(f.Input), true)
+	if outType != nil {
+		if GetNoPtrType(outType).Kind() != reflect.Slice {
+			return fmt.Errorf("Vertex:%s/%s foreach input:%s type:%v of vertex:%s is not a slice",
+				v.g.Name, v.ID, f.Input, outType, dep.ID)
+		}
+		v.foreachType = outType
+		if f.Gather == ForeachGatherMap {
+			keyType, err := f.keyType(GetNoPtrType(outType).Elem())
+			if err != nil {
+				return fmt.Errorf("Vertex:%s/%s %w", v.g.Name, v.ID, err)
+			}
+			v.foreachKeyType = keyType
+		}
+	}
+	v.depend(dep, innererror.VResultAll)
+	return nil
+}
+
+// newForeachWorkers processor contexts running elements concurrently
+func (v *VertexContext) newForeachWorkers() error {
+	for i := 0; i < v.Vertex.Foreach.getConcurrency(); i++ {
+		worker := &VertexContext{GraphContext: v.GraphContext, Vertex: v.Vertex, Params: v.Params, abandonable: v.abandonable}
+		if err := worker.initProcessor(); err != nil {
+			return err
+		}
+		worker.result = new(vertexResult)
+		v.workers = append(v.workers, worker)
+	}
+	return nil
+}
+
+// getForeachElements slice data of foreach input
+func (v *VertexContext) getForeachElements(dataContext *DataContext) (reflect.Value, error) {
+	cc := v.GraphContext.ClusterContext
+	name, err := resolveVariable(v.Vertex.Foreach.Input, cc.Scope)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("vertex:%s foreach input %w", v.Vertex.ID, err)
+	}
+	var rv reflect.Value
+	if t := v.Vertex.foreachType; t != nil {
+		if data, ok := dataContext.Get(NewDIObjectKey(name, t)); ok {
+			rv, _ = data.(reflect.Value)
+		}
+	} else {
+		rv, _ = dataContext.getByName(name)
+	}
+	if rv.IsValid() && rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return rv, fmt.Errorf("vertex:%s foreach input:%s is nil", v.Vertex.ID, name)
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || rv.Kind() != reflect.Slice {
+		return rv, fmt.Errorf("vertex:%s foreach input:%s is not a produced slice", v.Vertex.ID, name)
+	}
+	return rv, nil
+}
+
+// elementResult outputs of one element
+type elementResult struct {
+	outputs  map[string]reflect.Value
+	err      error
+	attempts int
+}
+
+// ExecuteForeach execute processor or subgraph once per element of foreach input and gather outputs
+func (v *VertexContext) ExecuteForeach(ctx context.Context) error {
+	start := time.Now()
+	var attempts int
+	defer func() {
+		v.result.duration = time.Since(start)
+	}()
+	dataContext := v.GraphContext.ExternDataContext
+	elements, err := v.getForeachElements(dataContext)
+	if err != nil {
+		v.result.processorResult = err
+		return err
+	}
+	var params *param.Params
+	if v.Processor != nil {
+		params = v.getSelectedParams(ctx)
+	}
+	n := elements.Len()
+	results := make([]elementResult, n)
+	concurrency := v.Vertex.Foreach.getConcurrency()
+	if concurrency > n {
+		concurrency = n
+	}
+	if v.GraphContext.ClusterContext.step != nil && concurrency > 1 {
+		// elements one by one in step mode
+		concurrency = 1
+	}
+	next := int32(-1)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	tasks := make([]Task, concurrency)
+	for w := range tasks {
+		var worker *VertexContext
+		if v.Processor != nil {
+			worker = v.workers[w]
+		}
+		// elements calling a subgraph wait for its vertexes
+		tasks[w].Waiting = worker == nil
+		tasks[w].Run = func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt32(&next, 1))
+				if i >= n {
+					return
+				}
+				if ctx.Err() != nil {
+					results[i].err = ctx.Err()
+					continue
+				}
+				results[i] = v.executeElement(ctx, worker, dataContext, elements.Index(i), i, params)
+			}
+		}
+	}
+	if v.GraphContext.ClusterContext.step != nil {
+		tasks[0].Run()
+	} else {
+		v.GraphContext.getScheduler().Schedule(v.GraphContext, tasks)
+	}
+	wg.Wait()
+	v.result.elements = n
+	for i := range results {
+		attempts += results[i].attempts
+		if results[i].err != nil {
+			v.result.failedElements++
+			if v.Vertex.Foreach.FailPolicy == ForeachDrop {
+				v.result.droppedElements = append(v.result.droppedElements, i)
+			}
+		}
+	}
+	v.result.attempts = attempts
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if v.Vertex.Foreach.FailPolicy != ForeachDrop {
+		for i := range results {
+			if results[i].err != nil {
+				v.result.processorResult = fmt.Errorf("vertex:%s foreach element:%d err:%w",
+					v.Vertex.ID, i, results[i].err)
+				return v.result.processorResult
+			}
+		}
+	}
+	if v.ProcessorDI != nil {
+		v.ProcessorDI.RemoveMovedInput(dataContext, v.Vertex.Input)
+	}
+	if err := v.gatherOutputs(dataContext, elements, results); err != nil {
+		v.result.processorResult = err
+		return err
+	}
+	return nil
+}
+
+// executeElement execute one element with a child data context holding the element and its index
+func (v *VertexContext) executeElement(ctx context.Context, worker *VertexContext, dataContext *DataContext,
+	element reflect.Value, index int, params *param.Params) (r elementResult) {
+	defer func() {
+		if p := recover(); p != nil {
+			r.err = fmt.Errorf("vertex:%v element:%d panic:%v", v.Vertex.getDotLabel(), index, p)
+		}
+	}()
+	child := NewChildDataContext(dataContext)
+	child.Set(foreachElementKey, element)
+	child.Set(foreachIndexKey, reflect.ValueOf(index))
+	if worker == nil {
+		scope, err := v.getSubGraphScope()
+		if err != nil {
+			r.err = err
+			return r
+		}
+		(*scope)["ELEMENT"] = element.Interface()
+		scope.SetInt64("INDEX", int64(index))
+		r.attempts = 1
+		_, r.err = v.runSubGraph(ctx, child, scope, index)
+		if r.err != nil {
+			return r
+		}
+		r.outputs = make(map[string]reflect.Value, len(v.Vertex.Output))
+		for _, data := range v.Vertex.Output {
+			field, err := resolveVariable(data.Field, scope)
+			if err != nil {
+				r.err = err
+				return r
+			}
+			if rv, ok := child.getLocalByName(field); ok {
+				r.outputs[data.Field] = rv
+			}
+		}
+		return r
+	}
+	worker.dataContext = child
+	worker.result = new(vertexResult)
+	r.err = worker.executeWithRetry(ctx, params)
+	r.attempts = worker.result.attempts
+	if r.err != nil {
+		return r
+	}
+	rVal := worker.ProcessorDI.processorValue()
+	r.outputs = make(map[string]reflect.Value, len(worker.ProcessorDI.outputs))
+	for _, out := range worker.ProcessorDI.outputs {
+		// copy so the next element does not overwrite it
+		r.outputs[out.name] = reflect.ValueOf(rVal.FieldByIndex(out.index).Interface())
+	}
+	return r
+}
+
+// gatherOutputs publish per element outputs as a slice in element order, failed elements keep
+// their position with zero value, or as a map keyed by element of succeeded elements
+func (v *VertexContext) gatherOutputs(dataContext *DataContext, elements reflect.Value, results []elementResult) error {
+	cc := v.GraphContext.ClusterContext
+	publish := v.outputFilter(v.Vertex.Output)
+	policy := v.Vertex.Foreach
+	keyType := v.Vertex.foreachKeyType
+	if policy.Gather == ForeachGatherMap && keyType == nil {
+		var err error
+		if keyType, err = policy.keyType(elements.Type().Elem()); err != nil {
+			return fmt.Errorf("vertex:%s %w", v.Vertex.ID, err)
+		}
+	}
+	for _, data := range v.Vertex.Output {
+		if publish != nil && !publish(data.Field) {
+			continue
+		}
+		var elemType reflect.Type
+		name, err := resolveVariable(data.ID, cc.Scope)
+		if err != nil {
+			return fmt.Errorf("vertex:%s output %w", v.Vertex.ID, err)
+		}
+		if v.ProcessorDI != nil {
+			id, ok := v.ProcessorDI.OutputIDs[data.Field]
+			if !ok {
+				continue
+			}
+			name = id.Name
+			if f, ok := v.ProcessorDI.getPlan().byName[data.Field]; ok {
+				elemType = f.typ
+			}
+		}
+		for i := range results {
+			if rv, ok := results[i].outputs[data.Field]; ok && elemType == nil {
+				elemType = rv.Type()
+			}
+		}
+		if elemType == nil {
+			// subgraph produced nothing, type unknown
+			continue
+		}
+		gathered := policy.gatherType(keyType, elemType)
+		var out reflect.Value
+		if policy.Gather == ForeachGatherMap {
+			out = reflect.MakeMapWithSize(gathered, len(results))
+		} else {
+			out = reflect.MakeSlice(gathered, len(results), len(results))
+		}
+		keys := make(map[interface{}]bool, len(results))
+		for i := range results {
+			var key reflect.Value
+			if policy.Gather == ForeachGatherMap {
+				var err error
+				if key, err = policy.keyOf(elements.Index(i)); err != nil {
+					return fmt.Errorf("vertex:%s element:%d %w", v.Vertex.ID, i, err)
+				}
+				if keys[key.Interface()] {
+					return fmt.Errorf("vertex:%s element:%d duplicate foreach gather key:%v", v.Vertex.ID, i, key)
+				}
+				keys[key.Interface()] = true
+			}
+			rv, ok := results[i].outputs[data.Field]
+			if results[i].err != nil || !ok || !rv.Type().AssignableTo(elemType) {
+				continue
+			}
+			if policy.Gather == ForeachGatherMap {
+				out.SetMapIndex(key, rv)
+			} else {
+				out.Index(i).Set(rv)
+			}
+		}
+		dataContext.Set(NewDIObjectKey(name, gathered), out)
+	}
+	return nil
+}
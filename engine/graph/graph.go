@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
+
+	"github.com/antonmedv/expr/vm"
 )
 
 // Graph graph detail struct
@@ -12,35 +15,51 @@ type Graph struct {
 	Vertex        []Vertex `toml:"vertex" json:"vertex"`
 	ExpectVersion string   `toml:"expect_version" json:"expect_version"`
 	Priority      int      `toml:"priority" json:"priority"`
+	VersionCond   string   `toml:"version_cond" json:"version_cond"`
+	Weight        int      `toml:"weight" json:"weight"`
+	SplitKey      string   `toml:"split_key" json:"split_key"`
+	FailPolicy    string   `toml:"fail_policy" json:"fail_policy"`
+	Deadline      string   `toml:"deadline" json:"deadline"`
 
 	cluster     *Cluster
 	vertexMap   map[string]*Vertex
 	dataMapping map[string]*Vertex
+	deadline    time.Duration
+
+	versionProgram *vm.Program
 
 	genIdx int
 }
 
 // DumpDot dump graph dot
 func (g *Graph) DumpDot(buffer *strings.Builder) {
+	g.dumpDot(buffer, nil)
+}
+
+func (g *Graph) dumpDot(buffer *strings.Builder, r *dotResult) {
 	buffer.WriteString("  subgraph cluster_")
-	buffer.WriteString(g.Name)
+	buffer.WriteString(g.getDotName())
 	buffer.WriteString("{\n")
 	buffer.WriteString("    style = rounded;\n")
-	buffer.WriteString(fmt.Sprintf("    label = \"%s\";\n", g.Name))
+	if len(g.ExpectVersion) > 0 {
+		buffer.WriteString(fmt.Sprintf("    label = \"%s version:%s\";\n", g.Name, g.ExpectVersion))
+	} else {
+		buffer.WriteString(fmt.Sprintf("    label = \"%s\";\n", g.Name))
+	}
 	buffer.WriteString("    ")
-	buffer.WriteString(g.Name + "__START__")
+	buffer.WriteString(g.getDotName() + "__START__")
 	buffer.WriteString("[color=black fillcolor=deepskyblue style=filled shape=Msquare label=\"START\"];\n")
 	buffer.WriteString("    ")
-	buffer.WriteString(g.Name + "__STOP__")
+	buffer.WriteString(g.getDotName() + "__STOP__")
 	buffer.WriteString("[color=black fillcolor=deepskyblue style=filled shape=Msquare label=\"STOP\"];\n")
 
 	for _, v := range g.vertexMap {
-		v.dumpDotDefine(buffer)
+		v.dumpDotDefine(buffer, r)
 	}
 
 	for _, c := range g.cluster.ConfigSetting {
 		buffer.WriteString("    ")
-		buffer.WriteString(g.Name + "_" + c.Name)
+		buffer.WriteString(g.getDotName() + "_" + c.Name)
 		buffer.WriteString(" [label=\"")
 		buffer.WriteString(c.Name)
 		buffer.WriteString("\"")
@@ -51,11 +70,25 @@ func (g *Graph) DumpDot(buffer *strings.Builder) {
 		if v.isGenerated {
 			continue
 		}
-		v.dumpDotEdge(buffer)
+		v.dumpDotEdge(buffer, r)
 	}
 	buffer.WriteString("};\n")
 }
 
+// getDotName dot id prefix of graph, versions of one graph are rendered separately
+func (g *Graph) getDotName() string {
+	if len(g.ExpectVersion) == 0 {
+		return g.Name
+	}
+	version := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, g.ExpectVersion)
+	return g.Name + "_v" + version
+}
+
 func (g *Graph) genVertexID() string {
 	id := fmt.Sprintf("%s_%d", g.Name, g.genIdx)
 	g.genIdx++
@@ -116,64 +149,180 @@ func (g *Graph) buildVertexMap() error {
 			return fmt.Errorf("Duplcate vertex id:%s", v.ID)
 		}
 		v.g = g
+		v.index = i
 		g.vertexMap[v.ID] = v
 	}
+	return g.buildFallback()
+}
+
+// buildFallback mark fallback vertexes, they are only executed inside the primary vertex
+func (g *Graph) buildFallback() error {
+	for i := range g.Vertex {
+		v := &g.Vertex[i]
+		if len(v.Fallback) == 0 {
+			continue
+		}
+		if len(v.Processor) == 0 {
+			return fmt.Errorf("Vertex:%s/%s fallback only support processor vertex", g.Name, v.ID)
+		}
+		if len(v.FallbackArgs) > 0 {
+			return fmt.Errorf("Vertex:%s/%s can NOT both config 'fallback' & 'fallback_args'", g.Name, v.ID)
+		}
+		fallback := g.getVertexByID(v.Fallback)
+		if fallback == nil || fallback == v {
+			return fmt.Errorf("Vertex:%s/%s invalid fallback vertex:%s", g.Name, v.ID, v.Fallback)
+		}
+		if len(fallback.Processor) == 0 || fallback.isFallback || len(fallback.Fallback) > 0 {
+			return fmt.Errorf("Vertex:%s/%s fallback vertex:%s must be a processor vertex used only once",
+				g.Name, v.ID, v.Fallback)
+		}
+		if fallback.Start || len(fallback.Expect) > 0 || len(fallback.ExpectConfig) > 0 || fallback.Foreach != nil ||
+			len(fallback.Deps)+len(fallback.DepsOnOk)+len(fallback.DepsOnErr) > 0 ||
+			len(fallback.Successor)+len(fallback.SuccessorOnOk)+len(fallback.SuccessorOnErr) > 0 {
+			return fmt.Errorf("Vertex:%s/%s fallback vertex:%s can NOT config start/expect/deps/successors/foreach",
+				g.Name, v.ID, v.Fallback)
+		}
+		fallback.isFallback = true
+		v.fallbackVertex = fallback
+	}
 	return nil
 }
 
-func (g *Graph) buildInputOutput() error {
+func (g *Graph) buildInputOutput(errs *buildErrors) error {
 	g.dataMapping = make(map[string]*Vertex)
 	for i := range g.Vertex {
 		v := &g.Vertex[i]
-		if err := v.buildInputOutput(); err != nil {
-			return err
+		if errs.add(v.buildInputOutput()) {
+			return errs.err()
 		}
-		if err := v.checkAndFitUnit(v.Input); err != nil {
-			return err
+		if errs.add(v.checkAndFitUnit(v.Input)) || errs.add(v.checkAndFitUnit(v.Output)) {
+			return errs.err()
 		}
-		if err := v.checkAndFitUnit(v.Output); err != nil {
-			return err
+		if v.isFallback {
+			continue
 		}
 		for idx := range v.Output {
 			data := &v.Output[idx]
 			if prev, exist := g.dataMapping[data.ID]; exist {
-				return fmt.Errorf("Duplicate data name:%s in vertex:%s/%s, prev vertex:%s",
-					data.ID, v.g.Name, v.getDotLabel(), prev.getDotLabel())
+				if errs.add(fmt.Errorf("Duplicate data name:%s in vertex:%s/%s, prev vertex:%s",
+					data.ID, v.g.Name, v.getDotLabel(), prev.getDotLabel())) {
+					return errs.err()
+				}
+				continue
 			}
 			g.dataMapping[data.ID] = v
 		}
 	}
+	for _, v := range g.sortedVertexes() {
+		if v.fallbackVertex != nil {
+			if errs.add(v.fallbackVertex.bindFallbackOutput(v)) {
+				return errs.err()
+			}
+		}
+	}
 	return nil
 }
 
-// Build build graph
+// Build build graph, graphs of strict_dsl clusters report all vertex problems together
 func (g *Graph) Build() error {
 	if len(g.Vertex) == 0 {
 		return fmt.Errorf("Graph:%s vertex empty", g.Name)
 	}
+	if !isValidFailPolicy(g.FailPolicy) {
+		return fmt.Errorf("Graph:%s invalid fail_policy:%s", g.Name, g.FailPolicy)
+	}
+	if g.Weight < 0 {
+		return fmt.Errorf("Graph:%s invalid weight:%d", g.Name, g.Weight)
+	}
+	if len(g.VersionCond) > 0 {
+		program, err := compileExpr(g.VersionCond)
+		if err != nil {
+			return fmt.Errorf("Graph:%s version:%s invalid version_cond:%v", g.Name, g.ExpectVersion, err)
+		}
+		g.versionProgram = program
+	}
+	if len(g.Deadline) > 0 {
+		deadline, err := time.ParseDuration(g.Deadline)
+		if err != nil || deadline <= 0 {
+			return fmt.Errorf("Graph:%s invalid deadline:%s", g.Name, g.Deadline)
+		}
+		g.deadline = deadline
+	}
 	if err := g.buildVertexMap(); err != nil {
 		return err
 	}
-	if err := g.buildInputOutput(); err != nil {
+	errs := newBuildErrors(g.cluster != nil && g.cluster.StrictDsl)
+	if err := g.buildInputOutput(errs); err != nil {
 		return err
 	}
-	for _, v := range g.vertexMap {
-		err := v.build()
-		if err != nil {
-			return err
+	vertexes := g.sortedVertexes()
+	for _, v := range vertexes {
+		if errs.add(v.build()) {
+			return errs.err()
 		}
 	}
-	for _, v := range g.vertexMap {
-		if len(v.Cond) > 0 {
+	for _, v := range vertexes {
+		if errs.add(v.buildJoin()) {
+			return errs.err()
+		}
+	}
+	for _, v := range vertexes {
+		if len(v.Cond) > 0 || len(v.Switch) > 0 || v.isFallback {
 			continue
 		}
-		err := v.verify()
-		if err != nil {
-			return err
+		if errs.add(v.verify()) {
+			return errs.err()
 		}
 	}
+	if errs.add(g.checkMovedData()) {
+		return errs.err()
+	}
+	if len(errs.errs) > 0 {
+		// vertexes failed to build may leave circles undetected
+		return errs.err()
+	}
 	if g.testCircle() {
 		return fmt.Errorf("Circle Exist")
 	}
 	return nil
 }
+
+// sortedVertexes vertexes in declared order
+func (g *Graph) sortedVertexes() []*Vertex {
+	vertexes := make([]*Vertex, 0, len(g.Vertex))
+	for i := range g.Vertex {
+		vertexes = append(vertexes, &g.Vertex[i])
+	}
+	return vertexes
+}
+
+// checkMovedData data moved by a vertex can NOT be consumed by other vertexes
+func (g *Graph) checkMovedData() error {
+	consumers := make(map[string][]*Vertex)
+	for i := range g.Vertex {
+		v := &g.Vertex[i]
+		for idx := range v.Input {
+			for _, id := range unitIDs(&v.Input[idx]) {
+				consumers[id] = append(consumers[id], v)
+			}
+		}
+	}
+	for i := range g.Vertex {
+		v := &g.Vertex[i]
+		for idx := range v.Input {
+			if !v.Input[idx].Move {
+				continue
+			}
+			for _, id := range unitIDs(&v.Input[idx]) {
+				for _, consumer := range consumers[id] {
+					if consumer == v || consumer == v.fallbackVertex || v == consumer.fallbackVertex {
+						continue
+					}
+					return fmt.Errorf("Vertex:%s/%s move input data id:%s is also consumed by vertex:%s",
+						g.Name, v.ID, id, consumer.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
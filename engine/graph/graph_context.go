@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
-
-	"xxxx/util/safe"
+	"time"
 )
 
 // Context graph context
@@ -35,6 +35,10 @@ func NewContext(cc *ClusterContext, g *Graph) (*Context, error) {
 		AllInputIDs:        make(map[DIObjectKey]bool),
 		AllOutputIDs:       make(map[DIObjectKey]bool)}
 	for _, v := range g.vertexMap {
+		if v.isFallback {
+			// executed inside the primary vertex context
+			continue
+		}
 		vc, err := NewVertexContext(c, v)
 		if err != nil {
 			return nil, fmt.Errorf("new vertex:%v context err:%w", v.getDotLabel(), err)
@@ -60,50 +64,155 @@ func NewContext(cc *ClusterContext, g *Graph) (*Context, error) {
 
 // Execute execute graph context with datacontext
 func (c *Context) Execute(ctx context.Context, dataContext *DataContext) error {
+	_, err := c.ExecuteWithResult(ctx, dataContext)
+	return err
+}
+
+// graphDeadlineKey context key of executions under a graph deadline, including subgraphs called by it,
+// processors still running when the deadline expires are abandoned like those of vertexes with timeout
+type graphDeadlineKey struct{}
+
+// ExecuteWithResult execute graph context with datacontext and return execution report
+func (c *Context) ExecuteWithResult(ctx context.Context, dataContext *DataContext) (*ExecuteResult, error) {
 	c.ExternDataContext = dataContext
-	return c.execute(ctx)
+	start := time.Now()
+	c.emitGraphEvent(EventGraphStart, 0, nil)
+	if c.Graph.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Graph.deadline)
+		defer cancel()
+		ctx = context.WithValue(ctx, graphDeadlineKey{}, true)
+	}
+	var span Span
+	if t := c.getTracer(); t != nil {
+		ctx, span = c.startGraphSpan(ctx, t)
+	}
+	err := c.execute(ctx)
+	result := c.buildResult(ctx)
+	if err != nil {
+		result.Err = err
+	}
+	if span != nil {
+		endSpan(span, result.Err)
+	}
+	c.emitGraphEvent(EventGraphFinish, time.Since(start), result.Err)
+	return result, result.Err
+}
+
+func (c *Context) buildResult(ctx context.Context) *ExecuteResult {
+	result := &ExecuteResult{
+		Cluster:  c.Graph.cluster.Name,
+		Graph:    c.Graph.Name,
+		Version:  c.Graph.ExpectVersion,
+		Vertexes: make(map[string]*VertexResult, len(c.VertexContextTable)),
+	}
+	for v, vc := range c.VertexContextTable {
+		if vc.result.status == VertexNotRun && ctx.Err() != nil {
+			// not scheduled after ctx done
+			vc.result.status = VertexSkippedByCancel
+			vc.result.conditionResult = ctx.Err()
+			vc.emitVertexEvent()
+		}
+		vr := vc.getResult()
+		result.Vertexes[v.ID] = vr
+		if v.isSuccessorsEmpty() && (vr.Status == VertexOk || vr.Status.IsFailed()) {
+			result.Terminals = append(result.Terminals, v.ID)
+		}
+	}
+	sort.Strings(result.Terminals)
+	result.Err = result.checkFailPolicy(c.Graph.FailPolicy)
+	return result
 }
 
 // Execute execute graph context
 func (c *Context) execute(ctx context.Context) error {
-	for id := range c.AllInputIDs {
-		c.ExternDataContext.RegisterData(id)
-	}
-	for id := range c.AllOutputIDs {
-		c.ExternDataContext.RegisterData(id)
+	for _, vc := range c.VertexContextTable {
+		di := vc.ProcessorDI
+		if di == nil {
+			continue
+		}
+		scope := c.ClusterContext.Scope
+		if vc.fallback != nil {
+			if err := vc.fallback.ProcessorDI.BindIDs(scope); err != nil {
+				return fmt.Errorf("vertex:%s fallback %w", vc.Vertex.ID, err)
+			}
+		}
+		if err := di.BindIDs(scope); err != nil {
+			return fmt.Errorf("vertex:%s %w", vc.Vertex.ID, err)
+		}
+		for _, worker := range vc.workers {
+			if err := worker.ProcessorDI.BindIDs(scope); err != nil {
+				return fmt.Errorf("vertex:%s %w", vc.Vertex.ID, err)
+			}
+		}
+		for name, id := range di.InputIDs {
+			// extern input is produced by caller graph, keep it
+			if !di.ExternIDs[name] {
+				c.ExternDataContext.RegisterData(*id)
+			}
+		}
+		for _, id := range di.OutputIDs {
+			c.ExternDataContext.RegisterData(*id)
+		}
 	}
 	var readySuccessors []*VertexContext
+	now := time.Now()
 	for _, v := range c.VertexContextTable {
 		if v.Ready() {
+			v.result.readyAt = now
 			readySuccessors = append(readySuccessors, v)
 		}
 	}
+	if s := c.ClusterContext.step; s != nil {
+		return c.executeSteps(ctx, s, readySuccessors)
+	}
 	return c.ExecuteReadyVertexes(ctx, readySuccessors)
 }
 
-// ExecuteReadyVertexes execute ready vertexes
+// ExecuteReadyVertexes execute ready vertexes and all successors, wait until all done
 func (c *Context) ExecuteReadyVertexes(ctx context.Context, vertexes []*VertexContext) error {
-	if len(vertexes) == 0 {
-		return nil
-	}
 	var wg sync.WaitGroup
+	c.scheduleVertexes(ctx, &wg, vertexes)
+	wg.Wait()
+	return nil
+}
+
+func (c *Context) getScheduler() Scheduler {
+	if m := c.Graph.cluster.GraphManager; m != nil {
+		return m.getScheduler()
+	}
+	return defaultScheduler
+}
+
+// scheduleVertexes schedule ready vertexes, successors join the same wait group
+// so no goroutine blocks waiting for its successors
+func (c *Context) scheduleVertexes(ctx context.Context, wg *sync.WaitGroup, vertexes []*VertexContext) {
+	// not-yet-started vertexes are skipped once ctx is done
+	if len(vertexes) == 0 || ctx.Err() != nil {
+		return
+	}
 	wg.Add(len(vertexes))
+	tasks := make([]Task, len(vertexes))
 	for i := range vertexes {
-		i := i
-		safe.Go(func() {
+		vertexContext := vertexes[i]
+		tasks[i].Waiting = vertexContext.waiting()
+		tasks[i].Run = func() {
 			defer wg.Done()
-			if err := vertexes[i].Execute(ctx); err != nil {
+			if err := vertexContext.Execute(ctx); err != nil {
 				log.Printf("vertex execute err:%v", err)
 			}
-			_ = c.OnVertexDone(ctx, vertexes[i])
-		})
+			c.scheduleVertexes(ctx, wg, c.readySuccessors(vertexContext))
+		}
 	}
-	wg.Wait()
-	return nil
+	c.getScheduler().Schedule(c, tasks)
 }
 
 // OnVertexDone do something after vertex execute
 func (c *Context) OnVertexDone(ctx context.Context, vertexContext *VertexContext) error {
+	return c.ExecuteReadyVertexes(ctx, c.readySuccessors(vertexContext))
+}
+
+func (c *Context) readySuccessors(vertexContext *VertexContext) []*VertexContext {
 	var readySuccessors []*VertexContext
 	for _, successor := range vertexContext.Vertex.successorVertex {
 		successorCtx, ok := c.VertexContextTable[successor]
@@ -114,8 +223,9 @@ func (c *Context) OnVertexDone(ctx context.Context, vertexContext *VertexContext
 			successorCtx.SetDependencyResult(vertexContext.Vertex, vertexContext.result)
 		// last dependency
 		if waitNum == 0 {
+			successorCtx.result.readyAt = time.Now()
 			readySuccessors = append(readySuccessors, successorCtx)
 		}
 	}
-	return c.ExecuteReadyVertexes(ctx, readySuccessors)
+	return readySuccessors
 }
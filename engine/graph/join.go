@@ -0,0 +1,225 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"xxxx/innererror"
+)
+
+// join mode of vertex dependencies
+const (
+	JoinAll        = "all"
+	JoinAny        = "any"
+	JoinQuorum     = "quorum"
+	JoinAllSettled = "all_settled"
+)
+
+// JoinPolicy how vertex joins its dependencies, configured as a mode string like "any"
+// or a table like {quorum = 2, cancel_rest = true}
+type JoinPolicy struct {
+	Mode       string `toml:"mode" json:"mode"`
+	Quorum     int    `toml:"quorum" json:"quorum"`
+	CancelRest bool   `toml:"cancel_rest" json:"cancel_rest"`
+}
+
+// UnmarshalTOML decode join mode string or table
+func (j *JoinPolicy) UnmarshalTOML(data interface{}) error {
+	switch v := data.(type) {
+	case string:
+		j.Mode = v
+	case map[string]interface{}:
+		for key, value := range v {
+			var ok bool
+			switch key {
+			case "mode":
+				j.Mode, ok = value.(string)
+			case "quorum":
+				var quorum int64
+				quorum, ok = value.(int64)
+				j.Quorum = int(quorum)
+			case "cancel_rest":
+				j.CancelRest, ok = value.(bool)
+			default:
+				return fmt.Errorf("unknown join key:%s", key)
+			}
+			if !ok {
+				return fmt.Errorf("invalid join %s:%v", key, value)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid join:%v", data)
+	}
+	return nil
+}
+
+// UnmarshalJSON decode join mode string or object
+func (j *JoinPolicy) UnmarshalJSON(data []byte) error {
+	var mode string
+	if err := json.Unmarshal(data, &mode); err == nil {
+		j.Mode = mode
+		return nil
+	}
+	type policy JoinPolicy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*policy)(j))
+}
+
+// isPartial vertex may run before all dependencies report
+func (j *JoinPolicy) isPartial() bool {
+	return j != nil && (j.Mode == JoinAny || j.Mode == JoinQuorum)
+}
+
+// getQuorum number of succeeded dependencies a partial join waits for
+func (j *JoinPolicy) getQuorum() int {
+	if j.Mode == JoinAny {
+		return 1
+	}
+	return j.Quorum
+}
+
+// buildJoin check join policy after all dependencies are built
+func (v *Vertex) buildJoin() error {
+	j := v.Join
+	if j == nil {
+		return nil
+	}
+	if j.Quorum > 0 && len(j.Mode) == 0 {
+		j.Mode = JoinQuorum
+	}
+	switch j.Mode {
+	case "", JoinAll, JoinAllSettled:
+		if j.Quorum > 0 || j.CancelRest {
+			return fmt.Errorf("Vertex:%s/%s join:%s can NOT config quorum/cancel_rest", v.g.Name, v.ID, j.Mode)
+		}
+	case JoinAny:
+		if j.Quorum > 0 {
+			return fmt.Errorf("Vertex:%s/%s join:any can NOT config quorum", v.g.Name, v.ID)
+		}
+	case JoinQuorum:
+		if j.Quorum <= 0 || j.Quorum > len(v.depsResults) {
+			return fmt.Errorf("Vertex:%s/%s invalid join quorum:%d with %d dependencies",
+				v.g.Name, v.ID, j.Quorum, len(v.depsResults))
+		}
+	default:
+		return fmt.Errorf("Vertex:%s/%s invalid join:%s", v.g.Name, v.ID, j.Mode)
+	}
+	if len(v.depsResults) == 0 {
+		return fmt.Errorf("Vertex:%s/%s join without dependencies", v.g.Name, v.ID)
+	}
+	if j.CancelRest {
+		// cancelling a dependency skips all its successors, only the join may consume it
+		for id := range v.depsResults {
+			dep := v.g.getVertexByID(id)
+			for successor := range dep.successorVertex {
+				if successor != v.ID {
+					return fmt.Errorf("Vertex:%s/%s join cancel_rest can NOT cancel dependency:%s used by vertex:%s",
+						v.g.Name, v.ID, id, successor)
+				}
+			}
+		}
+		for id := range v.depsResults {
+			v.g.getVertexByID(id).joinCancelable = true
+		}
+	}
+	return nil
+}
+
+// checkDepResult check result of dependency matches expected result
+func checkDepResult(expectR int, vr *vertexResult) error {
+	err, ok := innererror.Upgrade(vr.conditionResult)
+	if !ok {
+		if expectR == innererror.VResultErr {
+			return innererror.Errorf(innererror.VResultErr, "expect error but not return err")
+		}
+		return nil
+	}
+	if (err.Code() & int32(expectR)) == 0 {
+		return innererror.Errorf(innererror.VResultErr, "expect not match code:%v expect:%v", err.Code(), expectR)
+	}
+	return nil
+}
+
+// depSucceeded dependency matches expected result and ran without error, counted by partial joins
+func depSucceeded(expectR int, vr *vertexResult) bool {
+	if checkDepResult(expectR, vr) != nil || vr.processorResult != nil {
+		return false
+	}
+	return expectR != innererror.VResultAll || vr.conditionResult == nil
+}
+
+// countSucceededDeps number of reported dependencies succeeded
+func (v *VertexContext) countSucceededDeps() int {
+	succeeded := 0
+	for id, expectR := range v.Vertex.depsResults {
+		if r, ok := v.vertexDepResults.Load(id); ok && depSucceeded(expectR, r.(*vertexResult)) {
+			succeeded++
+		}
+	}
+	return succeeded
+}
+
+// checkJoinResult check enough dependencies succeeded for partial join
+func (v *VertexContext) checkJoinResult() error {
+	quorum := v.Vertex.Join.getQuorum()
+	if succeeded := v.countSucceededDeps(); succeeded < quorum {
+		return innererror.Errorf(innererror.VResultErr, "join:%s need %d succeeded dependencies got %d",
+			v.Vertex.Join.Mode, quorum, succeeded)
+	}
+	return nil
+}
+
+// setJoinDependencyResult partial join becomes ready once, when quorum dependencies succeeded or
+// all dependencies reported, return 0 only for the call making it ready
+func (v *VertexContext) setJoinDependencyResult(waitNum int32) int32 {
+	if waitNum != 0 && v.countSucceededDeps() < v.Vertex.Join.getQuorum() {
+		return waitNum
+	}
+	if !atomic.CompareAndSwapInt32(&v.joined, 0, 1) {
+		// late dependency, already scheduled
+		return -1
+	}
+	if v.Vertex.Join.CancelRest {
+		for id := range v.Vertex.depsResults {
+			if _, ok := v.vertexDepResults.Load(id); ok {
+				continue
+			}
+			if dep, ok := v.GraphContext.VertexContextTable[v.GraphContext.Graph.getVertexByID(id)]; ok {
+				dep.cancelByJoin()
+			}
+		}
+	}
+	return 0
+}
+
+// withJoinCancel context of dependency vertex cancelled once a partial join no longer needs it
+func (v *VertexContext) withJoinCancel(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	v.cancelLock.Lock()
+	defer v.cancelLock.Unlock()
+	v.cancel = cancel
+	if v.cancelledByJoin {
+		cancel()
+	}
+	return ctx, cancel
+}
+
+// cancelByJoin cancel the running execution, or the one not started yet
+func (v *VertexContext) cancelByJoin() {
+	v.cancelLock.Lock()
+	defer v.cancelLock.Unlock()
+	v.cancelledByJoin = true
+	if v.cancel != nil {
+		v.cancel()
+	}
+}
+
+func (v *VertexContext) isCancelledByJoin() bool {
+	v.cancelLock.Lock()
+	defer v.cancelLock.Unlock()
+	return v.cancelledByJoin
+}
@@ -1,13 +1,16 @@
 package graph
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 
 	"xxxx/dagengine/engine/param"
 	"xxxx/dagengine/engine/processor"
@@ -30,14 +33,100 @@ func Load(name string, content []byte, c Codec) error {
 
 // Execute  execute one graph on cluster
 func Execute(ctx context.Context, clusterName string, graphName string,
-	dataContext *DataContext, params *param.Params) error {
-	return DefaultManager.execute(ctx, clusterName, graphName, dataContext, params)
+	dataContext *DataContext, params *param.Params, opts ...ExecuteOption) error {
+	_, err := DefaultManager.execute(ctx, clusterName, graphName, dataContext, params, nil, opts...)
+	return err
+}
+
+// ExecuteWithResult execute one graph on cluster and return execution report
+func ExecuteWithResult(ctx context.Context, clusterName string, graphName string,
+	dataContext *DataContext, params *param.Params, opts ...ExecuteOption) (*ExecuteResult, error) {
+	return DefaultManager.execute(ctx, clusterName, graphName, dataContext, params, nil, opts...)
+}
+
+type executeOptions struct {
+	version string
+	step    *stepper
+	trace   *Trace
+	replay  *replayer
+	// parentID parentVertex execution and vertex calling the subgraph
+	parentID     uint64
+	parentVertex string
+	// caller cluster context calling the subgraph
+	caller   *ClusterContext
+	callPath string
+	scope    *param.Params
+}
+
+// ExecuteOption option of one execution
+type ExecuteOption func(*executeOptions)
+
+// WithVersion execute the graph with expect_version, overrides version_cond and weight
+func WithVersion(version string) ExecuteOption {
+	return func(o *executeOptions) {
+		o.version = version
+	}
+}
+
+// WithScope execute the entry graph with variables resolving its '$' ids and args
+func WithScope(scope *param.Params) ExecuteOption {
+	return func(o *executeOptions) {
+		o.scope = scope
+	}
 }
 
 // Manager manager of cluster
 type Manager struct {
-	clusters map[string]*Cluster
-	lock     sync.RWMutex
+	clusters   map[string]*Cluster
+	generation int64
+	scheduler  Scheduler
+	maxDepth   int
+	watchFiles map[string]*FileStatus
+	watchLock  sync.Mutex
+	sinks      []EventSink
+	tracer     Tracer
+	sweepOnce  sync.Once
+	// sweeping idle sweeper running, stopped by closing done
+	sweeping  sync.WaitGroup
+	done      chan struct{}
+	closeOnce sync.Once
+	lock      sync.RWMutex
+}
+
+var defaultScheduler Scheduler = &UnboundedScheduler{}
+
+const defaultMaxSubGraphDepth = 16
+
+// SetMaxSubGraphDepth set max nesting depth of subgraph calls, 0 means default 16
+func (m *Manager) SetMaxSubGraphDepth(depth int) {
+	m.lock.Lock()
+	m.maxDepth = depth
+	m.lock.Unlock()
+}
+
+func (m *Manager) getMaxSubGraphDepth() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.maxDepth <= 0 {
+		return defaultMaxSubGraphDepth
+	}
+	return m.maxDepth
+}
+
+// SetScheduler set scheduler of ready vertexes, nil means unbounded scheduler
+func (m *Manager) SetScheduler(s Scheduler) {
+	m.lock.Lock()
+	m.scheduler = s
+	m.lock.Unlock()
+}
+
+func (m *Manager) getScheduler() Scheduler {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.scheduler == nil {
+		return defaultScheduler
+	}
+	return m.scheduler
 }
 
 // Codec json toml unmarshal
@@ -59,6 +148,19 @@ func (c *JSONCodec) Unmarshal(in []byte, out interface{}) error {
 	return json.Unmarshal(in, out)
 }
 
+// StrictCodec codec reports unknown keys for strict_dsl clusters
+type StrictCodec interface {
+	Codec
+	CheckUnknownKeys([]byte, interface{}) error
+}
+
+// CheckUnknownKeys JSON decode and report the unknown field
+func (c *JSONCodec) CheckUnknownKeys(in []byte, out interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(in))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(out)
+}
+
 // TomlCodec toml codec
 type TomlCodec struct{}
 
@@ -72,6 +174,23 @@ func (c *TomlCodec) Unmarshal(in []byte, out interface{}) error {
 	return toml.Unmarshal(in, out)
 }
 
+// CheckUnknownKeys toml decode and report all unknown keys
+func (c *TomlCodec) CheckUnknownKeys(in []byte, out interface{}) error {
+	meta, err := toml.Decode(string(in), out)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, key := range meta.Undecoded() {
+		if len(key) > 1 && key[len(key)-2] == "join" {
+			// decoded by JoinPolicy, which reports unknown keys itself
+			continue
+		}
+		errs = append(errs, fmt.Errorf("unknown key:%s", key.String()))
+	}
+	return errors.Join(errs...)
+}
+
 // LoadFile load cluster from file
 func (m *Manager) loadFile(filepath string, c Codec) error {
 	f, err := os.Open(filepath)
@@ -89,43 +208,142 @@ func (m *Manager) loadFile(filepath string, c Codec) error {
 
 // LoadFile load cluster from content
 func (m *Manager) load(name string, content []byte, c Codec) error {
+	cluster, err := m.buildCluster(name, content, c)
+	if err != nil {
+		return err
+	}
+	m.publish(map[string]*Cluster{name: cluster}, nil)
+	return nil
+}
+
+func (m *Manager) buildCluster(name string, content []byte, c Codec) (*Cluster, error) {
 	cluster := &Cluster{}
 	if err := c.Unmarshal(content, cluster); err != nil {
-		return err
+		return nil, err
+	}
+	if len(cluster.Name) == 0 {
+		cluster.Name = name
 	}
 	if cluster.DefaultContextPoolSize == 0 {
 		cluster.DefaultContextPoolSize = defaultContextPoolSize
+		if p := cluster.ContextPool; p != nil && p.Max > 0 && p.Max < defaultContextPoolSize {
+			cluster.DefaultContextPoolSize = p.Max
+		}
+		if p := cluster.ContextPool; p != nil && p.MaxIdle > 0 && p.MaxIdle < cluster.DefaultContextPoolSize {
+			cluster.DefaultContextPoolSize = p.MaxIdle
+		}
 	}
-	if err := cluster.Build(processor.GenerateMetas()); err != nil {
-		return err
+	cluster.GraphManager = m
+	var keysErr error
+	if sc, ok := c.(StrictCodec); ok && cluster.StrictDsl {
+		keysErr = sc.CheckUnknownKeys(content, &Cluster{})
+	}
+	if err := errors.Join(keysErr, cluster.Build(processor.GenerateMetas())); err != nil {
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// mergeClusters copy clusters with updated and removed ones
+func mergeClusters(current map[string]*Cluster, updated map[string]*Cluster,
+	removed []string) map[string]*Cluster {
+	clusters := make(map[string]*Cluster, len(current)+len(updated))
+	for name, cluster := range current {
+		clusters[name] = cluster
 	}
+	for _, name := range removed {
+		delete(clusters, name)
+	}
+	for name, cluster := range updated {
+		clusters[name] = cluster
+	}
+	return clusters
+}
+
+// publish swap a new generation of clusters, the published map is never modified,
+// so in-flight executions keep using the generation they started with
+func (m *Manager) publish(updated map[string]*Cluster, removed []string) int64 {
 	m.lock.Lock()
-	m.clusters[name] = cluster
-	m.lock.Unlock()
-	return nil
+	defer m.lock.Unlock()
+	m.clusters = mergeClusters(m.clusters, updated, removed)
+	m.generation++
+	m.startIdleSweeper(updated)
+	return m.generation
+}
+
+// Generation current generation of loaded clusters
+func (m *Manager) Generation() int64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.generation
 }
 
-// Execute cluster by clusterName and graphName
+// snapshot current generation of clusters
+func (m *Manager) snapshot() map[string]*Cluster {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.clusters
+}
+
+// Execute cluster by clusterName and graphName, scope is the args of calling subgraph vertex
 func (m *Manager) execute(ctx context.Context, clusterName string, graphName string,
-	dataContext *DataContext, params *param.Params) error {
+	dataContext *DataContext, params *param.Params, scope *param.Params, opts ...ExecuteOption) (*ExecuteResult, error) {
+	var options executeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if scope == nil {
+		scope = options.scope
+	}
+	if t := m.getTracer(); t != nil {
+		var span Span
+		ctx, span = t.Start(ctx, SpanExecute,
+			SpanAttribute{Key: "dag.cluster", Value: clusterName},
+			SpanAttribute{Key: "dag.graph", Value: graphName})
+		result, err := m.executeIn(ctx, m.snapshot(), clusterName, graphName, dataContext, params, scope, 0, options)
+		endSpan(span, err)
+		return result, err
+	}
+	return m.executeIn(ctx, m.snapshot(), clusterName, graphName, dataContext, params, scope, 0, options)
+}
+
+// executeIn execute with one generation of clusters, depth is the nesting depth of subgraph calls
+func (m *Manager) executeIn(ctx context.Context, clusters map[string]*Cluster, clusterName string,
+	graphName string, dataContext *DataContext, params *param.Params, scope *param.Params,
+	depth int, options executeOptions) (*ExecuteResult, error) {
+	if maxDepth := m.getMaxSubGraphDepth(); depth > maxDepth {
+		return nil, fmt.Errorf("subgraph:%s::%s nesting depth exceeds %d", clusterName, graphName, maxDepth)
+	}
 	if dataContext == nil {
 		dataContext = NewDataContext()
 	}
-	m.lock.RLock()
-	cluster, ok := m.clusters[clusterName]
-	m.lock.RUnlock()
+	cluster, ok := clusters[clusterName]
 	if !ok {
-		return fmt.Errorf("not find cluter:%v", clusterName)
+		return nil, fmt.Errorf("not find cluter:%v", clusterName)
 	}
-	clusterContext, err := cluster.ClusterContextPool.Get(cluster)
+	// a caller holding a context of the pool would wait for itself
+	reentrant := options.caller != nil && options.caller.holds(cluster.ClusterContextPool)
+	clusterContext, err := cluster.ClusterContextPool.getContext(ctx, cluster, reentrant)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		clusterContext.Reset()
 		cluster.ClusterContextPool.Put(clusterContext)
 	}()
-	return clusterContext.Execute(ctx, graphName, dataContext, params)
+	clusterContext.Scope = scope
+	clusterContext.clusters = clusters
+	clusterContext.depth = depth
+	clusterContext.version = options.version
+	clusterContext.step = options.step
+	clusterContext.trace = options.trace
+	clusterContext.replay = options.replay
+	clusterContext.executionID = atomic.AddUint64(&executionSeq, 1)
+	clusterContext.parentID = options.parentID
+	clusterContext.parentVertex = options.parentVertex
+	clusterContext.caller = options.caller
+	clusterContext.callPath = options.callPath
+	return clusterContext.ExecuteWithResult(ctx, graphName, dataContext, params)
 }
 
 // DefaultManager default cluster manager
@@ -133,5 +351,19 @@ var DefaultManager = New()
 
 // New manager
 func New() *Manager {
-	return &Manager{clusters: make(map[string]*Cluster)}
+	return &Manager{clusters: make(map[string]*Cluster), watchFiles: make(map[string]*FileStatus),
+		done: make(chan struct{})}
+}
+
+// Close stop background goroutines of manager and wait for them, clusters loaded stay executable
+// but idle contexts are only evicted by Get and Put afterwards
+func (m *Manager) Close() {
+	// no idle sweeper starts after this
+	m.sweepOnce.Do(func() {})
+	m.closeOnce.Do(func() {
+		if m.done != nil {
+			close(m.done)
+		}
+	})
+	m.sweeping.Wait()
 }
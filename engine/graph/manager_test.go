@@ -5,11 +5,17 @@ import (
 	"log"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"xxxx/dagengine/engine/param"
 	"xxxx/dagengine/engine/processor"
 	"xxxx/innererror"
+
+	"github.com/BurntSushi/toml"
 )
 
 type Mid struct {
@@ -131,6 +137,150 @@ func (p *phase5) OnExecute(_ context.Context, params *param.Params) error {
 	return nil
 }
 
+type phase6 struct {
+	Mid []Mid `graph:"input"`
+	ID  *s    `graph:"output"`
+}
+
+func (p *phase6) OnInit() {
+}
+
+func (p *phase6) OnExecute(_ context.Context, params *param.Params) error {
+	p.ID.i = int(params.GetInt64("id")) * len(p.Mid)
+	return nil
+}
+
+type phase7 struct {
+}
+
+func (p *phase7) OnInit() {
+}
+
+func (p *phase7) OnExecute(_ context.Context, params *param.Params) error {
+	time.Sleep(time.Duration(params.GetInt64("sleep")) * time.Millisecond)
+	return nil
+}
+
+type phase8 struct {
+	ID    *s `graph:"output"`
+	calls int
+}
+
+func (p *phase8) OnInit() {
+}
+
+func (p *phase8) OnExecute(ctx context.Context, params *param.Params) error {
+	p.calls++
+	if sleep := params.GetInt64("sleep"); sleep > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(sleep) * time.Millisecond):
+		}
+	}
+	p.ID.i = int(params.GetInt64("id"))
+	if int64(p.calls) <= params.GetInt64("fail") {
+		return innererror.Error(int32(params.GetInt64("code")))
+	}
+	return nil
+}
+
+type profile struct {
+	REQ   *testReq        `graph:"extern_input"`
+	Flags map[string]bool `graph:"output"`
+}
+
+// profileInits count initialized profile processors
+var profileInits int32
+
+func (p *profile) OnInit() {
+	atomic.AddInt32(&profileInits, 1)
+}
+
+func (p *profile) OnExecute(_ context.Context, params *param.Params) error {
+	// count calls in REQ to check the setting is evaluated once per request
+	p.REQ.id[2]++
+	p.Flags = map[string]bool{"vip": p.REQ.name == params.GetString("vip_name"), "new": false}
+	return nil
+}
+
+type recallChannels struct {
+	Channels []string `graph:"output"`
+}
+
+func (p *recallChannels) OnInit() {
+}
+
+func (p *recallChannels) OnExecute(_ context.Context, params *param.Params) error {
+	p.Channels = strings.Split(params.GetString("channels"), ",")
+	return nil
+}
+
+type recall struct {
+	Channel string   `graph:"element"`
+	Index   int      `graph:"index"`
+	Items   []string `graph:"output"`
+}
+
+func (p *recall) OnInit() {
+}
+
+func (p *recall) OnExecute(_ context.Context, params *param.Params) error {
+	if p.Channel == params.GetString("fail") {
+		return innererror.Error(1)
+	}
+	p.Items = []string{p.Channel + strconv.Itoa(p.Index)}
+	return nil
+}
+
+// recallSource channel with tags, not comparable
+type recallSource struct {
+	Name string
+	Tags []string
+}
+
+type recallSources struct {
+	Sources []recallSource `graph:"output"`
+}
+
+func (p *recallSources) OnInit() {
+}
+
+func (p *recallSources) OnExecute(_ context.Context, params *param.Params) error {
+	for _, name := range strings.Split(params.GetString("channels"), ",") {
+		p.Sources = append(p.Sources, recallSource{Name: name})
+	}
+	return nil
+}
+
+type recallBySource struct {
+	Source recallSource `graph:"element"`
+	Items  []string     `graph:"output"`
+}
+
+func (p *recallBySource) OnInit() {
+}
+
+func (p *recallBySource) OnExecute(_ context.Context, params *param.Params) error {
+	p.Items = []string{p.Source.Name}
+	return nil
+}
+
+type mergeRecall struct {
+	Recalls [][]string `graph:"input"`
+	Merged  []string   `graph:"output"`
+}
+
+func (p *mergeRecall) OnInit() {
+}
+
+func (p *mergeRecall) OnExecute(_ context.Context, params *param.Params) error {
+	for _, items := range p.Recalls {
+		p.Merged = append(p.Merged, items...)
+	}
+	return nil
+}
+
 func TestManager_Execute(t *testing.T) {
 	log.SetFlags(log.Lshortfile | log.LstdFlags)
 	type fields struct {
@@ -405,6 +555,28 @@ func TestManager_Execute1(t *testing.T) {
 			},
 			wantErr: true,
 			want1:   testReq{name: "", id: []int{0, 11}, strs: nil}},
+		{name: "subgraph_args_test",
+			fields: fields{clusters: make(map[string]*Cluster)},
+			args: args{
+				ctx:         context.Background(),
+				dataContext: NewDataContext(),
+				clusterName: "subgraph_args_test.toml",
+				graphName:   "enter",
+				params:      &param.Params{"EXP": 102},
+			},
+			wantErr: false,
+			want1:   testReq{name: "", id: []int{11, 12}, strs: nil}},
+		{name: "expr_err_test",
+			fields: fields{clusters: make(map[string]*Cluster)},
+			args: args{
+				ctx:         context.Background(),
+				dataContext: NewDataContext(),
+				clusterName: "expr_err_test.toml",
+				graphName:   "enter",
+				params:      &param.Params{"EXP": 102},
+			},
+			wantErr: true,
+			want1:   testReq{name: "", id: []int{0, 11}, strs: nil}},
 		{name: "optional_input_test",
 			fields: fields{clusters: make(map[string]*Cluster)},
 			args: args{
@@ -423,6 +595,7 @@ func TestManager_Execute1(t *testing.T) {
 	processor.Register("phase3", func() processor.Processor { return &phase3{} })
 	processor.Register("phase4", func() processor.Processor { return &phase4{} })
 	processor.Register("phase5", func() processor.Processor { return &phase5{} })
+	processor.Register("phase6", func() processor.Processor { return &phase6{} })
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			filepath := "../../cmd/" + tt.args.clusterName
@@ -448,3 +621,972 @@ func TestManager_Execute1(t *testing.T) {
 		})
 	}
 }
+
+func TestManager_ExecuteWithResult(t *testing.T) {
+	type args struct {
+		clusterName string
+		graphName   string
+	}
+	tests := []struct {
+		name          string
+		args          args
+		wantErr       bool
+		wantStatus    map[string]VertexStatus
+		wantTerminals []string
+		// maxDuration execution must return within, 0 means unchecked
+		maxDuration time.Duration
+	}{
+		{name: "fail_any",
+			args:          args{clusterName: "fail_policy_test.toml", graphName: "fail_any"},
+			wantErr:       true,
+			wantStatus:    map[string]VertexStatus{"phase0": VertexErr, "phase1": VertexSkippedByExpect},
+			wantTerminals: nil},
+		{name: "fail_critical",
+			args:          args{clusterName: "fail_policy_test.toml", graphName: "fail_critical"},
+			wantErr:       false,
+			wantStatus:    map[string]VertexStatus{"phase0": VertexErr, "phase1": VertexOk},
+			wantTerminals: []string{"phase1"}},
+		{name: "fail_critical_err",
+			args:          args{clusterName: "fail_policy_test.toml", graphName: "fail_critical_err"},
+			wantErr:       true,
+			wantStatus:    map[string]VertexStatus{"phase0": VertexErr, "phase1": VertexOk},
+			wantTerminals: []string{"phase1"}},
+		{name: "fail_never",
+			args:          args{clusterName: "fail_policy_test.toml", graphName: "fail_never"},
+			wantErr:       false,
+			wantStatus:    map[string]VertexStatus{"phase0": VertexErr, "phase1": VertexOk},
+			wantTerminals: []string{"phase1"}},
+		{name: "vertex_timeout",
+			args:    args{clusterName: "timeout_test.toml", graphName: "vertex_timeout"},
+			wantErr: false,
+			wantStatus: map[string]VertexStatus{"slow": VertexTimeout, "on_err": VertexOk,
+				"on_ok": VertexSkippedByDeps, "on_code": VertexOk},
+			wantTerminals: []string{"on_code", "on_err"}},
+		{name: "graph_deadline",
+			args:          args{clusterName: "timeout_test.toml", graphName: "graph_deadline"},
+			wantErr:       false,
+			wantStatus:    map[string]VertexStatus{"slow": VertexTimeout, "next": VertexSkippedByCancel},
+			wantTerminals: nil,
+			maxDuration:   100 * time.Millisecond},
+		{name: "graph_deadline_subgraph",
+			args:          args{clusterName: "timeout_test.toml", graphName: "graph_deadline_subgraph"},
+			wantErr:       false,
+			wantStatus:    map[string]VertexStatus{"call_slow": VertexTimeout, "next": VertexSkippedByCancel},
+			wantTerminals: nil,
+			maxDuration:   100 * time.Millisecond},
+	}
+	processor.Register("phase0", func() processor.Processor { return &phase0{} })
+	processor.Register("phase1", func() processor.Processor { return &phase1{} })
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := LoadFile("../../cmd/" + tt.args.clusterName); err != nil {
+				t.Fatalf("Manager.LoadFile() error = %v", err)
+			}
+			ts := &testReq{name: "ts", id: []int{1, 2, 3}, strs: []string{"s0", "s1", "s2"}}
+			dataContext := NewDataContext()
+			var midi interface{} = ts
+			dataContext.Set(NewDIObjectKey("REQ", reflect.TypeOf(ts)), reflect.ValueOf(midi))
+			start := time.Now()
+			result, err := ExecuteWithResult(context.Background(), tt.args.clusterName, tt.args.graphName,
+				dataContext, &param.Params{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExecuteWithResult() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); tt.maxDuration > 0 && elapsed > tt.maxDuration {
+				t.Errorf("ExecuteWithResult() took %v, want <= %v", elapsed, tt.maxDuration)
+			}
+			if result == nil {
+				t.Fatalf("ExecuteWithResult() result = nil")
+			}
+			for id, want := range tt.wantStatus {
+				if got := result.Vertexes[id].Status; got != want {
+					t.Errorf("ExecuteWithResult() vertex:%v status = %v, want %v", id, got, want)
+				}
+			}
+			if !reflect.DeepEqual(result.Terminals, tt.wantTerminals) {
+				t.Errorf("ExecuteWithResult() terminals = %v, want %v", result.Terminals, tt.wantTerminals)
+			}
+		})
+	}
+}
+
+func TestManager_ExecuteRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		graphName    string
+		wantAttempts int
+		wantFallback bool
+		wantID       int
+	}{
+		{name: "retry_ok", graphName: "retry_ok", wantAttempts: 3, wantFallback: false, wantID: 1},
+		{name: "retry_on_codes", graphName: "retry_on_codes", wantAttempts: 1, wantFallback: true, wantID: 2},
+		{name: "fallback_vertex", graphName: "fallback_vertex", wantAttempts: 2, wantFallback: true, wantID: 9},
+		{name: "timeout_fallback", graphName: "timeout_fallback", wantAttempts: 1, wantFallback: true, wantID: 9},
+	}
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	processor.Register("phase8", func() processor.Processor { return &phase8{} })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := LoadFile("../../cmd/retry_test.toml"); err != nil {
+				t.Fatalf("Manager.LoadFile() error = %v", err)
+			}
+			dataContext := NewDataContext()
+			result, err := ExecuteWithResult(context.Background(), "retry_test.toml", tt.graphName,
+				dataContext, &param.Params{})
+			if err != nil {
+				t.Fatalf("ExecuteWithResult() error = %v", err)
+			}
+			flaky := result.Vertexes["flaky"]
+			if flaky.Status != VertexOk || flaky.Attempts != tt.wantAttempts || flaky.Fallback != tt.wantFallback {
+				t.Errorf("ExecuteWithResult() flaky = %+v, want attempts %v fallback %v",
+					flaky, tt.wantAttempts, tt.wantFallback)
+			}
+			if got := result.Vertexes["check"].Status; got != VertexOk {
+				t.Errorf("ExecuteWithResult() check status = %v, want %v", got, VertexOk)
+			}
+			v, _ := dataContext.Get(NewDIObjectKey("ID", reflect.TypeOf(&s{})))
+			rv, ok := v.(reflect.Value)
+			if !ok || rv.Interface().(*s).i != tt.wantID {
+				t.Errorf("ExecuteWithResult() output ID = %v, want %v", v, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestManager_LoadTypeCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		clusterName string
+		wantErr     string
+	}{
+		{name: "input_type_mismatch",
+			clusterName: "type_err_test.toml",
+			wantErr:     "Vertex:enter/phase5 input:ID type:*graph.s mismatch output:Mid type:[]graph.Mid of vertex:enter/phase0"},
+		{name: "aggregate_type_mismatch",
+			clusterName: "aggregate_type_err_test.toml",
+			wantErr:     "Vertex:enter/phase2 input:IDs type:*graph.s mismatch output:Mid type:[]graph.Mid of vertex:enter/phase0"},
+		{name: "move_consumed_twice",
+			clusterName: "move_err_test.toml",
+			wantErr:     "Vertex:enter/p51 move input data id:ID is also consumed by vertex:p52"},
+		{name: "type_ok",
+			clusterName: "aggregate_test.toml"},
+	}
+	processor.Register("phase0", func() processor.Processor { return &phase0{} })
+	processor.Register("phase1", func() processor.Processor { return &phase1{} })
+	processor.Register("phase2", func() processor.Processor { return &phase2{} })
+	processor.Register("phase3", func() processor.Processor { return &phase3{} })
+	processor.Register("phase5", func() processor.Processor { return &phase5{} })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New().loadFile("../../cmd/"+tt.clusterName, &TomlCodec{})
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Manager.loadFile() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Manager.loadFile() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_ExecuteUnitAttrs(t *testing.T) {
+	tests := []struct {
+		name       string
+		graphName  string
+		params     *param.Params
+		wantStatus VertexStatus
+		wantCode   int32
+		wantID     bool
+	}{
+		{name: "required_ok", graphName: "required_ok", params: &param.Params{},
+			wantStatus: VertexOk, wantID: true},
+		{name: "required_zero", graphName: "required_zero", params: &param.Params{},
+			wantStatus: VertexSkippedByRequired, wantCode: ResultCodeRequiredMissing, wantID: true},
+		{name: "required_not_run", graphName: "required_not_run", params: &param.Params{},
+			wantStatus: VertexSkippedByRequired, wantCode: ResultCodeRequiredMissing},
+		{name: "move", graphName: "move", params: &param.Params{},
+			wantStatus: VertexOk},
+		{name: "output_cond_false", graphName: "output_cond", params: &param.Params{},
+			wantStatus: VertexSkippedByRequired, wantCode: ResultCodeRequiredMissing},
+		{name: "output_cond_true", graphName: "output_cond", params: &param.Params{"EXP": 1},
+			wantStatus: VertexOk, wantID: true},
+	}
+	processor.Register("phase1", func() processor.Processor { return &phase1{} })
+	processor.Register("phase5", func() processor.Processor { return &phase5{} })
+	if err := LoadFile("../../cmd/unit_attrs_test.toml"); err != nil {
+		t.Fatalf("Manager.LoadFile() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataContext := newTestDataContext()
+			result, err := ExecuteWithResult(context.Background(), "unit_attrs_test.toml", tt.graphName,
+				dataContext, tt.params)
+			if err != nil {
+				t.Fatalf("ExecuteWithResult() error = %v", err)
+			}
+			vr := result.Vertexes["phase5"]
+			if vr.Status != tt.wantStatus || vr.Code != tt.wantCode {
+				t.Errorf("ExecuteWithResult() phase5 status = %v code = %v, want %v %v",
+					vr.Status, vr.Code, tt.wantStatus, tt.wantCode)
+			}
+			v, ok := dataContext.Get(NewDIObjectKey("ID", reflect.TypeOf(&s{})))
+			_, isValue := v.(reflect.Value)
+			if got := ok && isValue; got != tt.wantID {
+				t.Errorf("ExecuteWithResult() output ID = %v, want published %v", v, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestManager_LoadStrictDsl(t *testing.T) {
+	tests := []struct {
+		name        string
+		clusterName string
+		wantErrs    []string
+	}{
+		{name: "strict_ok",
+			clusterName: "strict_dsl_test.toml"},
+		{name: "strict_err",
+			clusterName: "strict_dsl_err_test.toml",
+			wantErrs: []string{
+				"unknown key:unknown_top",
+				"unknown key:graph.vertex.timeot",
+				"Vertex:enter/strict_dsl_err_test.toml::sub id is auto generated",
+				"Vertex:enter/call2 processor:not_exist not found",
+				"Vertex:sub/plain processor:not_exist_plain not found",
+				"Vertex:enter/phase3 is unreachable from start vertexes",
+				"Vertex:enter/phase0 output:Mid data id:mid0 is not consumed",
+				"Vertex:sub/sub_p3 output:OK data id:sub_ok is not consumed",
+				"Vertex:enter/phase5 input:ID is implicitly wired to vertex:phase1 by field name",
+				"config_setting:unused is not referenced",
+				"config_setting:unused invalid cond:unknown name UNKNOWN (1:13)",
+				"Vertex:sub/sub_p3 invalid expect:unknown name RET_CODE_plain (1:1)",
+				"Duplicate graph name:sub",
+			}},
+		{name: "lenient",
+			clusterName: "subgraph_test.toml"},
+	}
+	processor.Register("phase0", func() processor.Processor { return &phase0{} })
+	processor.Register("phase1", func() processor.Processor { return &phase1{} })
+	processor.Register("phase3", func() processor.Processor { return &phase3{} })
+	processor.Register("phase5", func() processor.Processor { return &phase5{} })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New().loadFile("../../cmd/"+tt.clusterName, &TomlCodec{})
+			if (err != nil) != (len(tt.wantErrs) > 0) {
+				t.Fatalf("Manager.loadFile() error = %v, want %v", err, tt.wantErrs)
+			}
+			for _, wantErr := range tt.wantErrs {
+				if !strings.Contains(err.Error(), wantErr) {
+					t.Errorf("Manager.loadFile() error = %v, want %v", err, wantErr)
+				}
+			}
+			// every problem is reported once
+			if err != nil && len(strings.Split(err.Error(), "\n")) != len(tt.wantErrs) {
+				t.Errorf("Manager.loadFile() error = %v, want %d problems", err, len(tt.wantErrs))
+			}
+		})
+	}
+}
+
+func TestManager_LoadStrictDslConsumed(t *testing.T) {
+	callee := `
+name = "strict_callee.toml"
+strict_dsl = true
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+id = "p0"
+start = true
+processor = "phase0"
+output = [{field="Mid", id="result_mid", result=true}]
+[[graph]]
+name = "sub"
+[[graph.vertex]]
+id = "p0"
+start = true
+processor = "phase0"
+output = [{field="Mid", id="mid0"}]
+`
+	caller := `
+name = "strict_caller.toml"
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+id = "call"
+start = true
+cluster = "strict_callee.toml"
+graph = "sub"
+[[graph.vertex]]
+id = "p1"
+processor = "phase1"
+deps = ["call"]
+input = [{field="Mid", id="mid0", extern=true}]
+`
+	tests := []struct {
+		name    string
+		callers []string
+		wantErr string
+	}{
+		{name: "no_caller", wantErr: "Vertex:sub/p0 output:Mid data id:mid0 is not consumed"},
+		{name: "caller", callers: []string{caller}},
+	}
+	processor.Register("phase0", func() processor.Processor { return &phase0{} })
+	processor.Register("phase1", func() processor.Processor { return &phase1{} })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New()
+			for _, content := range tt.callers {
+				if err := m.load("strict_caller.toml", []byte(content), &TomlCodec{}); err != nil {
+					t.Fatalf("Manager.load() caller error = %v", err)
+				}
+			}
+			err := m.load("strict_callee.toml", []byte(callee), &TomlCodec{})
+			if (err != nil) != (len(tt.wantErr) > 0) || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("Manager.load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_ExecuteVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		graphName   string
+		params      *param.Params
+		opts        []ExecuteOption
+		wantErr     bool
+		wantVersion string
+	}{
+		{name: "default", graphName: "enter", params: &param.Params{"APP_VERSION": 100}, wantVersion: "1.0"},
+		{name: "version_cond", graphName: "enter", params: &param.Params{"APP_VERSION": 300}, wantVersion: "2.0"},
+		{name: "explicit", graphName: "enter", params: &param.Params{"APP_VERSION": 300},
+			opts: []ExecuteOption{WithVersion("1.0")}, wantVersion: "1.0"},
+		{name: "explicit_not_exist", graphName: "enter", params: &param.Params{},
+			opts: []ExecuteOption{WithVersion("3.0")}, wantErr: true},
+		{name: "split_no_key", graphName: "split", params: &param.Params{}, wantVersion: "base"},
+	}
+	processor.Register("phase1", func() processor.Processor { return &phase1{} })
+	if err := LoadFile("../../cmd/version_test.toml"); err != nil {
+		t.Fatalf("Manager.LoadFile() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ExecuteWithResult(context.Background(), "version_test.toml", tt.graphName,
+				newTestDataContext(), tt.params, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExecuteWithResult() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && result.Version != tt.wantVersion {
+				t.Errorf("ExecuteWithResult() version = %v, want %v", result.Version, tt.wantVersion)
+			}
+		})
+	}
+	t.Run("split_sticky", func(t *testing.T) {
+		hits := make(map[string]int)
+		for uid := 0; uid < 100; uid++ {
+			var versions []string
+			for i := 0; i < 3; i++ {
+				result, err := ExecuteWithResult(context.Background(), "version_test.toml", "split",
+					newTestDataContext(), &param.Params{"UID": uid})
+				if err != nil {
+					t.Fatalf("ExecuteWithResult() error = %v", err)
+				}
+				versions = append(versions, result.Version)
+			}
+			if versions[0] != versions[1] || versions[0] != versions[2] {
+				t.Errorf("ExecuteWithResult() uid:%v versions = %v, want sticky", uid, versions)
+			}
+			hits[versions[0]]++
+		}
+		if hits["base"] == 0 || hits["canary"] == 0 {
+			t.Errorf("ExecuteWithResult() split hits = %v, want both versions", hits)
+		}
+	})
+	t.Run("event_version", func(t *testing.T) {
+		for len(GetDefaultEventChan()) > 0 {
+			<-GetDefaultEventChan()
+		}
+		if _, err := ExecuteWithResult(context.Background(), "version_test.toml", "enter",
+			newTestDataContext(), &param.Params{"APP_VERSION": 300}); err != nil {
+			t.Fatalf("ExecuteWithResult() error = %v", err)
+		}
+		e := <-GetDefaultEventChan()
+		if e.Version != "2.0" {
+			t.Errorf("Event.Version = %v, want 2.0", e.Version)
+		}
+	})
+	t.Run("dump_dot", func(t *testing.T) {
+		c := &Cluster{}
+		if _, err := toml.DecodeFile("../../cmd/version_test.toml", c); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Build(processor.GenerateMetas()); err != nil {
+			t.Fatal(err)
+		}
+		buffer := &strings.Builder{}
+		c.DumpDot(buffer)
+		for _, want := range []string{"cluster_enter_v1_0", "cluster_enter_v2_0", "cluster_split_vbase", "cluster_split_vcanary"} {
+			if !strings.Contains(buffer.String(), want) {
+				t.Errorf("Cluster.DumpDot() = %v, want %v", buffer.String(), want)
+			}
+		}
+	})
+}
+
+func TestManager_ExecuteConfigSetting(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     *param.Params
+		wantStatus map[string]VertexStatus
+	}{
+		{name: "vip_exp", params: &param.Params{"EXP": 100},
+			wantStatus: map[string]VertexStatus{"vip": VertexOk, "not_new": VertexOk, "vip_exp": VertexOk}},
+		{name: "vip_no_exp", params: &param.Params{"EXP": 101},
+			wantStatus: map[string]VertexStatus{"vip": VertexOk, "not_new": VertexOk,
+				"vip_exp": VertexSkippedByExpectConfig}},
+	}
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	processor.Register("profile", func() processor.Processor { return &profile{} })
+	inits := atomic.LoadInt32(&profileInits)
+	if err := LoadFile("../../cmd/config_setting_test.toml"); err != nil {
+		t.Fatalf("Manager.LoadFile() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataContext := newTestDataContext()
+			result, err := ExecuteWithResult(context.Background(), "config_setting_test.toml", "enter",
+				dataContext, tt.params)
+			if err != nil {
+				t.Fatalf("ExecuteWithResult() error = %v", err)
+			}
+			for id, want := range tt.wantStatus {
+				if got := result.Vertexes[id].Status; got != want {
+					t.Errorf("ExecuteWithResult() %s status = %v, want %v", id, got, want)
+				}
+			}
+			v, _ := dataContext.Get(NewDIObjectKey("REQ", reflect.TypeOf(&testReq{})))
+			if calls := v.(reflect.Value).Interface().(*testReq).id[2] - 3; calls != 1 {
+				t.Errorf("ExecuteWithResult() profile processor calls = %v, want 1", calls)
+			}
+		})
+	}
+	// the processor initialized at build is reused by sequential requests
+	if got := atomic.LoadInt32(&profileInits) - inits; got != 1 {
+		t.Errorf("profile processor inits = %v, want 1", got)
+	}
+}
+
+func TestManager_LoadConfigSetting(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "cycle", content: `
+[[config_setting]]
+name = "a"
+cond = 'b && EXP == 1'
+[[config_setting]]
+name = "b"
+cond = '!a'
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+start = true
+processor = "phase7"
+expect_config = "a"
+`, wantErr: "config_setting cycle:a -> b -> a"},
+		{name: "bad_processor", content: `
+[[config_setting]]
+name = "a"
+processor = "phase7"
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+start = true
+processor = "phase7"
+expect_config = "a"
+`, wantErr: "config_setting:a processor:phase7 must have exactly one bool or map[string]bool output"},
+		{name: "flag_of_expr", content: `
+[[config_setting]]
+name = "a"
+cond = 'EXP == 1'
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+start = true
+processor = "phase7"
+expect_config = "a.vip"
+`, wantErr: "No config_setting with name:a.vip defined"},
+		{name: "param_type", content: `
+params = {EXP = "integer"}
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+start = true
+processor = "phase7"
+`, wantErr: "param:EXP invalid type:integer"},
+		{name: "strict_type", content: `
+strict_dsl = true
+params = {EXP = "int"}
+[[config_setting]]
+name = "a"
+cond = 'EXP > "1"'
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+id = "p7"
+start = true
+processor = "phase7"
+expect_config = "a"
+`, wantErr: "config_setting:a invalid cond:invalid operation: > (mismatched types int64 and string) (1:5)"},
+	}
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New().load(tt.name, []byte(tt.content), &TomlCodec{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Manager.load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCluster_BuildConfigSettingFileMeta(t *testing.T) {
+	// metas loaded from file have no field types
+	ops := []processor.OperatorMeta{{Name: "phase7"}, {Name: "profile", Output: []processor.FieldMeta{{Name: "Flags"}}},
+		{Name: "phase7_flags", Output: []processor.FieldMeta{{Name: "Flags"}}},
+		{Name: "not_registered", Output: []processor.FieldMeta{{Name: "Flags"}}}}
+	tests := []struct {
+		name      string
+		processor string
+		wantErr   string
+	}{
+		{name: "ok", processor: "profile"},
+		{name: "not_registered", processor: "not_registered",
+			wantErr: "config_setting:a processor:not_registered not registered"},
+		{name: "bad_output", processor: "phase7_flags",
+			wantErr: "config_setting:a processor:phase7_flags must have exactly one bool or map[string]bool output"},
+	}
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	processor.Register("phase7_flags", func() processor.Processor { return &phase7{} })
+	processor.Register("profile", func() processor.Processor { return &profile{} })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Cluster{Name: "file_meta_test.toml",
+				ConfigSetting: []ConfigSetting{{Name: "a", Processor: tt.processor}},
+				Graph: []Graph{{Name: "enter",
+					Vertex: []Vertex{{ID: "p7", Start: true, Processor: "phase7", ExpectConfig: "a.vip"}}}}}
+			err := c.Build(ops)
+			if (err != nil) != (len(tt.wantErr) > 0) || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Cluster.Build() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_ExecuteForeach(t *testing.T) {
+	tests := []struct {
+		name        string
+		graphName   string
+		wantStatus  VertexStatus
+		wantFailed  int
+		wantDropped []int
+		wantKey     DIObjectKey
+		want        interface{}
+	}{
+		{name: "slice", graphName: "slice", wantStatus: VertexOk,
+			wantKey: NewDIObjectKey("Merged", reflect.TypeOf([]string{})), want: []string{"a0", "b1", "c2"}},
+		{name: "map_drop", graphName: "map_drop", wantStatus: VertexOk, wantFailed: 1, wantDropped: []int{1},
+			wantKey: NewDIObjectKey("recall_map", reflect.TypeOf(map[string][]string{})),
+			want:    map[string][]string{"a": {"a0"}, "c": {"c2"}}},
+		{name: "fail_all", graphName: "fail_all", wantStatus: VertexErr, wantFailed: 1},
+		{name: "subgraph", graphName: "subgraph", wantStatus: VertexOk,
+			wantKey: NewDIObjectKey("sub_items", reflect.TypeOf([][]string{})), want: [][]string{{"a0"}, {"b1"}}},
+		{name: "slice_drop_keep_index", graphName: "slice_drop", wantStatus: VertexOk, wantFailed: 1,
+			wantDropped: []int{1},
+			wantKey:     NewDIObjectKey("recall_kept", reflect.TypeOf([][]string{})), want: [][]string{{"a0"}, nil, {"c2"}}},
+		{name: "map_duplicate", graphName: "map_duplicate", wantStatus: VertexErr},
+		{name: "map_key", graphName: "map_key", wantStatus: VertexOk,
+			wantKey: NewDIObjectKey("recall_by_name", reflect.TypeOf(map[string][]string{})),
+			want:    map[string][]string{"a": {"a"}, "b": {"b"}}},
+	}
+	processor.Register("recall_channels", func() processor.Processor { return &recallChannels{} })
+	processor.Register("recall_sources", func() processor.Processor { return &recallSources{} })
+	processor.Register("recall_source", func() processor.Processor { return &recallBySource{} })
+	processor.Register("recall", func() processor.Processor { return &recall{} })
+	processor.Register("merge_recall", func() processor.Processor { return &mergeRecall{} })
+	if err := LoadFile("../../cmd/foreach_test.toml"); err != nil {
+		t.Fatalf("Manager.LoadFile() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataContext := NewDataContext()
+			result, err := ExecuteWithResult(context.Background(), "foreach_test.toml", tt.graphName,
+				dataContext, &param.Params{})
+			if err != nil {
+				t.Fatalf("ExecuteWithResult() error = %v", err)
+			}
+			vr := result.Vertexes["recall"]
+			if vr.Status != tt.wantStatus || vr.FailedElements != tt.wantFailed ||
+				!reflect.DeepEqual(vr.DroppedElements, tt.wantDropped) {
+				t.Errorf("ExecuteWithResult() recall status = %v failed = %v dropped = %v, want %v %v %v",
+					vr.Status, vr.FailedElements, vr.DroppedElements, tt.wantStatus, tt.wantFailed, tt.wantDropped)
+			}
+			if tt.want == nil {
+				return
+			}
+			v, ok := dataContext.Get(tt.wantKey)
+			rv, isValue := v.(reflect.Value)
+			if !ok || !isValue || !reflect.DeepEqual(rv.Interface(), tt.want) {
+				t.Errorf("ExecuteWithResult() %v = %v, want %v", tt.wantKey.Name, v, tt.want)
+			}
+		})
+	}
+	loadTests := []struct {
+		name    string
+		foreach string
+		wantErr string
+	}{
+		{name: "uncomparable", foreach: `{input = "Sources", gather = "map"}`,
+			wantErr: "foreach gather key type:graph.recallSource is not comparable"},
+		{name: "unknown_key", foreach: `{input = "Sources", gather = "map", key = "ID"}`,
+			wantErr: "foreach key:ID is not an exported field of element type:graph.recallSource"},
+		{name: "key_of_slice", foreach: `{input = "Sources", key = "Name"}`,
+			wantErr: "foreach key is only supported by gather map"},
+	}
+	for _, tt := range loadTests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := `
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+processor = "recall_sources"
+start = true
+[[graph.vertex]]
+id = "recall"
+processor = "recall_source"
+foreach = ` + tt.foreach + "\n"
+			err := New().load(tt.name, []byte(content), &TomlCodec{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Manager.load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_ExecuteSwitch(t *testing.T) {
+	tests := []struct {
+		name   string
+		params *param.Params
+		wantOk []string
+	}{
+		{name: "34old", params: &param.Params{"user_type": "34old"}, wantOk: []string{"join", "old", "user_type"}},
+		{name: "12new", params: &param.Params{"user_type": "12new"},
+			wantOk: []string{"join", "new", "new_extra", "user_type"}},
+		{name: "default", params: &param.Params{"user_type": "unknown"}, wantOk: []string{"join", "other", "user_type"}},
+	}
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	if err := LoadFile("../../cmd/switch_test.toml"); err != nil {
+		t.Fatalf("Manager.LoadFile() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ExecuteWithResult(context.Background(), "switch_test.toml", "enter",
+				NewDataContext(), tt.params)
+			if err != nil {
+				t.Fatalf("ExecuteWithResult() error = %v", err)
+			}
+			var gotOk []string
+			for id, vr := range result.Vertexes {
+				if vr.Status == VertexOk {
+					gotOk = append(gotOk, id)
+				} else if vr.Status != VertexSkippedByDeps {
+					t.Errorf("ExecuteWithResult() %s status = %v, want ok or skipped_by_deps", id, vr.Status)
+				}
+			}
+			sort.Strings(gotOk)
+			if !reflect.DeepEqual(gotOk, tt.wantOk) {
+				t.Errorf("ExecuteWithResult() ok vertexes = %v, want %v", gotOk, tt.wantOk)
+			}
+		})
+	}
+	t.Run("dump_dot", func(t *testing.T) {
+		c := &Cluster{}
+		if _, err := toml.DecodeFile("../../cmd/switch_test.toml", c); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Build(processor.GenerateMetas()); err != nil {
+			t.Fatal(err)
+		}
+		buffer := &strings.Builder{}
+		c.DumpDot(buffer)
+		for _, want := range []string{
+			"enter_user_type -> enter_old [style=dashed label=\"34old\"]",
+			"enter_user_type -> enter_other [style=dashed label=\"default\"]",
+		} {
+			if !strings.Contains(buffer.String(), want) {
+				t.Errorf("Cluster.DumpDot() = %v, want %v", buffer.String(), want)
+			}
+		}
+	})
+}
+
+func TestManager_ExecuteJoin(t *testing.T) {
+	tests := []struct {
+		name         string
+		graphName    string
+		wantStatus   map[string]VertexStatus
+		wantAttempts map[string]int
+		maxDuration  time.Duration
+	}{
+		{name: "any_cancel_rest", graphName: "any",
+			wantStatus:  map[string]VertexStatus{"fast": VertexOk, "slow": VertexSkippedByCancel, "first": VertexOk},
+			maxDuration: 300 * time.Millisecond},
+		{name: "any_late", graphName: "any_late",
+			wantStatus:   map[string]VertexStatus{"fast": VertexOk, "slow": VertexOk, "first": VertexOk},
+			wantAttempts: map[string]int{"first": 1}},
+		{name: "quorum", graphName: "quorum",
+			wantStatus: map[string]VertexStatus{"a": VertexOk, "b": VertexOk, "c": VertexTimeout, "agree": VertexOk}},
+		{name: "quorum_fail", graphName: "quorum_fail",
+			wantStatus: map[string]VertexStatus{"a": VertexOk, "b": VertexTimeout, "c": VertexTimeout,
+				"agree": VertexSkippedByDeps}},
+		{name: "all_settled", graphName: "all_settled",
+			wantStatus: map[string]VertexStatus{"a": VertexOk, "b": VertexTimeout, "settled": VertexOk}},
+	}
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	if err := LoadFile("../../cmd/join_test.toml"); err != nil {
+		t.Fatalf("Manager.LoadFile() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			result, err := ExecuteWithResult(context.Background(), "join_test.toml", tt.graphName,
+				NewDataContext(), &param.Params{})
+			if err != nil {
+				t.Fatalf("ExecuteWithResult() error = %v", err)
+			}
+			if tt.maxDuration > 0 && time.Since(start) > tt.maxDuration {
+				t.Errorf("ExecuteWithResult() duration = %v, want < %v", time.Since(start), tt.maxDuration)
+			}
+			for id, want := range tt.wantStatus {
+				if got := result.Vertexes[id].Status; got != want {
+					t.Errorf("ExecuteWithResult() vertex:%v status = %v, want %v", id, got, want)
+				}
+			}
+			for id, want := range tt.wantAttempts {
+				if got := result.Vertexes[id].Attempts; got != want {
+					t.Errorf("ExecuteWithResult() vertex:%v attempts = %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+	loadTests := []struct {
+		name    string
+		join    string
+		wantErr string
+	}{
+		{name: "invalid_mode", join: `"first"`, wantErr: "invalid join:first"},
+		{name: "quorum_too_large", join: `{quorum = 3}`, wantErr: "invalid join quorum:3 with 2 dependencies"},
+		{name: "cancel_rest_all", join: `{mode = "all", cancel_rest = true}`,
+			wantErr: "join:all can NOT config quorum/cancel_rest"},
+		{name: "unknown_key", join: `{quorom = 2}`, wantErr: "unknown join key:quorom"},
+		{name: "cancel_rest_shared", join: `{mode = "any", cancel_rest = true}
+[[graph.vertex]]
+id = "d"
+processor = "phase7"
+deps = ["b"]`, wantErr: "join cancel_rest can NOT cancel dependency:b used by vertex:d"},
+	}
+	for _, tt := range loadTests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := `
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+id = "a"
+processor = "phase7"
+[[graph.vertex]]
+id = "b"
+processor = "phase7"
+[[graph.vertex]]
+id = "c"
+processor = "phase7"
+deps = ["a", "b"]
+join = ` + tt.join + "\n"
+			err := New().load(tt.name, []byte(content), &TomlCodec{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Manager.load() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_ExecuteScopeVariable(t *testing.T) {
+	content := `
+name = "scope_test.toml"
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+id = "probe"
+start = true
+processor = "limit_probe"
+output = [{field = "Out", id = "$out"}]
+`
+	tests := []struct {
+		name    string
+		params  *param.Params
+		opts    []ExecuteOption
+		wantID  string
+		wantErr string
+	}{
+		{name: "params_not_bound", params: &param.Params{"out": "x"}, wantErr: "unresolved variable:$out"},
+		{name: "scope", params: &param.Params{}, opts: []ExecuteOption{WithScope(&param.Params{"out": "x"})},
+			wantID: "x"},
+		{name: "params_not_rebind", params: &param.Params{"out": "y"},
+			opts: []ExecuteOption{WithScope(&param.Params{"out": "x"})}, wantID: "x"},
+	}
+	processor.Register("limit_probe", func() processor.Processor { return &limitProbe{} })
+	m := New()
+	if err := m.load("scope_test.toml", []byte(content), &TomlCodec{}); err != nil {
+		t.Fatalf("Manager.load() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataContext := NewDataContext()
+			_, err := m.execute(context.Background(), "scope_test.toml", "enter", dataContext, tt.params, nil,
+				tt.opts...)
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Manager.execute() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Manager.execute() error = %v", err)
+			}
+			for _, id := range []string{"x", "y", "out", "$out"} {
+				_, ok := dataContext.Get(NewDIObjectKey(id, reflect.TypeOf(0)))
+				if ok != (id == tt.wantID) {
+					t.Errorf("Manager.execute() output id:%v published = %v, want %v", id, ok, id == tt.wantID)
+				}
+			}
+		})
+	}
+}
+
+// abandonProbe sleep GLOBAL sleep ms ignoring ctx, record inits and max concurrent executions
+type abandonProbe struct {
+	Out int `graph:"output"`
+}
+
+var abandonProbeInits, abandonProbeRunning, abandonProbePeak int32
+
+func (p *abandonProbe) OnInit() {
+	atomic.AddInt32(&abandonProbeInits, 1)
+}
+
+func (p *abandonProbe) OnExecute(_ context.Context, params *param.Params) error {
+	storeMax(&abandonProbePeak, atomic.AddInt32(&abandonProbeRunning, 1))
+	defer atomic.AddInt32(&abandonProbeRunning, -1)
+	global, _ := (*params)["GLOBAL"].(param.Params)
+	time.Sleep(time.Duration(global.GetInt64("sleep")) * time.Millisecond)
+	p.Out = 1
+	return nil
+}
+
+func TestManager_ExecuteTimeoutAbandon(t *testing.T) {
+	content := `
+name = "abandon_test.toml"
+default_context_pool_size = 1
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+id = "probe"
+start = true
+processor = "abandon_probe"
+timeout = "10ms"
+output = [{field = "Out", id = "$out"}]
+`
+	processor.Register("abandon_probe", func() processor.Processor { return &abandonProbe{} })
+	pool := NewWorkerPoolScheduler(2, map[string]int{"abandon_test.toml": 1})
+	defer pool.Stop()
+	m := New()
+	m.SetScheduler(pool)
+	atomic.StoreInt32(&abandonProbeInits, 0)
+	if err := m.load("abandon_test.toml", []byte(content), &TomlCodec{}); err != nil {
+		t.Fatalf("Manager.load() error = %v", err)
+	}
+	inits := atomic.LoadInt32(&abandonProbeInits)
+	for i, tt := range []struct {
+		sleep      int64
+		out        string
+		wantStatus VertexStatus
+	}{
+		{sleep: 50, out: "x", wantStatus: VertexTimeout},
+		// the abandoned processor keeps the only slot, the next execution waits for it
+		{sleep: 0, out: "y", wantStatus: VertexOk},
+		{sleep: 50, out: "x", wantStatus: VertexTimeout},
+		{sleep: 0, out: "z", wantStatus: VertexOk},
+	} {
+		dataContext := NewDataContext()
+		result, err := m.execute(context.Background(), "abandon_test.toml", "enter", dataContext,
+			&param.Params{"sleep": tt.sleep}, nil, WithScope(&param.Params{"out": tt.out}))
+		if err != nil {
+			t.Fatalf("execute %d: Manager.execute() error = %v", i, err)
+		}
+		if got := result.Vertexes["probe"].Status; got != tt.wantStatus {
+			t.Errorf("execute %d: probe status = %v, want %v", i, got, tt.wantStatus)
+		}
+		v, _ := dataContext.Get(NewDIObjectKey(tt.out, reflect.TypeOf(0)))
+		if _, ok := v.(reflect.Value); ok != (tt.wantStatus == VertexOk) {
+			t.Errorf("execute %d: output %v published = %v", i, tt.out, ok)
+		}
+	}
+	if peak := atomic.LoadInt32(&abandonProbePeak); peak > 1 {
+		t.Errorf("abandoned processor runs beyond cluster limit, peak = %d", peak)
+	}
+	// one spare replaces the abandoned processor, which is reused once it returns
+	if got := atomic.LoadInt32(&abandonProbeInits) - inits; got != 1 {
+		t.Errorf("processor inits after timeouts = %d, want 1", got)
+	}
+}
+
+func TestCluster_DumpResultDot(t *testing.T) {
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	if err := LoadFile("../../cmd/timeout_test.toml"); err != nil {
+		t.Fatalf("Manager.LoadFile() error = %v", err)
+	}
+	trace := &Trace{}
+	result, err := ExecuteWithResult(context.Background(), "timeout_test.toml", "vertex_timeout",
+		NewDataContext(), &param.Params{}, WithTrace(trace))
+	if err != nil {
+		t.Fatalf("ExecuteWithResult() error = %v", err)
+	}
+	c := DefaultManager.snapshot()["timeout_test.toml"]
+	for name, r := range map[string]*ExecuteResult{"result": result, "trace": trace.ExecuteResult()} {
+		t.Run(name, func(t *testing.T) {
+			buffer := &strings.Builder{}
+			c.DumpResultDot(buffer, r)
+			dot := buffer.String()
+			for _, want := range []string{
+				"\\ntimeout ",
+				"\\ncode:-10001\" fillcolor=orange color=red penwidth=3 style=\"filled,bold\"",
+				"vertex_timeout__START__ -> vertex_timeout_slow [style=bold color=red penwidth=3]",
+				"vertex_timeout_slow -> vertex_timeout_on_ok [style=dotted color=grey label=\"ok\"]",
+				"on_ok\\nskipped_by_deps\" fillcolor=lightgrey",
+			} {
+				if !strings.Contains(dot, want) {
+					t.Errorf("Cluster.DumpResultDot() = %v, want %v", dot, want)
+				}
+			}
+			if !strings.Contains(dot, "vertex_timeout_slow -> vertex_timeout_on_err [style=bold color=") {
+				t.Errorf("Cluster.DumpResultDot() = %v, want taken edge slow -> on_err", dot)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// default buckets of duration histograms in seconds
+var defaultMetricsBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metric types of text exposition
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricSeries one series of family, counts are per bucket of histogram
+type metricSeries struct {
+	labels []string
+	value  float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// metricFamily metrics with the same name and label names
+type metricFamily struct {
+	name   string
+	kind   string
+	help   string
+	labels []string
+	series map[string]*metricSeries
+}
+
+func newMetricFamily(name string, kind string, help string, labels ...string) *metricFamily {
+	return &metricFamily{name: name, kind: kind, help: help, labels: labels, series: make(map[string]*metricSeries)}
+}
+
+// get series of label values, created on first use
+func (f *metricFamily) get(values ...string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labels: values}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *metricFamily) formatLabels(values []string, extra ...string) string {
+	if len(f.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(f.labels)+len(extra)/2)
+	for i, name := range f.labels {
+		pairs = append(pairs, name+`="`+labelValueEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+extra[i+1]+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// write family in text exposition format, series sorted by labels
+func (f *metricFamily) write(buffer *bytes.Buffer, buckets []float64) {
+	if len(f.series) == 0 {
+		return
+	}
+	fmt.Fprintf(buffer, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != metricHistogram {
+			fmt.Fprintf(buffer, "%s%s %s\n", f.name, f.formatLabels(s.labels), formatMetricValue(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, le := range buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(buffer, "%s_bucket%s %d\n", f.name,
+				f.formatLabels(s.labels, "le", formatMetricValue(le)), cumulative)
+		}
+		fmt.Fprintf(buffer, "%s_bucket%s %d\n", f.name, f.formatLabels(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(buffer, "%s_sum%s %s\n", f.name, f.formatLabels(s.labels), formatMetricValue(s.sum))
+		fmt.Fprintf(buffer, "%s_count%s %d\n", f.name, f.formatLabels(s.labels), s.count)
+	}
+}
+
+// Metrics engine metrics of a manager collected from its events, served in prometheus text format
+type Metrics struct {
+	manager       *Manager
+	buckets       []float64
+	executions    *metricFamily
+	graphLatency  *metricFamily
+	vertexLatency *metricFamily
+	skipped       *metricFamily
+	errors        *metricFamily
+	inFlight      *metricFamily
+	lock          sync.Mutex
+}
+
+// NewMetrics create metrics of manager and register it as event sink,
+// buckets of duration histograms in seconds, default 1ms to 10s
+func NewMetrics(m *Manager, buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = defaultMetricsBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	s := &Metrics{
+		manager: m,
+		buckets: buckets,
+		executions: newMetricFamily("dag_graph_executions_total", metricCounter,
+			"Graph executions, subgraphs included.", "cluster", "graph", "result"),
+		graphLatency: newMetricFamily("dag_graph_duration_seconds", metricHistogram,
+			"Graph execution latency.", "cluster", "graph"),
+		vertexLatency: newMetricFamily("dag_vertex_duration_seconds", metricHistogram,
+			"Latency of executed vertexes.", "cluster", "graph", "vertex", "processor"),
+		skipped: newMetricFamily("dag_vertex_skipped_total", metricCounter,
+			"Skipped vertexes by reason.", "cluster", "graph", "vertex", "reason"),
+		errors: newMetricFamily("dag_vertex_errors_total", metricCounter,
+			"Failed vertexes by innererror code.", "cluster", "graph", "vertex", "processor", "code"),
+		inFlight: newMetricFamily("dag_executions_in_flight", metricGauge,
+			"Entry graph executions running.", "cluster", "graph"),
+	}
+	m.AddEventSink(s)
+	return s
+}
+
+func (s *Metrics) observe(series *metricSeries, seconds float64) {
+	if series.counts == nil {
+		series.counts = make([]uint64, len(s.buckets))
+	}
+	if i := sort.SearchFloat64s(s.buckets, seconds); i < len(s.buckets) {
+		series.counts[i]++
+	}
+	series.count++
+	series.sum += seconds
+}
+
+// OnEvent update metrics by event
+func (s *Metrics) OnEvent(e *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch e.Kind {
+	case EventGraphStart:
+		if e.Depth == 0 {
+			s.inFlight.get(e.Cluster, e.Graph).value++
+		}
+	case EventGraphFinish:
+		if e.Depth == 0 {
+			s.inFlight.get(e.Cluster, e.Graph).value--
+		}
+		result := "ok"
+		if e.Err != nil {
+			result = "err"
+		}
+		s.executions.get(e.Cluster, e.Graph, result).value++
+		s.observe(s.graphLatency.get(e.Cluster, e.Graph), e.Duration.Seconds())
+	case EventVertex:
+		if e.Status.IsSkipped() {
+			s.skipped.get(e.Cluster, e.Graph, e.Vertex, e.Status.String()).value++
+			return
+		}
+		s.observe(s.vertexLatency.get(e.Cluster, e.Graph, e.Vertex, e.Processor), e.Duration.Seconds())
+		if e.Status.IsFailed() {
+			s.errors.get(e.Cluster, e.Graph, e.Vertex, e.Processor, strconv.Itoa(int(e.Code))).value++
+		}
+	}
+}
+
+// poolFamilies cluster context pool and dropped events metrics read at scrape time
+func (s *Metrics) poolFamilies() []*metricFamily {
+	hits := newMetricFamily("dag_cluster_context_pool_hits_total", metricCounter,
+		"Cluster contexts reused from pool.", "cluster")
+	misses := newMetricFamily("dag_cluster_context_pool_misses_total", metricCounter,
+		"Cluster contexts created as pool is empty.", "cluster")
+	waits := newMetricFamily("dag_cluster_context_pool_waits_total", metricCounter,
+		"Gets blocked by full pool.", "cluster")
+	rejected := newMetricFamily("dag_cluster_context_pool_rejected_total", metricCounter,
+		"Gets rejected by full pool.", "cluster")
+	evicted := newMetricFamily("dag_cluster_context_pool_evicted_total", metricCounter,
+		"Idle cluster contexts evicted.", "cluster")
+	overflow := newMetricFamily("dag_cluster_context_pool_overflow_total", metricCounter,
+		"Cluster contexts created above max for re-entrant subgraph calls.", "cluster")
+	size := newMetricFamily("dag_cluster_context_pool_size", metricGauge,
+		"Idle cluster contexts in pool.", "cluster")
+	inUse := newMetricFamily("dag_cluster_context_pool_in_use", metricGauge,
+		"Cluster contexts in use.", "cluster")
+	for name, c := range s.manager.snapshot() {
+		if c.ClusterContextPool == nil {
+			continue
+		}
+		stats := c.ClusterContextPool.Stats()
+		hits.get(name).value = float64(stats.Hits)
+		misses.get(name).value = float64(stats.Misses)
+		waits.get(name).value = float64(stats.Waits)
+		rejected.get(name).value = float64(stats.Rejected)
+		evicted.get(name).value = float64(stats.Evicted)
+		overflow.get(name).value = float64(stats.Overflow)
+		size.get(name).value = float64(stats.Size)
+		inUse.get(name).value = float64(stats.InUse)
+	}
+	dropped := newMetricFamily("dag_events_dropped_total", metricCounter,
+		"Events dropped by full default event chan or event sinks of manager.", "sink")
+	dropped.get("default").value = float64(DroppedEvents())
+	dropped.get("manager").value = float64(s.manager.DroppedEvents())
+	return []*metricFamily{hits, misses, waits, rejected, evicted, overflow, size, inUse, dropped}
+}
+
+// WriteTo write metrics in prometheus text exposition format
+func (s *Metrics) WriteTo(w io.Writer) (int64, error) {
+	buffer := &bytes.Buffer{}
+	s.lock.Lock()
+	for _, f := range []*metricFamily{s.executions, s.graphLatency, s.inFlight, s.vertexLatency, s.skipped, s.errors} {
+		f.write(buffer, s.buckets)
+	}
+	s.lock.Unlock()
+	for _, f := range s.poolFamilies() {
+		f.write(buffer, s.buckets)
+	}
+	return buffer.WriteTo(w)
+}
+
+// ServeHTTP serve metrics in prometheus text exposition format
+func (s *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := s.WriteTo(w); err != nil {
+		log.Printf("write metrics err:%v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/dagengine/engine/processor"
+)
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	m := New()
+	if err := m.loadFile("../../cmd/event_test.toml", &TomlCodec{}); err != nil {
+		t.Fatalf("Manager.loadFile() error = %v", err)
+	}
+	metrics := NewMetrics(m, 10, 0.001, 0.01)
+	for i := 0; i < 2; i++ {
+		if _, err := m.execute(context.Background(), "event_test.toml", "enter", NewDataContext(),
+			&param.Params{}, nil); err != nil {
+			t.Fatalf("Manager.execute() error = %v", err)
+		}
+	}
+	metrics.OnEvent(&Event{Kind: EventVertex, Cluster: "event_test.toml", Graph: "enter", Vertex: "d",
+		Processor: "phase\"8", Status: VertexErr, Code: 3, Duration: 2 * time.Millisecond})
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Metrics.ServeHTTP() content type = %v", got)
+	}
+	body, _ := io.ReadAll(w.Body)
+	stats := m.snapshot()["event_test.toml"].ClusterContextPool.Stats()
+	wants := []string{
+		"# TYPE dag_graph_executions_total counter",
+		`dag_graph_executions_total{cluster="event_test.toml",graph="enter",result="ok"} 2`,
+		`dag_graph_executions_total{cluster="event_test.toml",graph="sub",result="ok"} 2`,
+		`dag_graph_duration_seconds_count{cluster="event_test.toml",graph="enter"} 2`,
+		`dag_executions_in_flight{cluster="event_test.toml",graph="enter"} 0`,
+		`dag_vertex_duration_seconds_count{cluster="event_test.toml",graph="enter",vertex="a",processor="phase7"} 2`,
+		`dag_vertex_duration_seconds_count{cluster="event_test.toml",graph="sub",vertex="x",processor="phase7"} 2`,
+		`dag_vertex_skipped_total{cluster="event_test.toml",graph="enter",vertex="b",reason="skipped_by_deps"} 2`,
+		`dag_vertex_errors_total{cluster="event_test.toml",graph="enter",vertex="d",processor="phase\"8",code="3"} 1`,
+		`dag_vertex_duration_seconds_bucket{cluster="event_test.toml",graph="enter",vertex="d",processor="phase\"8",le="0.001"} 0`,
+		`dag_vertex_duration_seconds_bucket{cluster="event_test.toml",graph="enter",vertex="d",processor="phase\"8",le="0.01"} 1`,
+		`dag_vertex_duration_seconds_bucket{cluster="event_test.toml",graph="enter",vertex="d",processor="phase\"8",le="+Inf"} 1`,
+		fmt.Sprintf(`dag_cluster_context_pool_hits_total{cluster="event_test.toml"} %d`, stats.Hits),
+		fmt.Sprintf(`dag_cluster_context_pool_misses_total{cluster="event_test.toml"} %d`, stats.Misses),
+		fmt.Sprintf(`dag_cluster_context_pool_size{cluster="event_test.toml"} %d`, stats.Size),
+		`dag_cluster_context_pool_in_use{cluster="event_test.toml"} 0`,
+		`dag_events_dropped_total{sink="manager"} 0`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("Metrics.ServeHTTP() missing %s in\n%s", want, body)
+		}
+	}
+	if stats.Hits+stats.Misses != 4 {
+		t.Errorf("ClusterContextPool.Stats() = %+v, want 4 gets", stats)
+	}
+}
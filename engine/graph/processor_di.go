@@ -3,7 +3,9 @@ package graph
 import (
 	"fmt"
 	"reflect"
+	"sync"
 
+	"xxxx/dagengine/engine/param"
 	"xxxx/dagengine/engine/processor"
 )
 
@@ -12,85 +14,295 @@ const (
 	cMultiInput  = "multi_input"
 	cExternInput = "extern_input"
 	cOutput      = "output"
+	cElement     = "element"
+	cIndex       = "index"
+	// input and output structs of typed processor
+	cIn  = "in"
+	cOut = "out"
 )
 
+// data keys of the current element and its index in the data context of a foreach element
+var (
+	foreachElementKey = DIObjectKey{Name: "$ELEMENT"}
+	foreachIndexKey   = DIObjectKey{Name: "$INDEX"}
+)
+
+// diField processor field with graph tag
+type diField struct {
+	index []int
+	name  string
+	tag   string
+	typ   reflect.Type
+	// key of extern input
+	externKey DIObjectKey
+}
+
+// diPlan fields of processor type read by reflection once, executions run against it
+type diPlan struct {
+	// settable fields reset before every execution, pointers inside input and output structs included
+	settable [][]int
+	// tagged fields in declared order, and by name
+	fields []*diField
+	byName map[string]*diField
+}
+
+// diPlans plan of every processor type
+var diPlans sync.Map
+
+// getDIPlan plan of processor type, built on first use
+func getDIPlan(rType reflect.Type) *diPlan {
+	if plan, ok := diPlans.Load(rType); ok {
+		return plan.(*diPlan)
+	}
+	t := GetNoPtrType(rType)
+	plan := &diPlan{byName: make(map[string]*diField)}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		plan.settable = append(plan.settable, []int{i})
+		if tag := sf.Tag.Get("graph"); (tag != cIn && tag != cOut) || sf.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < sf.Type.NumField(); j++ {
+			if sub := sf.Type.Field(j); sub.IsExported() && sub.Type.Kind() == reflect.Ptr {
+				plan.settable = append(plan.settable, []int{i, j})
+			}
+		}
+	}
+	for _, pf := range processor.Fields(t) {
+		f := &diField{index: pf.Index, name: pf.Name, tag: pf.Tag, typ: pf.Type}
+		if pf.Tag == cExternInput {
+			f.externKey = NewDIObjectKey(pf.Name, pf.Type)
+		}
+		plan.fields = append(plan.fields, f)
+		plan.byName[pf.Name] = f
+	}
+	plan2, _ := diPlans.LoadOrStore(rType, plan)
+	return plan2.(*diPlan)
+}
+
+// boundField field of plan with data key of the vertex
+type boundField struct {
+	*diField
+	key *DIObjectKey
+}
+
 // ProcessorDI processor di for execute
 type ProcessorDI struct {
 	Processor processor.Processor
 	InputIDs  map[string]*DIObjectKey
 	OutputIDs map[string]*DIObjectKey
+	ExternIDs map[string]bool
+
+	variableIDs map[*DIObjectKey]string
+	// aggregates aggregate ids of multi inputs by field, '$' ids are rebound by BindIDs
+	aggregates   map[string][]string
+	variableAggs []variableAggregate
+	// plan of processor type, inputs and outputs bound to data keys of the vertex
+	plan    *diPlan
+	inputs  []boundField
+	outputs []boundField
 }
 
-// Reset reset after execute
-func (p *ProcessorDI) Reset() {
-	rType := reflect.TypeOf(p.Processor)
+func (p *ProcessorDI) getPlan() *diPlan {
+	if p.plan == nil {
+		p.plan = getDIPlan(reflect.TypeOf(p.Processor))
+	}
+	return p.plan
+}
+
+// processorValue struct value of processor
+func (p *ProcessorDI) processorValue() reflect.Value {
 	rVal := reflect.ValueOf(p.Processor)
-	if rType.Kind() == reflect.Ptr {
-		rType = rType.Elem()
+	if rVal.Kind() == reflect.Ptr {
 		rVal = rVal.Elem()
 	}
-	for i := 0; i < rType.NumField(); i++ {
-		f := rVal.Field(i)
-		if f.CanSet() {
-			// reset init
-			if f.Kind() == reflect.Ptr {
-				f.Set(reflect.New(f.Type().Elem()))
-			} else {
-				f.Set(reflect.Zero(f.Type()))
-			}
+	return rVal
+}
+
+// variableAggregate '$' id at index of the aggregate ids of field
+type variableAggregate struct {
+	field string
+	index int
+	name  string
+}
+
+func isVariable(name string) bool {
+	return len(name) > 1 && name[0] == '$'
+}
+
+// lookupVariable value of '$name' in scope, name itself and false if not a variable or not in scope
+func lookupVariable(name string, scope *param.Params) (string, bool) {
+	if !isVariable(name) || scope == nil {
+		return name, false
+	}
+	v, ok := (*scope)[name[1:]]
+	if !ok {
+		return name, false
+	}
+	if str, ok := v.(string); ok {
+		return str, true
+	}
+	return fmt.Sprint(v), true
+}
+
+// resolveVariable resolve '$name' by scope, the args of calling subgraph vertex or of execution option,
+// params of request never rebind data ids
+func resolveVariable(name string, scope *param.Params) (string, error) {
+	if v, ok := lookupVariable(name, scope); ok || !isVariable(name) {
+		return v, nil
+	}
+	return name, fmt.Errorf("unresolved variable:%s", name)
+}
+
+// BindIDs rebind '$' variable data ids with scope
+func (p *ProcessorDI) BindIDs(scope *param.Params) error {
+	for id, name := range p.variableIDs {
+		resolved, err := resolveVariable(name, scope)
+		if err != nil {
+			return fmt.Errorf("processor:%T data id %w", p.Processor, err)
+		}
+		id.Name = resolved
+	}
+	for _, agg := range p.variableAggs {
+		resolved, err := resolveVariable(agg.name, scope)
+		if err != nil {
+			return fmt.Errorf("processor:%T aggregate id %w", p.Processor, err)
 		}
+		p.aggregates[agg.field][agg.index] = resolved
 	}
+	return nil
 }
 
-// InjectInput inject input for processor
-func (p *ProcessorDI) InjectInput(dataContext *DataContext, metas []Unit) {
-	rType := reflect.TypeOf(p.Processor)
-	rVal := reflect.ValueOf(p.Processor)
-	if rType.Kind() == reflect.Ptr {
-		rType = rType.Elem()
-		rVal = rVal.Elem()
+// Reset reset after execute
+func (p *ProcessorDI) Reset() {
+	rVal := p.processorValue()
+	if !rVal.CanAddr() {
+		return
 	}
-	mMetas := make(map[string]*Unit, len(metas))
-	for i := range metas {
-		mMetas[metas[i].Field] = &metas[i]
+	for _, index := range p.getPlan().settable {
+		f := rVal.FieldByIndex(index)
+		// reset init
+		if f.Kind() == reflect.Ptr {
+			f.Set(reflect.New(f.Type().Elem()))
+		} else {
+			f.SetZero()
+		}
 	}
-	for i := 0; i < rType.NumField(); i++ {
-		t := rType.Field(i)
-		f := rVal.Field(i)
-		tag := t.Tag.Get("graph")
+}
+
+// InjectInput inject input for processor
+func (p *ProcessorDI) InjectInput(dataContext *DataContext, metas []Unit) {
+	rVal := p.processorValue()
+	for _, in := range p.inputs {
+		f := rVal.FieldByIndex(in.index)
+		tag := in.tag
 		if tag == cInput {
-			if v, ok := dataContext.Get(*p.InputIDs[t.Name]); ok {
+			if v, ok := dataContext.Get(*in.key); ok {
 				p.setInput(f, v)
 			} else {
 				p.resetInput(f)
 			}
 		} else if tag == cMultiInput {
-			p.setMultiInput(dataContext, mMetas, f, t)
+			p.setMultiInput(dataContext, f, in.diField)
 		} else if tag == cExternInput {
-			externKey := NewDIObjectKey(t.Name, t.Type)
-			if v, ok := dataContext.Get(externKey); ok {
+			if v, ok := dataContext.Get(in.externKey); ok {
 				p.setInput(f, v)
-			} else if v, ok := GlobalDataContext.Get(externKey); ok {
+			} else if v, ok := GlobalDataContext.Get(in.externKey); ok {
 				p.setInput(f, v)
 			} else {
 				p.resetInput(f)
 			}
+		} else if tag == cElement || tag == cIndex {
+			key := foreachElementKey
+			if tag == cIndex {
+				key = foreachIndexKey
+			}
+			if v, ok := dataContext.Get(key); ok {
+				p.setElement(f, v)
+			} else {
+				p.resetInput(f)
+			}
 		}
 	}
 }
 
 // CollectOutput collect output for processor
 func (p *ProcessorDI) CollectOutput(dataContext *DataContext) {
-	rType := reflect.TypeOf(p.Processor)
-	rVal := reflect.ValueOf(p.Processor)
-	if rType.Kind() == reflect.Ptr {
-		rType = rType.Elem()
-		rVal = rVal.Elem()
+	p.CollectOutputIf(dataContext, nil)
+}
+
+// CollectOutputIf collect output fields accepted by publish, nil publish accepts all
+func (p *ProcessorDI) CollectOutputIf(dataContext *DataContext, publish func(field string) bool) {
+	rVal := p.processorValue()
+	for _, out := range p.outputs {
+		if publish != nil && !publish(out.name) {
+			continue
+		}
+		// store a copy so later executions of this processor do not overwrite published data
+		dataContext.Set(*out.key, reflect.ValueOf(rVal.FieldByIndex(out.index).Interface()))
 	}
-	for i := 0; i < rType.NumField(); i++ {
-		t := rType.Field(i)
-		if t.Tag.Get("graph") == cOutput {
-			dataContext.Set(*p.OutputIDs[t.Name], rVal.Field(i))
+}
+
+// getInputKeys data keys of input unit, one key for every aggregate id
+func (p *ProcessorDI) getInputKeys(unit *Unit) []DIObjectKey {
+	if len(unit.Aggregate) == 0 {
+		if id, ok := p.InputIDs[unit.Field]; ok {
+			return []DIObjectKey{*id}
+		}
+		return nil
+	}
+	t, ok := p.getPlan().byName[unit.Field]
+	if !ok || t.typ.Kind() != reflect.Map {
+		return nil
+	}
+	aggregates := p.aggregates[unit.Field]
+	keys := make([]DIObjectKey, 0, len(aggregates))
+	for _, agg := range aggregates {
+		keys = append(keys, NewDIObjectKey(agg, t.typ.Elem()))
+	}
+	return keys
+}
+
+func isZeroData(v interface{}) bool {
+	rv, ok := v.(reflect.Value)
+	if !ok || !rv.IsValid() {
+		return true
+	}
+	if rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return true
+		}
+		rv = rv.Elem()
+	}
+	return rv.IsZero()
+}
+
+// CheckRequiredInput check required inputs are produced with non zero value
+func (p *ProcessorDI) CheckRequiredInput(dataContext *DataContext, metas []Unit) error {
+	for i := range metas {
+		if !metas[i].Required {
+			continue
+		}
+		for _, key := range p.getInputKeys(&metas[i]) {
+			if v, ok := dataContext.Get(key); !ok || isZeroData(v) {
+				return fmt.Errorf("required input:%s data id:%s not produced", metas[i].Field, key.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveMovedInput remove data of move inputs from data context, the processor owns them
+func (p *ProcessorDI) RemoveMovedInput(dataContext *DataContext, metas []Unit) {
+	for i := range metas {
+		if !metas[i].Move {
+			continue
+		}
+		for _, key := range p.getInputKeys(&metas[i]) {
+			dataContext.Delete(key)
 		}
 	}
 }
@@ -98,26 +310,57 @@ func (p *ProcessorDI) CollectOutput(dataContext *DataContext) {
 // PrepareInput register input ids
 func (p *ProcessorDI) PrepareInput(inputs []Unit) error {
 	p.InputIDs = make(map[string]*DIObjectKey)
-	return p.SetUpIDs("input", inputs, p.InputIDs)
+	p.ExternIDs = make(map[string]bool)
+	p.aggregates = make(map[string][]string)
+	p.variableAggs = p.variableAggs[:0]
+	for _, input := range inputs {
+		if input.IsExtern {
+			p.ExternIDs[input.Field] = true
+		}
+		if len(input.Aggregate) == 0 {
+			continue
+		}
+		p.aggregates[input.Field] = append([]string(nil), input.Aggregate...)
+		for i, agg := range input.Aggregate {
+			if isVariable(agg) {
+				p.variableAggs = append(p.variableAggs, variableAggregate{field: input.Field, index: i, name: agg})
+			}
+		}
+	}
+	if err := p.SetUpIDs("input", inputs, p.InputIDs); err != nil {
+		return err
+	}
+	p.inputs = p.inputs[:0]
+	for _, f := range p.getPlan().fields {
+		switch f.tag {
+		case cInput, cMultiInput, cExternInput, cElement, cIndex:
+			p.inputs = append(p.inputs, boundField{diField: f, key: p.InputIDs[f.name]})
+		}
+	}
+	return nil
 }
 
 // PrepareOutput register output ids
 func (p *ProcessorDI) PrepareOutput(outputs []Unit) error {
 	p.OutputIDs = make(map[string]*DIObjectKey)
-	return p.SetUpIDs("output", outputs, p.OutputIDs)
+	if err := p.SetUpIDs("output", outputs, p.OutputIDs); err != nil {
+		return err
+	}
+	p.outputs = p.outputs[:0]
+	for _, f := range p.getPlan().fields {
+		if f.tag == cOutput {
+			p.outputs = append(p.outputs, boundField{diField: f, key: p.OutputIDs[f.name]})
+		}
+	}
+	return nil
 }
 
 // SetUpIDs set up ids
 func (p *ProcessorDI) SetUpIDs(tag string, cfgs []Unit, ids map[string]*DIObjectKey) error {
-	rType := reflect.TypeOf(p.Processor)
-	if rType.Kind() == reflect.Ptr {
-		rType = rType.Elem()
-	}
-	for i := 0; i < rType.NumField(); i++ {
-		t := rType.Field(i)
-		if t.Tag.Get("graph") == tag {
-			dikey := NewDIObjectKey(t.Name, t.Type)
-			ids[t.Name] = &dikey
+	for _, f := range p.getPlan().fields {
+		if f.tag == tag {
+			dikey := NewDIObjectKey(f.name, f.typ)
+			ids[f.name] = &dikey
 		}
 	}
 	for _, cfg := range cfgs {
@@ -129,6 +372,12 @@ func (p *ProcessorDI) SetUpIDs(tag string, cfgs []Unit, ids map[string]*DIObject
 			return fmt.Errorf("processor:%v not find field:%v", p.Processor, cfg.Field)
 		}
 		id.Name = cfg.ID
+		if isVariable(cfg.ID) {
+			if p.variableIDs == nil {
+				p.variableIDs = make(map[*DIObjectKey]string)
+			}
+			p.variableIDs[id] = cfg.ID
+		}
 	}
 	return nil
 }
@@ -149,6 +398,21 @@ func (p *ProcessorDI) setInput(f reflect.Value, v interface{}) {
 	f.Set(rv)
 }
 
+// setElement set foreach element or index, elements of other types are ignored
+func (p *ProcessorDI) setElement(f reflect.Value, v interface{}) {
+	rv, ok := v.(reflect.Value)
+	if !ok {
+		return
+	}
+	if rv.Type().AssignableTo(f.Type()) {
+		f.Set(rv)
+	} else if f.Kind() == reflect.Ptr && rv.Type().AssignableTo(f.Type().Elem()) {
+		f.Elem().Set(rv)
+	} else if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Type().AssignableTo(f.Type()) {
+		f.Set(rv.Elem())
+	}
+}
+
 func (p *ProcessorDI) resetInput(f reflect.Value) {
 	// reset
 	if f.Kind() == reflect.Ptr {
@@ -159,15 +423,14 @@ func (p *ProcessorDI) resetInput(f reflect.Value) {
 	}
 }
 
-func (p *ProcessorDI) setMultiInput(dataContext *DataContext, mMetas map[string]*Unit,
-	rVal reflect.Value, t reflect.StructField) {
-	meta, ok := mMetas[t.Name]
+func (p *ProcessorDI) setMultiInput(dataContext *DataContext, rVal reflect.Value, t *diField) {
+	aggregates, ok := p.aggregates[t.name]
 	if !ok {
 		return
 	}
-	f := reflect.MakeMap(t.Type)
-	for _, agg := range meta.Aggregate {
-		if v, ok := dataContext.Get(NewDIObjectKey(agg, t.Type.Elem())); ok {
+	f := reflect.MakeMap(t.typ)
+	for _, agg := range aggregates {
+		if v, ok := dataContext.Get(NewDIObjectKey(agg, t.typ.Elem())); ok {
 			rv, ok := v.(reflect.Value)
 			if !ok {
 				continue
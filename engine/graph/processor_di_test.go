@@ -0,0 +1,116 @@
+package graph
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"xxxx/dagengine/engine/param"
+)
+
+type diBench struct {
+	A     *s            `graph:"input"`
+	B     []string      `graph:"input"`
+	C     int           `graph:"input"`
+	M     map[string]*s `graph:"multi_input"`
+	REQ   *testReq      `graph:"extern_input"`
+	O1    *s            `graph:"output"`
+	O2    []int         `graph:"output"`
+	O3    string        `graph:"output"`
+	calls int
+}
+
+func (p *diBench) OnInit() {
+}
+
+func (p *diBench) OnExecute(_ context.Context, _ *param.Params) error {
+	p.calls++
+	p.O1.i = p.A.i + len(p.M)
+	p.O2 = append(p.O2, p.C)
+	p.O3 = p.REQ.name
+	return nil
+}
+
+func newDIBench(b testing.TB) (*ProcessorDI, *DataContext, []Unit) {
+	inputs := []Unit{{Field: "A", ID: "a"}, {Field: "B", ID: "b"}, {Field: "C", ID: "c"},
+		{Field: "M", Aggregate: []string{"m1", "$m"}}}
+	outputs := []Unit{{Field: "O1", ID: "o1"}, {Field: "O2", ID: "o2"}, {Field: "O3", ID: "$o3"}}
+	di := &ProcessorDI{Processor: &diBench{}}
+	if err := di.PrepareInput(inputs); err != nil {
+		b.Fatal(err)
+	}
+	if err := di.PrepareOutput(outputs); err != nil {
+		b.Fatal(err)
+	}
+	if err := di.BindIDs(&param.Params{"m": "m2", "o3": "name"}); err != nil {
+		b.Fatal(err)
+	}
+	dataContext := newTestDataContext()
+	dataContext.Set(NewDIObjectKey("a", reflect.TypeOf(&s{})), reflect.ValueOf(&s{i: 1}))
+	dataContext.Set(NewDIObjectKey("b", reflect.TypeOf([]string{})), reflect.ValueOf([]string{"b"}))
+	dataContext.Set(NewDIObjectKey("c", reflect.TypeOf(0)), reflect.ValueOf(3))
+	dataContext.Set(NewDIObjectKey("m1", reflect.TypeOf(&s{})), reflect.ValueOf(&s{i: 10}))
+	dataContext.Set(NewDIObjectKey("m2", reflect.TypeOf(&s{})), reflect.ValueOf(&s{i: 20}))
+	return di, dataContext, inputs
+}
+
+func TestProcessorDI_Execute(t *testing.T) {
+	di, dataContext, inputs := newDIBench(t)
+	for i := 0; i < 2; i++ {
+		di.Reset()
+		di.InjectInput(dataContext, inputs)
+		p := di.Processor.(*diBench)
+		if p.A.i != 1 || !reflect.DeepEqual(p.B, []string{"b"}) || p.C != 3 || len(p.M) != 2 ||
+			p.M["m2"].i != 20 || p.REQ == nil || p.REQ.name != "ts" || p.calls != i {
+			t.Fatalf("ProcessorDI.InjectInput() processor = %+v", p)
+		}
+		if err := p.OnExecute(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		di.CollectOutput(dataContext)
+	}
+	if v, ok := dataContext.Get(NewDIObjectKey("o1", reflect.TypeOf(&s{}))); !ok || v.(reflect.Value).Interface().(*s).i != 3 {
+		t.Errorf("ProcessorDI.CollectOutput() o1 = %v", v)
+	}
+	if v, ok := dataContext.Get(NewDIObjectKey("o2", reflect.TypeOf([]int{}))); !ok ||
+		!reflect.DeepEqual(v.(reflect.Value).Interface(), []int{3}) {
+		t.Errorf("ProcessorDI.CollectOutput() o2 = %v, want reset between executions", v)
+	}
+	if v, ok := dataContext.Get(NewDIObjectKey("name", reflect.TypeOf(""))); !ok || v.(reflect.Value).String() != "ts" {
+		t.Errorf("ProcessorDI.CollectOutput() name = %v", v)
+	}
+}
+
+// BenchmarkProcessorDI allocations of injecting and collecting one vertex
+func BenchmarkProcessorDI(b *testing.B) {
+	di, dataContext, inputs := newDIBench(b)
+	p := di.Processor
+	b.Run("reset", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			di.Reset()
+		}
+	})
+	b.Run("inject", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			di.InjectInput(dataContext, inputs)
+		}
+	})
+	b.Run("collect", func(b *testing.B) {
+		di.InjectInput(dataContext, inputs)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			di.CollectOutput(dataContext)
+		}
+	})
+	b.Run("vertex", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			di.Reset()
+			di.InjectInput(dataContext, inputs)
+			_ = p.OnExecute(context.Background(), nil)
+			di.CollectOutput(dataContext)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"xxxx/innererror"
+)
+
+// fill colors of vertex status in result dot
+var vertexStatusColors = map[VertexStatus]string{
+	VertexNotRun:                "white",
+	VertexSkippedByDeps:         "lightgrey",
+	VertexSkippedByExpect:       "lightyellow",
+	VertexSkippedByExpectConfig: "lightyellow",
+	VertexSkippedByCond:         "lightyellow",
+	VertexSkippedByRequired:     "lightyellow",
+	VertexSkippedByCancel:       "lightgrey",
+	VertexOk:                    "palegreen",
+	VertexErr:                   "tomato",
+	VertexPanic:                 "tomato",
+	VertexTimeout:               "orange",
+	VertexCancelled:             "orange",
+}
+
+// dotResult execution result of one graph rendered on its dot
+type dotResult struct {
+	result *ExecuteResult
+	// critical vertexes and edges 'dep->vertex' on the longest path by duration
+	critical      map[string]bool
+	criticalEdges map[string]bool
+	// first and last vertexes of the critical path
+	criticalStart string
+	criticalStop  string
+}
+
+func newDotResult(g *Graph, result *ExecuteResult) *dotResult {
+	r := &dotResult{result: result, critical: make(map[string]bool), criticalEdges: make(map[string]bool)}
+	r.buildCriticalPath(g)
+	return r
+}
+
+func (r *dotResult) getStatus(id string) VertexStatus {
+	if vr, ok := r.result.Vertexes[id]; ok {
+		return vr.Status
+	}
+	return VertexNotRun
+}
+
+// isEdgeTaken dependency result matched the expected result, like checkConditionResult
+func isEdgeTaken(expect int, s VertexStatus) bool {
+	if s == VertexNotRun {
+		return false
+	}
+	// skipped or interrupted vertexes report condition error
+	conditionErr := s.IsSkipped() || s == VertexTimeout || s == VertexCancelled
+	switch expect {
+	case innererror.VResultOk:
+		return !conditionErr
+	case innererror.VResultErr:
+		return conditionErr
+	}
+	return true
+}
+
+// buildCriticalPath find the path of taken edges with the longest total vertex duration
+func (r *dotResult) buildCriticalPath(g *Graph) {
+	finish := make(map[string]time.Duration)
+	prev := make(map[string]string)
+	var visit func(v *Vertex) time.Duration
+	visit = func(v *Vertex) time.Duration {
+		if d, ok := finish[v.ID]; ok {
+			return d
+		}
+		finish[v.ID] = -1
+		s := r.getStatus(v.ID)
+		if s == VertexNotRun || s == VertexSkippedByDeps || s == VertexSkippedByCancel {
+			return -1
+		}
+		var start time.Duration
+		deps := make([]string, 0, len(v.depsResults))
+		for id := range v.depsResults {
+			deps = append(deps, id)
+		}
+		sort.Strings(deps)
+		for _, id := range deps {
+			if !isEdgeTaken(v.depsResults[id], r.getStatus(id)) {
+				continue
+			}
+			if d := visit(g.getVertexByID(id)); d > start || (d >= 0 && len(prev[v.ID]) == 0) {
+				start = d
+				prev[v.ID] = id
+			}
+		}
+		finish[v.ID] = start + r.result.Vertexes[v.ID].Duration
+		return finish[v.ID]
+	}
+	ids := make([]string, 0, len(g.vertexMap))
+	for id := range g.vertexMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var stop string
+	longest := time.Duration(-1)
+	for _, id := range ids {
+		if d := visit(g.vertexMap[id]); d > longest {
+			longest = d
+			stop = id
+		}
+	}
+	if longest < 0 {
+		return
+	}
+	r.criticalStop = stop
+	for id := stop; len(id) > 0; id = prev[id] {
+		r.critical[id] = true
+		r.criticalStart = id
+		if dep, ok := prev[id]; ok {
+			r.criticalEdges[dep+"->"+id] = true
+		}
+	}
+}
+
+// getVertexLabel status, duration and code of vertex appended to its label
+func (r *dotResult) getVertexLabel(v *Vertex) string {
+	vr, ok := r.result.Vertexes[v.ID]
+	if !ok {
+		return "\\n" + VertexNotRun.String()
+	}
+	label := "\\n" + vr.Status.String()
+	if d := vr.Duration.Round(10 * time.Microsecond); d > 0 {
+		label += " " + d.String()
+	}
+	if vr.Code != 0 && !vr.Status.IsSkipped() {
+		label += fmt.Sprintf("\\ncode:%d", vr.Code)
+	}
+	if vr.Attempts > 1 {
+		label += fmt.Sprintf(" attempts:%d", vr.Attempts)
+	}
+	return label
+}
+
+func (r *dotResult) getVertexStyle(v *Vertex) string {
+	var s strings.Builder
+	if len(v.Cond) > 0 || len(v.Switch) > 0 {
+		s.WriteString(" shape=diamond")
+	} else if v.Foreach != nil {
+		s.WriteString(" shape=folder peripheries=2")
+	} else if len(v.Graph) > 0 {
+		s.WriteString(" shape=box3d")
+	}
+	s.WriteString(" fillcolor=" + vertexStatusColors[r.getStatus(v.ID)])
+	if r.critical[v.ID] {
+		s.WriteString(" color=red penwidth=3 style=\"filled,bold\"")
+	} else if r.getStatus(v.ID) == VertexNotRun {
+		s.WriteString(" color=black style=\"filled,dashed\"")
+	} else {
+		s.WriteString(" color=black style=filled")
+	}
+	return s.String()
+}
+
+func (r *dotResult) getTakenStyle(critical bool, taken bool, label string) string {
+	if critical {
+		return " [style=bold color=red penwidth=3 label=\"" + label + "\"]"
+	}
+	if taken {
+		return " [style=bold color=darkgreen penwidth=2 label=\"" + label + "\"]"
+	}
+	return " [style=dotted color=grey label=\"" + label + "\"]"
+}
+
+// getEdgeStyle highlight edge taken by the execution, emphasise it on critical path
+func (r *dotResult) getEdgeStyle(dep *Vertex, v *Vertex, expect int) string {
+	label := "all"
+	switch expect {
+	case innererror.VResultOk:
+		label = "ok"
+	case innererror.VResultErr:
+		label = "err"
+	}
+	taken := isEdgeTaken(expect, r.getStatus(dep.ID))
+	return r.getTakenStyle(r.criticalEdges[dep.ID+"->"+v.ID], taken, label)
+}
+
+// getBranchStyle highlight branch of switch taken by the execution
+func (r *dotResult) getBranchStyle(v *Vertex, successor *Vertex, label string) string {
+	s := r.getStatus(successor.ID)
+	taken := s != VertexNotRun && s != VertexSkippedByDeps
+	return r.getTakenStyle(r.criticalEdges[v.ID+"->"+successor.ID], taken, label)
+}
+
+// getTerminalStyle emphasise edges from START and to STOP of the critical path, empty for static dot
+func (r *dotResult) getTerminalStyle(v *Vertex, stop bool) string {
+	if r == nil {
+		return ""
+	}
+	if (stop && r.criticalStop == v.ID) || (!stop && r.criticalStart == v.ID) {
+		return " [style=bold color=red penwidth=3]"
+	}
+	return ""
+}
+
+// getResultGraph graph version run by result
+func (c *Cluster) getResultGraph(result *ExecuteResult) *Graph {
+	if gv, ok := c.versions[result.Graph]; ok {
+		if g := gv.getVersion(result.Version); g != nil {
+			return g
+		}
+	}
+	return c.getGraph(result.Graph)
+}
+
+// DumpResultDot dump dot of the graphs run by result, vertexes are coloured by status and labeled with
+// durations, edges taken by the execution are highlighted and the critical path is emphasised.
+// Subgraphs of the same cluster are rendered with the result of their first call.
+func (c *Cluster) DumpResultDot(buffer *strings.Builder, result *ExecuteResult) {
+	buffer.WriteString("digraph G {\n")
+	buffer.WriteString("    rankdir=LR;\n")
+	c.dumpResultDot(buffer, result, make(map[*Graph]bool))
+	buffer.WriteString("}\n")
+}
+
+func (c *Cluster) dumpResultDot(buffer *strings.Builder, result *ExecuteResult, dumped map[*Graph]bool) {
+	g := c.getResultGraph(result)
+	if g == nil || dumped[g] {
+		return
+	}
+	dumped[g] = true
+	g.DumpResultDot(buffer, result)
+	ids := make([]string, 0, len(result.Vertexes))
+	for id := range result.Vertexes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if sub := result.Vertexes[id].SubGraph; sub != nil && sub.Cluster == c.Name {
+			c.dumpResultDot(buffer, sub, dumped)
+		}
+	}
+}
+
+// DumpResultDot dump graph dot annotated by execution result
+func (g *Graph) DumpResultDot(buffer *strings.Builder, result *ExecuteResult) {
+	g.dumpDot(buffer, newDotResult(g, result))
+}
@@ -0,0 +1,249 @@
+package graph
+
+import (
+	"log"
+	"sync"
+
+	"xxxx/util/safe"
+)
+
+// Task task of a ready vertex. Waiting tasks wait for tasks of other vertexes,
+// e.g. subgraph calls and foreach vertexes waiting for their elements
+type Task struct {
+	Run     func()
+	Waiting bool
+}
+
+// Scheduler run the tasks of ready vertexes for one graph context.
+// Schedule may run tasks on the current goroutine, but must not block waiting
+// for tasks running on other goroutines, the graph context waits for them.
+type Scheduler interface {
+	Schedule(c *Context, tasks []Task)
+}
+
+// clusterLimiter scheduler limiting concurrency of clusters
+type clusterLimiter interface {
+	limited(cluster string) bool
+}
+
+// slotHolder scheduler keeping a slot of a limited cluster for work outliving its task,
+// e.g. a processor abandoned by vertex timeout
+type slotHolder interface {
+	hold(cluster string) (release func())
+}
+
+// holdClusterSlot take a slot of the cluster of c until release, noop if the scheduler does not limit it
+func holdClusterSlot(c *Context) func() {
+	if h, ok := c.getScheduler().(slotHolder); ok {
+		return h.hold(c.Graph.cluster.Name)
+	}
+	return func() {}
+}
+
+// UnboundedScheduler run every task on a new goroutine
+type UnboundedScheduler struct{}
+
+// Schedule run every task on a new goroutine
+func (s *UnboundedScheduler) Schedule(_ *Context, tasks []Task) {
+	for _, task := range tasks {
+		safe.Go(task.Run)
+	}
+}
+
+// InlineScheduler run a single ready task on the current goroutine,
+// so a chain of single successors never switches goroutine.
+// Tasks of clusters limited by next are always delegated, so the limits hold.
+type InlineScheduler struct {
+	Next Scheduler
+}
+
+// NewInlineScheduler create inline scheduler, next schedules multi ready tasks
+func NewInlineScheduler(next Scheduler) *InlineScheduler {
+	if next == nil {
+		next = &UnboundedScheduler{}
+	}
+	return &InlineScheduler{Next: next}
+}
+
+// Schedule run single task inline, otherwise delegate to next scheduler
+func (s *InlineScheduler) Schedule(c *Context, tasks []Task) {
+	if l, ok := s.Next.(clusterLimiter); len(tasks) == 1 && (!ok || !l.limited(c.Graph.cluster.Name)) {
+		tasks[0].Run()
+		return
+	}
+	s.Next.Schedule(c, tasks)
+}
+
+func (s *InlineScheduler) hold(cluster string) func() {
+	if h, ok := s.Next.(slotHolder); ok {
+		return h.hold(cluster)
+	}
+	return func() {}
+}
+
+// clusterSlots running tasks and tasks waiting for a slot of a limited cluster
+type clusterSlots struct {
+	limit   int
+	running int
+	peak    int
+	pending []func()
+}
+
+// WorkerPoolScheduler run tasks on a fixed number of workers, a task runs on the
+// submitting goroutine when no worker is idle.
+// Tasks of a limited cluster take a slot, inline runs included; when all slots are taken
+// the task is queued and run by the goroutine releasing the next slot.
+// Waiting tasks take no slot and run on a new goroutine when no worker is idle,
+// so vertexes waiting for a subgraph never deadlock the pool.
+type WorkerPoolScheduler struct {
+	tasks    chan func()
+	clusters map[string]*clusterSlots
+	lock     sync.Mutex
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewWorkerPoolScheduler create worker pool with workers goroutines and per cluster concurrency limits
+func NewWorkerPoolScheduler(workers int, clusterLimits map[string]int) *WorkerPoolScheduler {
+	s := &WorkerPoolScheduler{
+		tasks:    make(chan func()),
+		clusters: make(map[string]*clusterSlots, len(clusterLimits)),
+		stop:     make(chan struct{}),
+	}
+	for cluster, limit := range clusterLimits {
+		if limit < 1 {
+			limit = 1
+		}
+		s.clusters[cluster] = &clusterSlots{limit: limit}
+	}
+	for i := 0; i < workers; i++ {
+		safe.Go(s.work)
+	}
+	return s
+}
+
+func (s *WorkerPoolScheduler) work() {
+	for {
+		select {
+		case task := <-s.tasks:
+			s.run(task)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *WorkerPoolScheduler) run(task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("worker pool task panic:%v", r)
+		}
+	}()
+	task()
+}
+
+// Stop stop all workers
+func (s *WorkerPoolScheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *WorkerPoolScheduler) limited(cluster string) bool {
+	_, ok := s.clusters[cluster]
+	return ok
+}
+
+// Peak max concurrent tasks of a limited cluster
+func (s *WorkerPoolScheduler) Peak(cluster string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if slots, ok := s.clusters[cluster]; ok {
+		return slots.peak
+	}
+	return 0
+}
+
+// hold take a slot beyond the limit, the slot of the returning task is handed over,
+// release runs the queued tasks with it
+func (s *WorkerPoolScheduler) hold(cluster string) func() {
+	slots, ok := s.clusters[cluster]
+	if !ok {
+		return func() {}
+	}
+	s.lock.Lock()
+	slots.running++
+	s.lock.Unlock()
+	return func() {
+		for task := s.next(slots); task != nil; task = s.next(slots) {
+			s.run(task)
+		}
+	}
+}
+
+// acquire take a slot for task, or queue it when all slots are taken
+func (s *WorkerPoolScheduler) acquire(slots *clusterSlots, task func()) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if slots.running >= slots.limit {
+		slots.pending = append(slots.pending, task)
+		return false
+	}
+	slots.running++
+	if slots.running > slots.peak {
+		slots.peak = slots.running
+	}
+	return true
+}
+
+// next hand the slot to the next queued task, or release it
+func (s *WorkerPoolScheduler) next(slots *clusterSlots) func() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(slots.pending) == 0 {
+		slots.running--
+		return nil
+	}
+	task := slots.pending[0]
+	slots.pending[0] = nil
+	slots.pending = slots.pending[1:]
+	return task
+}
+
+// holdSlot run task and then the queued tasks with the slot it holds
+func (s *WorkerPoolScheduler) holdSlot(slots *clusterSlots, task func()) func() {
+	return func() {
+		for ; task != nil; task = s.next(slots) {
+			s.run(task)
+		}
+	}
+}
+
+// Schedule hand tasks to idle workers, run the others on current goroutine,
+// tasks of limited clusters are queued when all slots are taken
+func (s *WorkerPoolScheduler) Schedule(c *Context, tasks []Task) {
+	slots := s.clusters[c.Graph.cluster.Name]
+	var inline []func()
+	for _, task := range tasks {
+		run := task.Run
+		if slots != nil && !task.Waiting {
+			if !s.acquire(slots, run) {
+				continue
+			}
+			run = s.holdSlot(slots, run)
+		}
+		select {
+		case s.tasks <- run:
+		default:
+			if task.Waiting {
+				// the current goroutine may hold a slot
+				safe.Go(run)
+			} else {
+				inline = append(inline, run)
+			}
+		}
+	}
+	for _, run := range inline {
+		run()
+	}
+}
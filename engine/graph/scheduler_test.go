@@ -0,0 +1,269 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/dagengine/engine/processor"
+)
+
+// statScheduler record max concurrent tasks and goroutines
+type statScheduler struct {
+	next          Scheduler
+	running       int32
+	maxRunning    int32
+	maxGoroutines int32
+}
+
+func storeMax(addr *int32, v int32) {
+	for {
+		old := atomic.LoadInt32(addr)
+		if v <= old || atomic.CompareAndSwapInt32(addr, old, v) {
+			return
+		}
+	}
+}
+
+func (s *statScheduler) Schedule(c *Context, tasks []Task) {
+	wrapped := make([]Task, len(tasks))
+	for i := range tasks {
+		task := tasks[i]
+		wrapped[i] = Task{Waiting: task.Waiting, Run: func() {
+			storeMax(&s.maxRunning, atomic.AddInt32(&s.running, 1))
+			storeMax(&s.maxGoroutines, int32(runtime.NumGoroutine()))
+			defer atomic.AddInt32(&s.running, -1)
+			task.Run()
+		}}
+	}
+	s.next.Schedule(c, wrapped)
+}
+
+// schedulerTestClusters clusters executed concurrently, vertexes running at the same time do not write
+// the same fields of REQ so the test is clean under -race
+var schedulerTestClusters = []struct {
+	clusterName string
+	graphName   string
+	params      *param.Params
+}{
+	{clusterName: "extern_input_test.toml", graphName: "enter"},
+	{clusterName: "dep_test.toml", graphName: "enter", params: &param.Params{"EXP": 101}},
+	{clusterName: "expect_expect_config_test.toml", graphName: "enter", params: &param.Params{"EXP": 101}},
+	{clusterName: "select_args_test.toml", graphName: "enter", params: &param.Params{"EXP": 101}},
+	{clusterName: "dep_ret_code_test.toml", graphName: "enter", params: &param.Params{"EXP": 100}},
+	{clusterName: "subgraph_test.toml", graphName: "enter", params: &param.Params{"EXP": 10000}},
+	{clusterName: "foreach_test.toml", graphName: "slice"},
+	{clusterName: "foreach_test.toml", graphName: "subgraph"},
+}
+
+func newTestSchedulers() map[string]func() Scheduler {
+	return map[string]func() Scheduler{
+		"unbounded": func() Scheduler { return &UnboundedScheduler{} },
+		"inline":    func() Scheduler { return NewInlineScheduler(nil) },
+		"pool":      func() Scheduler { return NewWorkerPoolScheduler(2, nil) },
+		"pool_limit": func() Scheduler {
+			return NewWorkerPoolScheduler(4,
+				map[string]int{"subgraph_test.toml": 1, "foreach_test.toml": 1})
+		},
+		"inline_pool_limit": func() Scheduler {
+			return NewInlineScheduler(NewWorkerPoolScheduler(4,
+				map[string]int{"subgraph_test.toml": 1, "foreach_test.toml": 1}))
+		},
+	}
+}
+
+func registerSchedulerTestProcessors() {
+	processor.Register("phase0", func() processor.Processor { return &phase0{} })
+	processor.Register("phase1", func() processor.Processor { return &phase1{} })
+	processor.Register("phase2", func() processor.Processor { return &phase2{} })
+	processor.Register("phase3", func() processor.Processor { return &phase3{} })
+	processor.Register("phase6", func() processor.Processor { return &phase6{} })
+	processor.Register("recall_channels", func() processor.Processor { return &recallChannels{} })
+	processor.Register("recall", func() processor.Processor { return &recall{} })
+	processor.Register("merge_recall", func() processor.Processor { return &mergeRecall{} })
+	processor.Register("recall_sources", func() processor.Processor { return &recallSources{} })
+	processor.Register("recall_source", func() processor.Processor { return &recallBySource{} })
+}
+
+func newTestDataContext() *DataContext {
+	ts := &testReq{name: "ts", id: []int{1, 2, 3}, strs: []string{"s0", "s1", "s2"}}
+	dataContext := NewDataContext()
+	var midi interface{} = ts
+	dataContext.Set(NewDIObjectKey("REQ", reflect.TypeOf(ts)), reflect.ValueOf(midi))
+	return dataContext
+}
+
+func TestManager_Scheduler(t *testing.T) {
+	registerSchedulerTestProcessors()
+	for name, newScheduler := range newTestSchedulers() {
+		m := New()
+		m.SetScheduler(newScheduler())
+		for _, tt := range schedulerTestClusters {
+			t.Run(name+"/"+tt.clusterName, func(t *testing.T) {
+				if err := m.loadFile("../../cmd/"+tt.clusterName, &TomlCodec{}); err != nil {
+					t.Fatalf("Manager.loadFile() error = %v", err)
+				}
+				var wg sync.WaitGroup
+				for i := 0; i < 8; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						result, err := m.execute(context.Background(), tt.clusterName, tt.graphName,
+							newTestDataContext(), tt.params, nil)
+						if err != nil {
+							t.Errorf("Manager.execute() error = %v", err)
+							return
+						}
+						for id, vr := range result.Vertexes {
+							if vr.Status == VertexNotRun {
+								t.Errorf("Manager.execute() vertex:%v not run", id)
+							}
+						}
+					}()
+				}
+				wg.Wait()
+			})
+		}
+		s := m.getScheduler()
+		if inline, ok := s.(*InlineScheduler); ok {
+			s = inline.Next
+		}
+		if pool, ok := s.(*WorkerPoolScheduler); ok {
+			pool.Stop()
+		}
+	}
+}
+
+func BenchmarkScheduler(b *testing.B) {
+	registerSchedulerTestProcessors()
+	for name, newScheduler := range newTestSchedulers() {
+		for _, tt := range schedulerTestClusters {
+			b.Run(name+"/"+tt.clusterName, func(b *testing.B) {
+				next := newScheduler()
+				s := &statScheduler{next: next}
+				m := New()
+				m.SetScheduler(s)
+				if err := m.loadFile("../../cmd/"+tt.clusterName, &TomlCodec{}); err != nil {
+					b.Fatalf("Manager.loadFile() error = %v", err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						_, _ = m.execute(context.Background(), tt.clusterName, tt.graphName,
+							newTestDataContext(), tt.params, nil)
+					}
+				})
+				b.StopTimer()
+				b.ReportMetric(float64(atomic.LoadInt32(&s.maxRunning)), "max_tasks")
+				b.ReportMetric(float64(atomic.LoadInt32(&s.maxGoroutines)), "max_goroutines")
+				if inline, ok := next.(*InlineScheduler); ok {
+					next = inline.Next
+				}
+				if pool, ok := next.(*WorkerPoolScheduler); ok {
+					pool.Stop()
+				}
+			})
+		}
+	}
+}
+
+// limitProbe record max concurrent executions of its processor
+type limitProbe struct {
+	Out int `graph:"output"`
+}
+
+var limitProbeRunning, limitProbePeak int32
+
+func (p *limitProbe) OnInit() {
+}
+
+func (p *limitProbe) OnExecute(_ context.Context, params *param.Params) error {
+	storeMax(&limitProbePeak, atomic.AddInt32(&limitProbeRunning, 1))
+	defer atomic.AddInt32(&limitProbeRunning, -1)
+	time.Sleep(time.Millisecond)
+	return nil
+}
+
+func TestWorkerPoolScheduler_ClusterLimit(t *testing.T) {
+	content := `
+name = "limit_test.toml"
+[[graph]]
+name = "enter"
+`
+	for i := 0; i < 6; i++ {
+		content += fmt.Sprintf("[[graph.vertex]]\nid = \"p%d\"\nstart = true\nprocessor = \"limit_probe\"\n"+
+			"output = [{field = \"Out\", id = \"out%d\"}]\n", i, i)
+	}
+	// the subgraph vertex waits for vertexes of the same cluster
+	content += `[[graph.vertex]]
+id = "sub"
+start = true
+cluster = "limit_test.toml"
+graph = "sub"
+[[graph]]
+name = "sub"
+[[graph.vertex]]
+id = "s0"
+start = true
+processor = "limit_probe"
+output = [{field = "Out", id = "s0"}]
+[[graph.vertex]]
+id = "s1"
+processor = "limit_probe"
+deps = ["s0"]
+output = [{field = "Out", id = "s1"}]
+`
+	processor.Register("limit_probe", func() processor.Processor { return &limitProbe{} })
+	for name, s := range map[string]Scheduler{
+		"pool":   NewWorkerPoolScheduler(3, map[string]int{"limit_test.toml": 2}),
+		"inline": NewInlineScheduler(NewWorkerPoolScheduler(3, map[string]int{"limit_test.toml": 2})),
+		"limit1": NewWorkerPoolScheduler(8, map[string]int{"limit_test.toml": 1}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := New()
+			m.SetScheduler(s)
+			if err := m.load("limit_test.toml", []byte(content), &TomlCodec{}); err != nil {
+				t.Fatalf("Manager.load() error = %v", err)
+			}
+			atomic.StoreInt32(&limitProbePeak, 0)
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					result, err := m.execute(context.Background(), "limit_test.toml", "enter", NewDataContext(),
+						&param.Params{}, nil)
+					if err != nil {
+						t.Errorf("Manager.execute() error = %v", err)
+						return
+					}
+					for id, vr := range result.Vertexes {
+						if vr.Status != VertexOk {
+							t.Errorf("Manager.execute() vertex:%v status:%v", id, vr.Status)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			pool, ok := s.(*WorkerPoolScheduler)
+			if !ok {
+				pool = s.(*InlineScheduler).Next.(*WorkerPoolScheduler)
+			}
+			pool.Stop()
+			limit := 2
+			if name == "limit1" {
+				limit = 1
+			}
+			if peak := atomic.LoadInt32(&limitProbePeak); peak > int32(limit) || pool.Peak("limit_test.toml") > limit {
+				t.Errorf("peak concurrency = %d, scheduler peak = %d, want <= %d", peak, pool.Peak("limit_test.toml"), limit)
+			}
+		})
+	}
+}
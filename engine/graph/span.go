@@ -0,0 +1,174 @@
+package graph
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xxxx/innererror"
+)
+
+// span names
+const (
+	SpanExecute  = "dag.execute"
+	SpanGraph    = "dag.graph"
+	SpanVertex   = "dag.vertex"
+	SpanSubGraph = "dag.subgraph"
+)
+
+// SpanAttribute key value attribute of span
+type SpanAttribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer open spans of executions, adapters of tracing libraries implement it.
+// The returned ctx carries the span, processors get it in OnExecute so their own spans nest in the vertex span.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...SpanAttribute) (context.Context, Span)
+}
+
+// Span span opened by tracer
+type Span interface {
+	SetAttributes(attrs ...SpanAttribute)
+	RecordError(err error)
+	End()
+}
+
+// SetTracer set tracer of executions, nil disables tracing
+func (m *Manager) SetTracer(t Tracer) {
+	m.lock.Lock()
+	m.tracer = t
+	m.lock.Unlock()
+}
+
+func (m *Manager) getTracer() Tracer {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.tracer
+}
+
+func (c *Context) getTracer() Tracer {
+	if m := c.Graph.cluster.GraphManager; m != nil {
+		return m.getTracer()
+	}
+	return nil
+}
+
+// endSpan record err with its code and end span
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.SetAttributes(SpanAttribute{Key: "dag.code", Value: innererror.Code(err)})
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// startGraphSpan open span of graph execution
+func (c *Context) startGraphSpan(ctx context.Context, t Tracer) (context.Context, Span) {
+	return t.Start(ctx, SpanGraph,
+		SpanAttribute{Key: "dag.cluster", Value: c.Graph.cluster.Name},
+		SpanAttribute{Key: "dag.graph", Value: c.Graph.Name},
+		SpanAttribute{Key: "dag.version", Value: c.Graph.ExpectVersion},
+		SpanAttribute{Key: "dag.depth", Value: c.ClusterContext.depth})
+}
+
+// startVertexSpan open span of vertex execution
+func (v *VertexContext) startVertexSpan(ctx context.Context, t Tracer) (context.Context, Span) {
+	return t.Start(ctx, SpanVertex,
+		SpanAttribute{Key: "dag.cluster", Value: v.GraphContext.Graph.cluster.Name},
+		SpanAttribute{Key: "dag.graph", Value: v.GraphContext.Graph.Name},
+		SpanAttribute{Key: "dag.vertex", Value: v.Vertex.ID},
+		SpanAttribute{Key: "dag.processor", Value: v.Vertex.Processor})
+}
+
+// endVertexSpan record status, skip reason and code of vertex, end span
+func (v *VertexContext) endVertexSpan(span Span) {
+	vr := v.getResult()
+	span.SetAttributes(SpanAttribute{Key: "dag.status", Value: vr.Status.String()})
+	if vr.Status.IsSkipped() {
+		if vr.Err != nil {
+			span.SetAttributes(SpanAttribute{Key: "dag.skip_reason", Value: vr.Err.Error()})
+		}
+		span.End()
+		return
+	}
+	endSpan(span, vr.Err)
+}
+
+// MemoryTracer tracer keeping ended spans in memory, for tests
+type MemoryTracer struct {
+	seq   uint64
+	spans []*MemorySpan
+	lock  sync.Mutex
+}
+
+// NewMemoryTracer create memory tracer
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+type memorySpanKey struct{}
+
+// Start open span, child of the memory span in ctx
+func (t *MemoryTracer) Start(ctx context.Context, name string, attrs ...SpanAttribute) (context.Context, Span) {
+	s := &MemorySpan{
+		Name:       name,
+		ID:         atomic.AddUint64(&t.seq, 1),
+		Attributes: make(map[string]interface{}, len(attrs)),
+		StartTime:  time.Now(),
+		tracer:     t,
+	}
+	if parent, ok := ctx.Value(memorySpanKey{}).(*MemorySpan); ok {
+		s.ParentID = parent.ID
+	}
+	s.SetAttributes(attrs...)
+	return context.WithValue(ctx, memorySpanKey{}, s), s
+}
+
+// Spans ended spans in end order
+func (t *MemoryTracer) Spans() []*MemorySpan {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]*MemorySpan(nil), t.spans...)
+}
+
+// Reset drop ended spans
+func (t *MemoryTracer) Reset() {
+	t.lock.Lock()
+	t.spans = nil
+	t.lock.Unlock()
+}
+
+// MemorySpan span of memory tracer, ParentID is 0 for root span
+type MemorySpan struct {
+	Name       string
+	ID         uint64
+	ParentID   uint64
+	Attributes map[string]interface{}
+	Err        error
+	StartTime  time.Time
+	EndTime    time.Time
+	tracer     *MemoryTracer
+}
+
+// SetAttributes set attributes, override the same keys
+func (s *MemorySpan) SetAttributes(attrs ...SpanAttribute) {
+	for _, attr := range attrs {
+		s.Attributes[attr.Key] = attr.Value
+	}
+}
+
+// RecordError record err of span
+func (s *MemorySpan) RecordError(err error) {
+	s.Err = err
+}
+
+// End end span and keep it in tracer
+func (s *MemorySpan) End() {
+	s.EndTime = time.Now()
+	s.tracer.lock.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.lock.Unlock()
+}
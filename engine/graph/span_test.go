@@ -0,0 +1,129 @@
+package graph
+
+import (
+	"context"
+	"testing"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/dagengine/engine/processor"
+	"xxxx/innererror"
+)
+
+var spanTestTracer = NewMemoryTracer()
+
+type spanChild struct {
+}
+
+func (p *spanChild) OnInit() {
+}
+
+func (p *spanChild) OnExecute(ctx context.Context, params *param.Params) error {
+	// own span of processor nests in the vertex span
+	_, span := spanTestTracer.Start(ctx, "processor")
+	span.End()
+	if code := params.GetInt64("code"); code != 0 {
+		return innererror.Error(int32(code))
+	}
+	return nil
+}
+
+func TestManager_ExecuteSpans(t *testing.T) {
+	content := `
+name = "span_test.toml"
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+id = "a"
+start = true
+processor = "span_child"
+[[graph.vertex]]
+id = "b"
+processor = "span_child"
+deps_on_err = ["a"]
+[[graph.vertex]]
+id = "c"
+cluster = "span_test.toml"
+graph = "sub"
+deps = ["a"]
+[[graph]]
+name = "sub"
+[[graph.vertex]]
+id = "x"
+start = true
+processor = "span_child"
+args = {code = 3}
+`
+	processor.Register("span_child", func() processor.Processor { return &spanChild{} })
+	m := New()
+	if err := m.load("span_test.toml", []byte(content), &TomlCodec{}); err != nil {
+		t.Fatalf("Manager.load() error = %v", err)
+	}
+	spanTestTracer.Reset()
+	if _, err := m.execute(context.Background(), "span_test.toml", "enter", NewDataContext(),
+		&param.Params{}, nil); err != nil {
+		t.Fatalf("Manager.execute() error = %v", err)
+	}
+	if got := spanTestTracer.Spans(); len(got) != 2 {
+		t.Fatalf("MemoryTracer.Spans() without tracer = %d, want processor spans only", len(got))
+	}
+	spanTestTracer.Reset()
+	m.SetTracer(spanTestTracer)
+	if _, err := m.execute(context.Background(), "span_test.toml", "enter", NewDataContext(),
+		&param.Params{}, nil); err != nil {
+		t.Fatalf("Manager.execute() error = %v", err)
+	}
+	spans := spanTestTracer.Spans()
+	byID := make(map[uint64]*MemorySpan)
+	byName := make(map[string]*MemorySpan)
+	for _, s := range spans {
+		byID[s.ID] = s
+	}
+	for _, s := range spans {
+		name := s.Name
+		if vertex, ok := s.Attributes["dag.vertex"]; ok {
+			name += "/" + vertex.(string)
+		} else if g, ok := s.Attributes["dag.graph"]; ok {
+			name += "/" + g.(string)
+		}
+		if s.Name == "processor" {
+			name += "/" + byID[s.ParentID].Attributes["dag.vertex"].(string)
+		}
+		byName[name] = s
+	}
+	parents := map[string]string{
+		"dag.execute/enter": "",
+		"dag.graph/enter":   "dag.execute/enter",
+		"dag.vertex/a":      "dag.graph/enter",
+		"processor/a":       "dag.vertex/a",
+		"dag.vertex/b":      "dag.graph/enter",
+		"dag.vertex/c":      "dag.graph/enter",
+		"dag.subgraph/c":    "dag.vertex/c",
+		"dag.graph/sub":     "dag.subgraph/c",
+		"dag.vertex/x":      "dag.graph/sub",
+		"processor/x":       "dag.vertex/x",
+	}
+	if len(spans) != len(parents) {
+		t.Errorf("MemoryTracer.Spans() = %d, want %d", len(spans), len(parents))
+	}
+	for name, parent := range parents {
+		s, ok := byName[name]
+		if !ok {
+			t.Errorf("span %s not found", name)
+			continue
+		}
+		if got := byID[s.ParentID]; (parent == "" && got != nil) || (parent != "" && got != byName[parent]) {
+			t.Errorf("span %s parent = %+v, want %s", name, got, parent)
+		}
+	}
+	a, b, x := byName["dag.vertex/a"], byName["dag.vertex/b"], byName["dag.vertex/x"]
+	if a.Attributes["dag.processor"] != "span_child" || a.Attributes["dag.cluster"] != "span_test.toml" ||
+		a.Attributes["dag.status"] != "ok" || a.Err != nil {
+		t.Errorf("span a = %+v, want ok processor attributes", a)
+	}
+	if b.Attributes["dag.status"] != "skipped_by_deps" || b.Attributes["dag.skip_reason"] == nil {
+		t.Errorf("span b = %+v, want skip reason", b)
+	}
+	if x.Attributes["dag.code"] != int32(3) || x.Err == nil {
+		t.Errorf("span x = %+v, want code 3", x)
+	}
+}
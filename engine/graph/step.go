@@ -0,0 +1,117 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"xxxx/dagengine/engine/param"
+)
+
+// order of ready vertexes in step mode
+const (
+	StepOrderID       = "id"
+	StepOrderDeclared = "declared"
+)
+
+// StepHooks hooks of step mode, called on the executing goroutine between two vertexes.
+// A blocking hook pauses the execution, a hook returning error aborts it.
+type StepHooks struct {
+	// Order order of ready vertexes, StepOrderID(default) or StepOrderDeclared
+	Order string
+	// BeforeVertex called before vertex executes, Step.Result is nil
+	BeforeVertex func(ctx context.Context, step *Step) error
+	// AfterVertex called after vertex executes with its result
+	AfterVertex func(ctx context.Context, step *Step) error
+}
+
+// Step vertex executed in step mode
+type Step struct {
+	Cluster string
+	Graph   string
+	Vertex  string
+	// Depth nesting depth of subgraph calls, 0 for the entry graph
+	Depth       int
+	DataContext *DataContext
+	// Params execute params of the request
+	Params *param.Params
+	// Args args of the vertex
+	Args   *param.Params
+	Result *VertexResult
+}
+
+// WithStep execute ready vertexes one at a time in deterministic order on the calling goroutine,
+// subgraphs and foreach elements included, hooks are called around every vertex
+func WithStep(hooks *StepHooks) ExecuteOption {
+	return func(o *executeOptions) {
+		o.step = &stepper{hooks: hooks}
+	}
+}
+
+// stepper step mode state of one execution, shared with its subgraphs
+type stepper struct {
+	hooks *StepHooks
+	// err abort error returned by hook
+	err error
+}
+
+// sort ready vertexes by configured order
+func (s *stepper) sort(vertexes []*VertexContext) {
+	sort.Slice(vertexes, func(i, j int) bool {
+		if s.hooks.Order == StepOrderDeclared {
+			return vertexes[i].Vertex.index < vertexes[j].Vertex.index
+		}
+		return vertexes[i].Vertex.ID < vertexes[j].Vertex.ID
+	})
+}
+
+func (s *stepper) newStep(vc *VertexContext) *Step {
+	c := vc.GraphContext
+	return &Step{
+		Cluster:     c.Graph.cluster.Name,
+		Graph:       c.Graph.Name,
+		Vertex:      vc.Vertex.ID,
+		Depth:       c.ClusterContext.depth,
+		DataContext: c.ExternDataContext,
+		Params:      c.ClusterContext.ExecuteParams,
+		Args:        vc.Params,
+	}
+}
+
+// executeSteps execute ready vertexes and all successors one by one, stop when a hook aborts
+func (c *Context) executeSteps(ctx context.Context, s *stepper, ready []*VertexContext) error {
+	for len(ready) > 0 && s.err == nil && ctx.Err() == nil {
+		s.sort(ready)
+		vc := ready[0]
+		ready = ready[1:]
+		step := s.newStep(vc)
+		if s.hooks.BeforeVertex != nil {
+			if err := s.hooks.BeforeVertex(ctx, step); err != nil {
+				s.err = fmt.Errorf("step aborted before vertex:%s/%s err:%w", c.Graph.Name, vc.Vertex.ID, err)
+				break
+			}
+		}
+		if err := vc.Execute(ctx); err != nil {
+			log.Printf("vertex execute err:%v", err)
+		}
+		if s.hooks.AfterVertex != nil {
+			step.Result = vc.getResult()
+			if err := s.hooks.AfterVertex(ctx, step); err != nil {
+				s.err = fmt.Errorf("step aborted after vertex:%s/%s err:%w", c.Graph.Name, vc.Vertex.ID, err)
+			}
+		}
+		ready = append(ready, c.readySuccessors(vc)...)
+	}
+	if s.err == nil {
+		return nil
+	}
+	for _, vc := range c.VertexContextTable {
+		if vc.result.status == VertexNotRun {
+			vc.result.status = VertexSkippedByCancel
+			vc.result.conditionResult = s.err
+			vc.emitVertexEvent()
+		}
+	}
+	return s.err
+}
@@ -0,0 +1,84 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/dagengine/engine/processor"
+)
+
+func TestManager_ExecuteStep(t *testing.T) {
+	errAbort := errors.New("abort")
+	tests := []struct {
+		name       string
+		order      string
+		abortAt    string
+		wantSteps  []string
+		wantErr    bool
+		wantStatus map[string]VertexStatus
+	}{
+		{name: "id", order: StepOrderID,
+			wantSteps: []string{"enter/a", "enter/b", "enter/c", "enter/d", "enter/e", "sub/x", "sub/y"}},
+		{name: "declared", order: StepOrderDeclared,
+			wantSteps: []string{"enter/c", "enter/a", "enter/b", "enter/d", "enter/e", "sub/y", "sub/x"}},
+		{name: "abort", order: StepOrderID, abortAt: "enter/d", wantErr: true,
+			wantSteps: []string{"enter/a", "enter/b", "enter/c", "enter/d"},
+			wantStatus: map[string]VertexStatus{"a": VertexOk, "c": VertexOk,
+				"d": VertexSkippedByCancel, "e": VertexSkippedByCancel}},
+		{name: "abort_in_subgraph", order: StepOrderID, abortAt: "sub/y", wantErr: true,
+			wantSteps:  []string{"enter/a", "enter/b", "enter/c", "enter/d", "enter/e", "sub/x", "sub/y"},
+			wantStatus: map[string]VertexStatus{"d": VertexOk, "e": VertexErr}},
+	}
+	processor.Register("phase7", func() processor.Processor { return &phase7{} })
+	if err := LoadFile("../../cmd/step_test.toml"); err != nil {
+		t.Fatalf("Manager.LoadFile() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the same order every run
+			for i := 0; i < 10; i++ {
+				var steps, done []string
+				hooks := &StepHooks{
+					Order: tt.order,
+					BeforeVertex: func(_ context.Context, step *Step) error {
+						name := step.Graph + "/" + step.Vertex
+						steps = append(steps, name)
+						if step.Result != nil || step.DataContext == nil {
+							t.Errorf("BeforeVertex() step = %+v, want data context and no result", step)
+						}
+						if name == tt.abortAt {
+							return errAbort
+						}
+						return nil
+					},
+					AfterVertex: func(_ context.Context, step *Step) error {
+						done = append(done, step.Graph+"/"+step.Vertex)
+						if step.Result == nil || step.Result.ID != step.Vertex {
+							t.Errorf("AfterVertex() step = %+v, want result of vertex", step)
+						}
+						return nil
+					},
+				}
+				result, err := ExecuteWithResult(context.Background(), "step_test.toml", "enter",
+					NewDataContext(), &param.Params{}, WithStep(hooks))
+				if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, errAbort)) {
+					t.Fatalf("ExecuteWithResult() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(steps, tt.wantSteps) {
+					t.Fatalf("ExecuteWithResult() steps = %v, want %v", steps, tt.wantSteps)
+				}
+				if len(done) != len(steps) && !tt.wantErr {
+					t.Errorf("ExecuteWithResult() done = %v, want %v", done, steps)
+				}
+				for id, want := range tt.wantStatus {
+					if got := result.Vertexes[id].Status; got != want {
+						t.Errorf("ExecuteWithResult() vertex:%v status = %v, want %v", id, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+)
+
+// buildErrors errors of a build, strict_dsl clusters collect all of them instead of stopping at the first
+type buildErrors struct {
+	strict bool
+	errs   []error
+	seen   map[string]bool
+}
+
+func newBuildErrors(strict bool) *buildErrors {
+	return &buildErrors{strict: strict, seen: make(map[string]bool)}
+}
+
+// add record err, true means stop building
+func (b *buildErrors) add(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, e := range unjoin(err) {
+		// the same problem may be found by build and by strict check
+		if !b.seen[e.Error()] {
+			b.seen[e.Error()] = true
+			b.errs = append(b.errs, e)
+		}
+	}
+	return !b.strict
+}
+
+func (b *buildErrors) err() error {
+	if len(b.errs) == 1 {
+		return b.errs[0]
+	}
+	return errors.Join(b.errs...)
+}
+
+// unjoin errors joined by errors.Join
+func unjoin(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// checkStrictDsl report all problems of a strict_dsl cluster
+func (c *Cluster) checkStrictDsl() error {
+	var errs []error
+	consumed := make(map[string]bool)
+	referenced := make(map[string]bool)
+	graphs := c.sortedGraphs()
+	for _, g := range c.callerGraphs() {
+		g.addConsumed(consumed)
+	}
+	for _, g := range graphs {
+		g.addConsumed(consumed)
+		for i := range g.Vertex {
+			v := &g.Vertex[i]
+			if len(v.ExpectConfig) > 0 {
+				name, _, _ := parseSettingRef(v.ExpectConfig)
+				referenced[name] = true
+			}
+			for _, cond := range v.SelectArgs {
+				name, _, _ := parseSettingRef(cond.Match)
+				referenced[name] = true
+			}
+		}
+	}
+	for _, cs := range c.ConfigSetting {
+		for _, dep := range cs.deps {
+			referenced[dep] = true
+		}
+	}
+	for _, g := range graphs {
+		reachable := g.getReachable()
+		for i := range g.Vertex {
+			v := &g.Vertex[i]
+			if v.isIDGenerated {
+				errs = append(errs, fmt.Errorf("Vertex:%s/%s id is auto generated", g.Name, v.getDotLabel()))
+			}
+			if len(v.Processor) > 0 && c.getOpMeta(v.Processor) == nil {
+				errs = append(errs, fmt.Errorf("Vertex:%s/%s processor:%s not found", g.Name, v.ID, v.Processor))
+			}
+			if v.isFallback {
+				continue
+			}
+			if !reachable[v] {
+				errs = append(errs, fmt.Errorf("Vertex:%s/%s is unreachable from start vertexes", g.Name, v.ID))
+			}
+			for _, data := range v.Output {
+				if !consumed[data.ID] && !data.Result {
+					errs = append(errs, fmt.Errorf("Vertex:%s/%s output:%s data id:%s is not consumed",
+						g.Name, v.ID, data.Field, data.ID))
+				}
+			}
+			for _, data := range v.Input {
+				if !data.isImplicit {
+					continue
+				}
+				dep := g.getVertexByData(data.ID)
+				if dep == nil {
+					continue
+				}
+				for _, output := range dep.Output {
+					if output.ID == data.ID && output.isImplicit {
+						errs = append(errs, fmt.Errorf("Vertex:%s/%s input:%s is implicitly wired to vertex:%s by field name, declare the data id",
+							g.Name, v.ID, data.Field, dep.ID))
+					}
+				}
+			}
+		}
+	}
+	for _, cs := range c.ConfigSetting {
+		if !referenced[cs.Name] {
+			errs = append(errs, fmt.Errorf("config_setting:%s is not referenced", cs.Name))
+		}
+	}
+	errs = append(errs, c.checkExprs()...)
+	return errors.Join(errs...)
+}
+
+// checkExprs type check expressions against declared params and variables set by engine
+func (c *Cluster) checkExprs() []error {
+	var errs []error
+	for _, cs := range c.ConfigSetting {
+		if len(cs.Cond) == 0 {
+			continue
+		}
+		settings := make(map[string]interface{}, len(c.ConfigSetting))
+		for _, other := range c.ConfigSetting {
+			if other.Name == cs.Name {
+				continue
+			}
+			if len(other.Processor) > 0 {
+				settings[other.Name] = map[string]bool{}
+			} else {
+				settings[other.Name] = false
+			}
+		}
+		if err := checkExpr(cs.Cond, c.exprEnv(settings)); err != nil {
+			errs = append(errs, fmt.Errorf("config_setting:%s invalid cond:%v", cs.Name, err))
+		}
+	}
+	params := c.exprEnv(nil)
+	for _, g := range c.sortedGraphs() {
+		if len(g.VersionCond) > 0 {
+			if err := checkExpr(g.VersionCond, params); err != nil {
+				errs = append(errs, fmt.Errorf("Graph:%s version:%s invalid version_cond:%v",
+					g.Name, g.ExpectVersion, err))
+			}
+		}
+		for i := range g.Vertex {
+			errs = append(errs, g.Vertex[i].checkExprs(params)...)
+		}
+	}
+	return errs
+}
+
+// checkExprs type check expressions of vertex, expect and output cond see RET_ variables of deps
+func (v *Vertex) checkExprs(params map[string]interface{}) []error {
+	var errs []error
+	if len(v.Cond) > 0 {
+		if err := checkExpr(v.Cond, params); err != nil {
+			errs = append(errs, fmt.Errorf("Vertex:%s/%s invalid cond:%v", v.g.Name, v.ID, err))
+		}
+	}
+	if len(v.Switch) > 0 {
+		if err := checkExpr(v.Switch, params); err != nil {
+			errs = append(errs, fmt.Errorf("Vertex:%s/%s invalid switch:%v", v.g.Name, v.ID, err))
+		}
+	}
+	rets := make(map[string]interface{}, 3*len(v.depsResults)+1)
+	for id := range v.depsResults {
+		rets["RET_CODE_"+id] = int64(0)
+		rets["RET_ATTEMPTS_"+id] = int64(0)
+		rets["RET_FALLBACK_"+id] = false
+	}
+	if len(v.Expect) > 0 {
+		if err := checkExpr(v.Expect, v.g.cluster.exprEnv(rets)); err != nil {
+			errs = append(errs, fmt.Errorf("Vertex:%s/%s invalid expect:%v", v.g.Name, v.ID, err))
+		}
+	}
+	rets["RET_CODE"] = int64(0)
+	for _, data := range v.Output {
+		if len(data.Cond) == 0 {
+			continue
+		}
+		if err := checkExpr(data.Cond, v.g.cluster.exprEnv(rets)); err != nil {
+			errs = append(errs, fmt.Errorf("Vertex:%s/%s invalid output:%s cond:%v", v.g.Name, v.ID, data.Field, err))
+		}
+	}
+	return errs
+}
+
+// addConsumed add data ids consumed by vertexes of graph, including ids of subgraph outputs
+// bound by foreach subgraph vertexes
+func (g *Graph) addConsumed(consumed map[string]bool) {
+	for i := range g.Vertex {
+		v := &g.Vertex[i]
+		for idx := range v.Input {
+			for _, id := range unitIDs(&v.Input[idx]) {
+				consumed[id] = true
+			}
+		}
+		if v.Foreach == nil {
+			continue
+		}
+		consumed[v.Foreach.Input] = true
+		if len(v.Graph) > 0 {
+			for _, data := range v.Output {
+				consumed[data.Field] = true
+			}
+		}
+	}
+}
+
+// callerGraphs graphs of other loaded clusters calling graphs of cluster by subgraph vertexes
+func (c *Cluster) callerGraphs() []*Graph {
+	if c.GraphManager == nil {
+		return nil
+	}
+	var callers []*Graph
+	for _, g := range sortedGraphs(c.GraphManager.snapshot()) {
+		if g.cluster.Name == c.Name {
+			// the version of cluster being replaced
+			continue
+		}
+		for i := range g.Vertex {
+			if v := &g.Vertex[i]; len(v.Graph) > 0 && v.Cluster == c.Name {
+				callers = append(callers, g)
+				break
+			}
+		}
+	}
+	return callers
+}
+
+func trimNot(name string) string {
+	if len(name) > 0 && name[0] == '!' {
+		return name[1:]
+	}
+	return name
+}
+
+// getReachable vertexes reachable from start vertexes
+func (g *Graph) getReachable() map[*Vertex]bool {
+	reachable := make(map[*Vertex]bool)
+	var queue []*Vertex
+	for i := range g.Vertex {
+		if v := &g.Vertex[i]; v.Start {
+			reachable[v] = true
+			queue = append(queue, v)
+		}
+	}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, successor := range v.successorVertex {
+			if !reachable[successor] {
+				reachable[successor] = true
+				queue = append(queue, successor)
+			}
+		}
+	}
+	return reachable
+}
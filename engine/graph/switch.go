@@ -0,0 +1,135 @@
+package graph
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"xxxx/innererror"
+)
+
+// SwitchCase one branch of switch vertex
+type SwitchCase struct {
+	Value interface{} `toml:"value" json:"value"`
+	Next  []string    `toml:"next" json:"next"`
+}
+
+// buildSwitch compile switch expression and depend successors of every branch on the switch vertex
+func (v *Vertex) buildSwitch() error {
+	if len(v.Switch) == 0 {
+		if len(v.Cases) > 0 || len(v.Default) > 0 {
+			return fmt.Errorf("Vertex:%s/%s cases/default is only supported on switch vertex", v.g.Name, v.ID)
+		}
+		return nil
+	}
+	if len(v.Cond) > 0 || len(v.Processor) > 0 || len(v.Graph) > 0 || v.Foreach != nil {
+		return fmt.Errorf("Vertex:%s/%s switch vertex can NOT config cond/processor/graph/foreach", v.g.Name, v.ID)
+	}
+	if len(v.SuccessorOnOk)+len(v.SuccessorOnErr) > 0 {
+		return fmt.Errorf("Vertex:%s/%s switch vertex use cases/default instead of if/else", v.g.Name, v.ID)
+	}
+	program, err := compileExpr(v.Switch)
+	if err != nil {
+		return fmt.Errorf("Vertex:%s/%s invalid switch:%v", v.g.Name, v.ID, err)
+	}
+	v.switchProgram = program
+	values := make(map[string]bool, len(v.Cases))
+	for i, c := range v.Cases {
+		value := fmt.Sprint(c.Value)
+		if values[value] {
+			return fmt.Errorf("Vertex:%s/%s duplicate switch case:%s", v.g.Name, v.ID, value)
+		}
+		values[value] = true
+		if err := v.buildBranch(c.Next, i); err != nil {
+			return err
+		}
+	}
+	return v.buildBranch(v.Default, len(v.Cases))
+}
+
+// buildBranch successors run only when the switch takes branch
+func (v *Vertex) buildBranch(next []string, branch int) error {
+	for _, id := range next {
+		successor := v.g.getVertexByID(id)
+		if successor == nil {
+			return fmt.Errorf("[%s]No successor id:%s", v.getDotLabel(), id)
+		}
+		if successor.isFallback {
+			return fmt.Errorf("[%s]Can NOT use fallback vertex id:%s as successor", v.getDotLabel(), id)
+		}
+		successor.depend(v, innererror.VResultOk)
+		if successor.switchBranches == nil {
+			successor.switchBranches = make(map[string]map[int]bool)
+		}
+		if successor.switchBranches[v.ID] == nil {
+			successor.switchBranches[v.ID] = make(map[int]bool)
+		}
+		successor.switchBranches[v.ID][branch] = true
+	}
+	return nil
+}
+
+// getBranchLabel label of branch, case value or default
+func (v *Vertex) getBranchLabel(branch int) string {
+	if branch < len(v.Cases) {
+		return fmt.Sprint(v.Cases[branch].Value)
+	}
+	return "default"
+}
+
+func (v *Vertex) dumpSwitchEdge(s *strings.Builder, r *dotResult) {
+	for _, successor := range v.successorVertex {
+		var labels []string
+		for branch := 0; branch <= len(v.Cases); branch++ {
+			if successor.switchBranches[v.ID][branch] {
+				labels = append(labels, v.getBranchLabel(branch))
+			}
+		}
+		label := strings.ReplaceAll(strings.Join(labels, ","), "\"", "\\\"")
+		s.WriteString("    " + v.getDotID() + " -> " + successor.getDotID())
+		if r != nil {
+			s.WriteString(r.getBranchStyle(v, successor, label) + ";\n")
+			continue
+		}
+		s.WriteString(" [style=dashed label=\"" + label + "\"];\n")
+	}
+}
+
+// evalSwitch evaluate switch expression once and record the taken branch, default if no case matches
+func (v *VertexContext) evalSwitch() {
+	if len(v.Vertex.Switch) == 0 {
+		return
+	}
+	v.result.branch = len(v.Vertex.Cases)
+	if v.GraphContext.ClusterContext.ExecuteParams == nil {
+		return
+	}
+	output, err := runExpr(v.Vertex.switchProgram, v.GraphContext.ClusterContext.ExecuteParams)
+	if err != nil {
+		log.Printf("expr name:%v switch:%v err:%v", v.Vertex.ID, v.Vertex.Switch, err)
+		return
+	}
+	value := fmt.Sprint(output)
+	for i, c := range v.Vertex.Cases {
+		if fmt.Sprint(c.Value) == value {
+			v.result.branch = i
+			return
+		}
+	}
+}
+
+// checkSwitchBranch check switch dependencies took a branch this vertex is on
+func (v *VertexContext) checkSwitchBranch() error {
+	for id, branches := range v.Vertex.switchBranches {
+		r, ok := v.vertexDepResults.Load(id)
+		if !ok {
+			continue
+		}
+		vr, _ := r.(*vertexResult)
+		if !branches[vr.branch] {
+			return innererror.Errorf(innererror.VResultErr, "switch:%v branch:%v not taken", id,
+				v.GraphContext.Graph.getVertexByID(id).getBranchLabel(vr.branch))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,476 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/innererror"
+)
+
+// TraceCodec encode and decode data of one registered type in trace
+type TraceCodec interface {
+	Name() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, t reflect.Type) (reflect.Value, error)
+}
+
+// JSONTraceCodec encode trace data as JSON, only exported fields are kept
+type JSONTraceCodec struct{}
+
+// Name JSON trace codec
+func (JSONTraceCodec) Name() string {
+	return "json"
+}
+
+// Encode JSON encode
+func (JSONTraceCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode JSON decode to value of type t
+func (JSONTraceCodec) Decode(data []byte, t reflect.Type) (reflect.Value, error) {
+	rv := reflect.New(t)
+	if err := json.Unmarshal(data, rv.Interface()); err != nil {
+		return rv, err
+	}
+	return rv.Elem(), nil
+}
+
+// GobTraceCodec encode trace data as gob
+type GobTraceCodec struct{}
+
+// Name gob trace codec
+func (GobTraceCodec) Name() string {
+	return "gob"
+}
+
+// Encode gob encode
+func (GobTraceCodec) Encode(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decode gob decode to value of type t
+func (GobTraceCodec) Decode(data []byte, t reflect.Type) (reflect.Value, error) {
+	rv := reflect.New(t)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(rv.Interface()); err != nil {
+		return rv, err
+	}
+	return rv.Elem(), nil
+}
+
+type traceType struct {
+	t     reflect.Type
+	codec TraceCodec
+}
+
+var (
+	traceTypes    = make(map[string]*traceType)
+	traceTypeLock sync.RWMutex
+)
+
+// RegisterTraceType register codec of data type of sample, data of unregistered types
+// is recorded with its type only and can NOT be replayed
+func RegisterTraceType(sample interface{}, codec TraceCodec) {
+	t := reflect.TypeOf(sample)
+	traceTypeLock.Lock()
+	traceTypes[t.String()] = &traceType{t: t, codec: codec}
+	traceTypeLock.Unlock()
+}
+
+func getTraceType(name string) *traceType {
+	traceTypeLock.RLock()
+	defer traceTypeLock.RUnlock()
+	return traceTypes[name]
+}
+
+// TraceData one recorded data
+type TraceData struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Codec string `json:"codec,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// newTraceData encode value with codec of its type
+func newTraceData(name string, rv reflect.Value) *TraceData {
+	d := &TraceData{Name: name, Type: rv.Type().String()}
+	tt := getTraceType(d.Type)
+	if tt == nil {
+		return d
+	}
+	data, err := tt.codec.Encode(rv.Interface())
+	if err != nil {
+		d.Codec = fmt.Sprintf("error:%v", err)
+		return d
+	}
+	d.Codec = tt.codec.Name()
+	d.Data = data
+	return d
+}
+
+// decode value of recorded data, false if type is not registered or data not recorded
+func (d *TraceData) decode() (reflect.Value, bool) {
+	tt := getTraceType(d.Type)
+	if tt == nil || tt.codec.Name() != d.Codec {
+		return reflect.Value{}, false
+	}
+	rv, err := tt.codec.Decode(d.Data, tt.t)
+	return rv, err == nil
+}
+
+// VertexTrace recorded execution of one vertex
+type VertexTrace struct {
+	// Call subgraph vertexes calling the graph, empty for the entry graph
+	Call      string        `json:"call,omitempty"`
+	Cluster   string        `json:"cluster"`
+	Graph     string        `json:"graph"`
+	Vertex    string        `json:"vertex"`
+	Processor string        `json:"processor,omitempty"`
+	SubGraph  string        `json:"sub_graph,omitempty"`
+	Inputs    []*TraceData  `json:"inputs,omitempty"`
+	Outputs   []*TraceData  `json:"outputs,omitempty"`
+	Code      int32         `json:"code"`
+	Status    string        `json:"status"`
+	Duration  time.Duration `json:"duration"`
+	Attempts  int           `json:"attempts"`
+}
+
+func (v *VertexTrace) key() string {
+	return traceKey(v.Call, v.Cluster, v.Graph, v.Vertex)
+}
+
+func traceKey(call string, cluster string, graph string, vertex string) string {
+	return call + "|" + cluster + "::" + graph + "/" + vertex
+}
+
+// ExecuteResult execution report of the entry graph rebuilt from trace
+func (t *Trace) ExecuteResult() *ExecuteResult {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	result := t.graphResult("", t.Cluster, t.Graph)
+	result.Version = t.Version
+	return result
+}
+
+func (t *Trace) graphResult(call string, cluster string, graph string) *ExecuteResult {
+	result := &ExecuteResult{Cluster: cluster, Graph: graph, Vertexes: make(map[string]*VertexResult)}
+	for _, vt := range t.Vertexes {
+		if vt.Call != call || vt.Cluster != cluster || vt.Graph != graph {
+			continue
+		}
+		if _, ok := result.Vertexes[vt.Vertex]; ok {
+			continue
+		}
+		vr := &VertexResult{
+			ID:        vt.Vertex,
+			Processor: vt.Processor,
+			Status:    parseVertexStatus(vt.Status),
+			Code:      vt.Code,
+			Duration:  vt.Duration,
+			Attempts:  vt.Attempts,
+		}
+		if idx := strings.Index(vt.SubGraph, "::"); idx > 0 {
+			subCall := vt.Cluster + "::" + vt.Graph + "/" + vt.Vertex
+			if len(call) > 0 {
+				subCall = call + ">" + subCall
+			}
+			vr.SubGraph = t.graphResult(subCall, vt.SubGraph[:idx], vt.SubGraph[idx+2:])
+		}
+		result.Vertexes[vt.Vertex] = vr
+	}
+	return result
+}
+
+// Trace recorded execution of one request, vertexes of subgraphs included
+type Trace struct {
+	Cluster        string          `json:"cluster"`
+	Graph          string          `json:"graph"`
+	Version        string          `json:"version"`
+	Params         param.Params    `json:"params"`
+	ConfigSettings map[string]bool `json:"config_settings"`
+	ExternInputs   []*TraceData    `json:"extern_inputs"`
+	Vertexes       []*VertexTrace  `json:"vertexes"`
+
+	lock sync.Mutex
+}
+
+// WriteTo write trace as JSON
+func (t *Trace) WriteTo(w io.Writer) (int64, error) {
+	t.lock.Lock()
+	data, err := json.Marshal(t)
+	t.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// ReadTrace read JSON trace, integer params are decoded as int64 like toml args
+func ReadTrace(r io.Reader) (*Trace, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	t := &Trace{}
+	if err := decoder.Decode(t); err != nil {
+		return nil, err
+	}
+	t.Params, _ = fromJSONNumber(map[string]interface{}(t.Params)).(map[string]interface{})
+	return t, nil
+}
+
+func fromJSONNumber(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range value {
+			value[k] = fromJSONNumber(e)
+		}
+	case []interface{}:
+		for i, e := range value {
+			value[i] = fromJSONNumber(e)
+		}
+	}
+	return v
+}
+
+// WithTrace record the execution into trace
+func WithTrace(trace *Trace) ExecuteOption {
+	return func(o *executeOptions) {
+		o.trace = trace
+	}
+}
+
+// begin record request of the entry graph
+func (t *Trace) begin(cluster string, g *Graph, params *param.Params) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.Cluster = cluster
+	t.Graph = g.Name
+	t.Version = g.ExpectVersion
+	if params != nil {
+		t.Params = *params.Clone()
+	}
+}
+
+func (t *Trace) setConfigSetting(name string, value bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.ConfigSettings == nil {
+		t.ConfigSettings = make(map[string]bool)
+	}
+	t.ConfigSettings[name] = value
+}
+
+func (t *Trace) addExternInput(d *TraceData) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, input := range t.ExternInputs {
+		if input.Name == d.Name && input.Type == d.Type {
+			return
+		}
+	}
+	t.ExternInputs = append(t.ExternInputs, d)
+}
+
+func (t *Trace) addVertex(v *VertexTrace) {
+	t.lock.Lock()
+	t.Vertexes = append(t.Vertexes, v)
+	t.lock.Unlock()
+}
+
+// processorFields encode fields of processor with tags
+func processorFields(p *ProcessorDI, tags ...string) []*TraceData {
+	rVal := p.processorValue()
+	var fields []*TraceData
+	for _, f := range p.getPlan().fields {
+		for _, want := range tags {
+			if f.tag == want {
+				fields = append(fields, newTraceData(f.name, rVal.FieldByIndex(f.index)))
+			}
+		}
+	}
+	return fields
+}
+
+// traceInputs record injected inputs of processor, extern inputs are also recorded for replay
+func (v *VertexContext) traceInputs() {
+	t := v.GraphContext.ClusterContext.trace
+	if t == nil {
+		return
+	}
+	v.tracedInputs = processorFields(v.ProcessorDI, cInput, cMultiInput, cExternInput)
+	for _, d := range processorFields(v.ProcessorDI, cExternInput) {
+		t.addExternInput(d)
+	}
+}
+
+// traceVertex record result of vertex and outputs of the executed processor
+func (v *VertexContext) traceVertex() {
+	cc := v.GraphContext.ClusterContext
+	if cc.trace == nil {
+		return
+	}
+	r := v.getResult()
+	vt := &VertexTrace{
+		Call:      cc.callPath,
+		Cluster:   cc.Cluster.Name,
+		Graph:     v.GraphContext.Graph.Name,
+		Vertex:    v.Vertex.ID,
+		Processor: v.Vertex.Processor,
+		SubGraph:  r.SubGraphName(),
+		Code:      r.Code,
+		Status:    r.Status.String(),
+		Duration:  r.Duration,
+		Attempts:  r.Attempts,
+	}
+	executed := v
+	if v.result.fallback && v.fallback != nil {
+		executed = v.fallback
+	}
+	if executed.Processor != nil && v.Vertex.Foreach == nil &&
+		(v.result.status == VertexOk || v.result.status == VertexErr) {
+		vt.Inputs = executed.tracedInputs
+		vt.Outputs = processorFields(executed.ProcessorDI, cOutput)
+	}
+	cc.trace.addVertex(vt)
+}
+
+// replayer replace processors by outputs recorded in trace
+type replayer struct {
+	trace      *Trace
+	processors map[string]bool
+	vertexes   map[string]*VertexTrace
+}
+
+// WithReplay replace the processors by outputs and result codes recorded in trace,
+// all recorded processors are replaced if processors is empty
+func WithReplay(trace *Trace, processors ...string) ExecuteOption {
+	r := &replayer{
+		trace:      trace,
+		processors: make(map[string]bool, len(processors)),
+		vertexes:   make(map[string]*VertexTrace, len(trace.Vertexes)),
+	}
+	for _, name := range processors {
+		r.processors[name] = true
+	}
+	for _, vt := range trace.Vertexes {
+		r.vertexes[vt.key()] = vt
+	}
+	return func(o *executeOptions) {
+		o.replay = r
+	}
+}
+
+// getVertex recorded execution of the vertex in the same subgraph call, nil if not recorded,
+// skipped or ended by timeout and cancel, which have no outputs to replay
+func (r *replayer) getVertex(call string, cluster string, graph string, vertex *Vertex) *VertexTrace {
+	if len(r.processors) > 0 && !r.processors[vertex.Processor] {
+		return nil
+	}
+	vt, ok := r.vertexes[traceKey(call, cluster, graph, vertex.ID)]
+	if !ok || (vt.Status != VertexOk.String() && vt.Status != VertexErr.String()) {
+		return nil
+	}
+	return vt
+}
+
+// replayProcessor set recorded outputs to processor instead of executing it, false if not recorded,
+// err if a recorded output can NOT be decoded
+func (v *VertexContext) replayProcessor() (bool, error) {
+	cc := v.GraphContext.ClusterContext
+	if cc.replay == nil {
+		return false, nil
+	}
+	vt := cc.replay.getVertex(cc.callPath, cc.Cluster.Name, v.GraphContext.Graph.Name, v.Vertex)
+	if vt == nil {
+		return false, nil
+	}
+	v.ProcessorDI.Reset()
+	rVal := v.ProcessorDI.processorValue()
+	plan := v.ProcessorDI.getPlan()
+	for _, d := range vt.Outputs {
+		pf, ok := plan.byName[d.Name]
+		if !ok {
+			continue
+		}
+		f := rVal.FieldByIndex(pf.index)
+		rv, ok := d.decode()
+		if !ok || !rv.Type().AssignableTo(f.Type()) {
+			return true, fmt.Errorf("vertex:%s output:%s type:%s can NOT be replayed, codec:%s",
+				v.Vertex.ID, d.Name, d.Type, d.Codec)
+		}
+		f.Set(rv)
+	}
+	v.result.attempts = vt.Attempts
+	if vt.Code != 0 {
+		v.result.processorResult = innererror.Error(vt.Code)
+	}
+	return true, nil
+}
+
+// Replay execute graph of trace with recorded params, config settings and extern inputs,
+// processors are replaced by recorded outputs, all recorded processors if processors is empty
+func (m *Manager) Replay(ctx context.Context, trace *Trace, processors []string,
+	opts ...ExecuteOption) (*ExecuteResult, error) {
+	dataContext := NewDataContext()
+	for _, d := range trace.ExternInputs {
+		rv, ok := d.decode()
+		if !ok {
+			return nil, fmt.Errorf("extern input:%s type:%s can NOT be decoded, codec:%s not registered",
+				d.Name, d.Type, d.Codec)
+		}
+		dataContext.Set(NewDIObjectKey(d.Name, rv.Type()), rv)
+	}
+	params := trace.Params.Clone()
+	opts = append([]ExecuteOption{WithReplay(trace, processors...)}, opts...)
+	return m.execute(ctx, trace.Cluster, trace.Graph, dataContext, params, nil, opts...)
+}
+
+// Replay execute graph of trace on default manager
+func Replay(ctx context.Context, trace *Trace, processors []string,
+	opts ...ExecuteOption) (*ExecuteResult, error) {
+	return DefaultManager.Replay(ctx, trace, processors, opts...)
+}
+
+// replaySetting set recorded config setting value and flags, false if not recorded
+func (c *ClusterContext) replaySetting(cs *ConfigSetting, sv *settingValue) bool {
+	if c.replay == nil {
+		return false
+	}
+	t := c.replay.trace
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	value, ok := t.ConfigSettings[cs.Name]
+	if !ok {
+		return false
+	}
+	sv.value = value
+	prefix := cs.Name + "."
+	for name, flag := range t.ConfigSettings {
+		if strings.HasPrefix(name, prefix) {
+			if sv.flags == nil {
+				sv.flags = make(map[string]bool)
+			}
+			sv.flags[name[len(prefix):]] = flag
+		}
+	}
+	return true
+}
@@ -0,0 +1,262 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/dagengine/engine/processor"
+)
+
+type traceUser struct {
+	Name string
+}
+
+var traceFetchCalls int32
+
+type traceFetch struct {
+	User  *traceUser `graph:"extern_input"`
+	Items []string   `graph:"output"`
+}
+
+func (p *traceFetch) OnInit() {
+}
+
+func (p *traceFetch) OnExecute(_ context.Context, params *param.Params) error {
+	atomic.AddInt32(&traceFetchCalls, 1)
+	for i := 0; i < int(params.GetInt64("n")); i++ {
+		p.Items = append(p.Items, p.User.Name+strconv.Itoa(i))
+	}
+	return nil
+}
+
+type traceRank struct {
+	Items  []string `graph:"input"`
+	Ranked []string `graph:"output"`
+}
+
+func (p *traceRank) OnInit() {
+}
+
+func (p *traceRank) OnExecute(_ context.Context, _ *param.Params) error {
+	p.Ranked = append(p.Ranked, p.Items...)
+	sort.Sort(sort.Reverse(sort.StringSlice(p.Ranked)))
+	return nil
+}
+
+func getVertexTrace(trace *Trace, vertex string) *VertexTrace {
+	for _, vt := range trace.Vertexes {
+		if vt.Vertex == vertex {
+			return vt
+		}
+	}
+	return nil
+}
+
+func getTraceOutput(t *testing.T, trace *Trace, vertex string, name string) interface{} {
+	vt := getVertexTrace(trace, vertex)
+	if vt == nil {
+		t.Fatalf("trace of vertex:%s not found", vertex)
+	}
+	for _, d := range vt.Outputs {
+		if d.Name == name {
+			rv, ok := d.decode()
+			if !ok {
+				t.Fatalf("trace output:%s of vertex:%s can not be decoded", name, vertex)
+			}
+			return rv.Interface()
+		}
+	}
+	return nil
+}
+
+func TestManager_TraceReplay(t *testing.T) {
+	processor.Register("trace_fetch", func() processor.Processor { return &traceFetch{} })
+	processor.Register("trace_rank", func() processor.Processor { return &traceRank{} })
+	RegisterTraceType(&traceUser{}, GobTraceCodec{})
+	RegisterTraceType([]string{}, JSONTraceCodec{})
+	if err := LoadFile("../../cmd/trace_test.toml"); err != nil {
+		t.Fatalf("Manager.LoadFile() error = %v", err)
+	}
+	dataContext := NewDataContext()
+	user := &traceUser{Name: "u"}
+	dataContext.Set(NewDIObjectKey("User", reflect.TypeOf(user)), reflect.ValueOf(user))
+	trace := &Trace{}
+	if _, err := ExecuteWithResult(context.Background(), "trace_test.toml", "enter", dataContext,
+		&param.Params{"level": int64(2), "n": int64(3)}, WithTrace(trace)); err != nil {
+		t.Fatalf("ExecuteWithResult() error = %v", err)
+	}
+	if !trace.ConfigSettings["vip"] || len(trace.ExternInputs) != 1 || len(trace.Vertexes) != 2 {
+		t.Fatalf("ExecuteWithResult() trace = %+v, want vip setting, extern input User and 2 vertexes", trace)
+	}
+	wantRanked := []string{"u2", "u1", "u0"}
+	if got := getTraceOutput(t, trace, "trace_rank", "Ranked"); !reflect.DeepEqual(got, wantRanked) {
+		t.Errorf("ExecuteWithResult() traced Ranked = %v, want %v", got, wantRanked)
+	}
+
+	buffer := &bytes.Buffer{}
+	if _, err := trace.WriteTo(buffer); err != nil {
+		t.Fatalf("Trace.WriteTo() error = %v", err)
+	}
+	recorded, err := ReadTrace(buffer)
+	if err != nil {
+		t.Fatalf("ReadTrace() error = %v", err)
+	}
+	if got := recorded.Params.GetInt64("n"); got != 3 {
+		t.Errorf("ReadTrace() params n = %v, want 3", got)
+	}
+	tests := []struct {
+		name       string
+		processors []string
+		wantCalls  int32
+	}{
+		{name: "replay_fetch", processors: []string{"trace_fetch"}, wantCalls: 0},
+		{name: "replay_all", processors: nil, wantCalls: 0},
+		{name: "replay_rank", processors: []string{"trace_rank"}, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := atomic.LoadInt32(&traceFetchCalls)
+			replayed := &Trace{}
+			result, err := Replay(context.Background(), recorded, tt.processors, WithTrace(replayed))
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			if got := atomic.LoadInt32(&traceFetchCalls) - before; got != tt.wantCalls {
+				t.Errorf("Replay() trace_fetch calls = %v, want %v", got, tt.wantCalls)
+			}
+			if got := result.Vertexes["trace_rank"].Status; got != VertexOk {
+				t.Errorf("Replay() trace_rank status = %v, want %v", got, VertexOk)
+			}
+			if got := getTraceOutput(t, replayed, "trace_rank", "Ranked"); !reflect.DeepEqual(got, wantRanked) {
+				t.Errorf("Replay() Ranked = %v, want %v", got, wantRanked)
+			}
+		})
+	}
+}
+
+type traceCount struct {
+	N int
+}
+
+type traceCounter struct {
+	Items []string   `graph:"input"`
+	Count traceCount `graph:"output"`
+}
+
+func (p *traceCounter) OnInit() {
+}
+
+func (p *traceCounter) OnExecute(_ context.Context, _ *param.Params) error {
+	p.Count.N = len(p.Items)
+	return nil
+}
+
+func TestManager_ReplaySubGraph(t *testing.T) {
+	content := `
+name = "trace_sub_test.toml"
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+id = "fetch_a"
+processor = "trace_fetch"
+args = {n = 1}
+start = true
+output = [{field = "Items", id = "items_a"}]
+[[graph.vertex]]
+id = "fetch_b"
+processor = "trace_fetch"
+args = {n = 3}
+start = true
+output = [{field = "Items", id = "items_b"}]
+[[graph.vertex]]
+id = "call_a"
+cluster = "."
+graph = "rank"
+args = {in = "items_a", out = "ranked_a"}
+deps = ["fetch_a"]
+[[graph.vertex]]
+id = "call_b"
+cluster = "."
+graph = "rank"
+args = {in = "items_b", out = "ranked_b"}
+deps = ["fetch_b"]
+[[graph.vertex]]
+processor = "trace_counter"
+input = [{field = "Items", id = "items_b"}]
+deps = ["fetch_b"]
+
+[[graph]]
+name = "rank"
+[[graph.vertex]]
+processor = "trace_rank"
+start = true
+input = [{field = "Items", id = "$in", extern = true}]
+output = [{field = "Ranked", id = "$out"}]
+`
+	processor.Register("trace_fetch", func() processor.Processor { return &traceFetch{} })
+	processor.Register("trace_rank", func() processor.Processor { return &traceRank{} })
+	processor.Register("trace_counter", func() processor.Processor { return &traceCounter{} })
+	RegisterTraceType(&traceUser{}, GobTraceCodec{})
+	RegisterTraceType([]string{}, JSONTraceCodec{})
+	m := New()
+	if err := m.load("trace_sub_test.toml", []byte(content), &TomlCodec{}); err != nil {
+		t.Fatalf("Manager.load() error = %v", err)
+	}
+	dataContext := NewDataContext()
+	user := &traceUser{Name: "u"}
+	dataContext.Set(NewDIObjectKey("User", reflect.TypeOf(user)), reflect.ValueOf(user))
+	trace := &Trace{}
+	if _, err := m.execute(context.Background(), "trace_sub_test.toml", "enter", dataContext,
+		&param.Params{}, nil, WithTrace(trace)); err != nil {
+		t.Fatalf("Manager.execute() error = %v", err)
+	}
+	result := trace.ExecuteResult()
+	for _, call := range []string{"call_a", "call_b"} {
+		if vr := result.Vertexes[call]; vr.SubGraph == nil || vr.SubGraph.Vertexes["trace_rank"] == nil {
+			t.Errorf("Trace.ExecuteResult() vertex:%s subgraph = %+v, want trace_rank", call, vr.SubGraph)
+		}
+	}
+	// records of the same subgraph vertex are matched by call, not by order
+	for i, j := 0, len(trace.Vertexes)-1; i < j; i, j = i+1, j-1 {
+		trace.Vertexes[i], trace.Vertexes[j] = trace.Vertexes[j], trace.Vertexes[i]
+	}
+
+	tests := []struct {
+		name       string
+		processors []string
+		wantStatus map[string]VertexStatus
+	}{
+		{name: "subgraph_calls", processors: []string{"trace_rank"},
+			wantStatus: map[string]VertexStatus{"call_a": VertexOk, "call_b": VertexOk, "trace_counter": VertexOk}},
+		{name: "unregistered_output", processors: []string{"trace_counter"},
+			wantStatus: map[string]VertexStatus{"trace_counter": VertexErr}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataContext := NewDataContext()
+			dataContext.Set(NewDIObjectKey("User", reflect.TypeOf(user)), reflect.ValueOf(user))
+			result, err := m.execute(context.Background(), "trace_sub_test.toml", "enter", dataContext,
+				&param.Params{}, nil, WithReplay(trace, tt.processors...))
+			if err != nil {
+				t.Fatalf("Manager.execute() error = %v", err)
+			}
+			for id, want := range tt.wantStatus {
+				if got := result.Vertexes[id].Status; got != want {
+					t.Errorf("Manager.execute() vertex:%v status = %v, want %v", id, got, want)
+				}
+			}
+			for id, want := range map[string][]string{"ranked_a": {"u0"}, "ranked_b": {"u2", "u1", "u0"}} {
+				v, _ := dataContext.Get(NewDIObjectKey(id, reflect.TypeOf([]string{})))
+				if rv, ok := v.(reflect.Value); !ok || !reflect.DeepEqual(rv.Interface(), want) {
+					t.Errorf("Manager.execute() %s = %v, want %v", id, v, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"xxxx/dagengine/engine/param"
+	"xxxx/dagengine/engine/processor"
+)
+
+type typedLabelIn struct {
+	Name  string `graph:"element"`
+	Index int    `graph:"index"`
+}
+
+type typedLabelOut struct {
+	Label string
+}
+
+type typedLabelArgs struct {
+	Prefix string `param:"prefix,required"`
+}
+
+type typedJoinIn struct {
+	Labels []string
+	Count  *int `graph:"extern_input"`
+}
+
+type typedJoinOut struct {
+	Joined string
+	Total  *int
+}
+
+type typedJoinArgs struct {
+	Sep   string `param:"sep"`
+	Limit int    `param:"limit"`
+}
+
+func (a *typedJoinArgs) Validate() error {
+	if a.Limit < 0 {
+		return fmt.Errorf("limit:%d < 0", a.Limit)
+	}
+	return nil
+}
+
+func TestManager_ExecuteTyped(t *testing.T) {
+	content := `
+name = "typed_test.toml"
+[[graph]]
+name = "enter"
+[[graph.vertex]]
+processor = "recall_channels"
+start = true
+args = {channels = "a,b,c"}
+[[graph.vertex]]
+id = "label"
+processor = "typed_label"
+args = {prefix = "x"}
+foreach = {input = "Channels"}
+output = [{field = "Label", id = "labels"}]
+[[graph.vertex]]
+processor = "typed_join"
+args = {sep = "|", limit = 2}
+input = [{field = "Labels", id = "labels"}]
+`
+	processor.Register("recall_channels", func() processor.Processor { return &recallChannels{} })
+	processor.RegisterTyped("typed_label",
+		func(_ context.Context, in typedLabelIn, args typedLabelArgs) (typedLabelOut, error) {
+			return typedLabelOut{Label: args.Prefix + in.Name + strconv.Itoa(in.Index)}, nil
+		})
+	processor.RegisterTyped("typed_join",
+		func(_ context.Context, in typedJoinIn, args typedJoinArgs) (typedJoinOut, error) {
+			if in.Count == nil || *in.Count != 0 {
+				return typedJoinOut{}, fmt.Errorf("pointer input not reset")
+			}
+			labels := in.Labels
+			if args.Limit > 0 && len(labels) > args.Limit {
+				labels = labels[:args.Limit]
+			}
+			total := len(in.Labels)
+			return typedJoinOut{Joined: strings.Join(labels, args.Sep), Total: &total}, nil
+		})
+
+	want := processor.OperatorMeta{Name: "typed_join",
+		Input: []processor.FieldMeta{{Name: "Labels", Type: reflect.TypeOf([]string{})}},
+		Output: []processor.FieldMeta{{Name: "Joined", Type: reflect.TypeOf("")},
+			{Name: "Total", Type: reflect.TypeOf(new(int))}}}
+	if got := processor.GenerateMeta("typed_join", processor.Get("typed_join")); !reflect.DeepEqual(got, want) {
+		t.Errorf("GenerateMeta() = %+v, want %+v", got, want)
+	}
+
+	m := New()
+	if err := m.load("typed_test.toml", []byte(content), &TomlCodec{}); err != nil {
+		t.Fatalf("Manager.load() error = %v", err)
+	}
+	// the second execution reuses processors of the pooled cluster context
+	for i := 0; i < 2; i++ {
+		dataContext := NewDataContext()
+		if _, err := m.execute(context.Background(), "typed_test.toml", "enter", dataContext,
+			&param.Params{}, nil); err != nil {
+			t.Fatalf("Manager.execute() error = %v", err)
+		}
+		v, ok := dataContext.Get(NewDIObjectKey("Joined", reflect.TypeOf("")))
+		if !ok {
+			t.Fatalf("execute %d: Joined not produced", i)
+		}
+		if got := v.(reflect.Value).Interface(); got != "xa0|xb1" {
+			t.Errorf("execute %d: Joined = %v, want xa0|xb1", i, got)
+		}
+		v, ok = dataContext.Get(NewDIObjectKey("Total", reflect.TypeOf(new(int))))
+		if !ok {
+			t.Fatalf("execute %d: Total not produced", i)
+		}
+		if got := v.(reflect.Value).Interface().(*int); *got != 3 {
+			t.Errorf("execute %d: Total = %d, want 3", i, *got)
+		}
+	}
+}
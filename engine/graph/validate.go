@@ -0,0 +1,357 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"xxxx/dagengine/engine/param"
+)
+
+// getGraph get graph by name
+func (c *Cluster) getGraph(name string) *Graph {
+	g, exist := c.graphMap[name]
+	if !exist {
+		return nil
+	}
+	return g
+}
+
+func (g *Graph) getLabel() string {
+	if len(g.ExpectVersion) > 0 {
+		return fmt.Sprintf("%s::%s@%s", g.cluster.Name, g.Name, g.ExpectVersion)
+	}
+	return fmt.Sprintf("%s::%s", g.cluster.Name, g.Name)
+}
+
+// sortedGraphs all versions of graphs in cluster, in name order
+func (c *Cluster) sortedGraphs() []*Graph {
+	names := make([]string, 0, len(c.versions))
+	for name := range c.versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	graphs := make([]*Graph, 0, len(c.Graph))
+	for _, name := range names {
+		graphs = append(graphs, c.versions[name].graphs...)
+	}
+	return graphs
+}
+
+// sortedGraphs all built graphs of clusters in name order
+func sortedGraphs(clusters map[string]*Cluster) []*Graph {
+	names := make([]string, 0, len(clusters))
+	for name := range clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var graphs []*Graph
+	for _, name := range names {
+		graphs = append(graphs, clusters[name].sortedGraphs()...)
+	}
+	return graphs
+}
+
+// getCallees all versions of graph called by subgraph vertex, nil if not a subgraph vertex or not found
+func getCallees(clusters map[string]*Cluster, v *Vertex) []*Graph {
+	if len(v.Graph) == 0 {
+		return nil
+	}
+	c, exist := clusters[v.Cluster]
+	if !exist {
+		return nil
+	}
+	gv, exist := c.versions[v.Graph]
+	if !exist {
+		return nil
+	}
+	return gv.graphs
+}
+
+// Validate check subgraph references, subgraph call cycles and extern inputs of called graphs
+// across all loaded clusters
+func Validate() error {
+	return DefaultManager.Validate()
+}
+
+// Validate check subgraph references, subgraph call cycles and extern inputs of called graphs
+// across all loaded clusters
+func (m *Manager) Validate() error {
+	return validateClusters(m.snapshot())
+}
+
+func validateClusters(clusters map[string]*Cluster) error {
+	if err := validateSubGraphRefs(clusters); err != nil {
+		return err
+	}
+	if err := validateSubGraphCycles(clusters); err != nil {
+		return err
+	}
+	return validateExternInputs(clusters)
+}
+
+// clusterError validation problem with the clusters involved
+type clusterError struct {
+	clusters []string
+	err      error
+}
+
+func (e *clusterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *clusterError) Unwrap() error {
+	return e.err
+}
+
+// validateChanged validate clusters after changed ones are updated or removed. Problems of changed clusters
+// and clusters calling them block the change, problems only involving other clusters are returned as others.
+func validateChanged(clusters map[string]*Cluster, changed []string) (blocking error, others []*clusterError) {
+	affected := affectedClusters(clusters, changed)
+	var errs []error
+	for _, err := range []error{validateSubGraphRefs(clusters), validateSubGraphCycles(clusters),
+		validateExternInputs(clusters)} {
+		if err == nil {
+			continue
+		}
+		for _, e := range unjoin(err) {
+			ce, ok := e.(*clusterError)
+			if !ok {
+				errs = append(errs, e)
+				continue
+			}
+			involved := false
+			for _, name := range ce.clusters {
+				involved = involved || affected[name]
+			}
+			if involved {
+				errs = append(errs, ce)
+			} else {
+				others = append(others, ce)
+			}
+		}
+	}
+	return errors.Join(errs...), others
+}
+
+// affectedClusters changed clusters and clusters calling them directly or through other clusters
+func affectedClusters(clusters map[string]*Cluster, changed []string) map[string]bool {
+	affected := make(map[string]bool, len(changed))
+	for _, name := range changed {
+		affected[name] = true
+	}
+	graphs := sortedGraphs(clusters)
+	for grown := true; grown; {
+		grown = false
+		for _, g := range graphs {
+			if affected[g.cluster.Name] {
+				continue
+			}
+			for i := range g.Vertex {
+				if v := &g.Vertex[i]; len(v.Graph) > 0 && affected[v.Cluster] {
+					affected[g.cluster.Name] = true
+					grown = true
+					break
+				}
+			}
+		}
+	}
+	return affected
+}
+
+// validateSubGraphRefs check every subgraph vertex refers to a loaded cluster and graph
+func validateSubGraphRefs(clusters map[string]*Cluster) error {
+	var errs []error
+	for _, g := range sortedGraphs(clusters) {
+		for _, v := range g.vertexMap {
+			if len(v.Graph) == 0 {
+				continue
+			}
+			callee, exist := clusters[v.Cluster]
+			if !exist {
+				errs = append(errs, &clusterError{clusters: []string{g.cluster.Name},
+					err: fmt.Errorf("Vertex:%s/%s refers to missing cluster:%s", g.getLabel(), v.ID, v.Cluster)})
+				continue
+			}
+			if callee.getGraph(v.Graph) == nil {
+				errs = append(errs, &clusterError{clusters: []string{g.cluster.Name},
+					err: fmt.Errorf("Vertex:%s/%s refers to missing graph:%s::%s", g.getLabel(), v.ID, v.Cluster, v.Graph)})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateSubGraphCycles check graphs never call themselves through subgraph vertexes
+func validateSubGraphCycles(clusters map[string]*Cluster) error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[*Graph]int)
+	var path []*Graph
+	var visit func(g *Graph) error
+	visit = func(g *Graph) error {
+		state[g] = visiting
+		path = append(path, g)
+		for i := range g.Vertex {
+			for _, callee := range getCallees(clusters, &g.Vertex[i]) {
+				switch state[callee] {
+				case visiting:
+					var labels, names []string
+					for idx := len(path) - 1; idx >= 0; idx-- {
+						labels = append([]string{path[idx].getLabel()}, labels...)
+						names = append(names, path[idx].cluster.Name)
+						if path[idx] == callee {
+							break
+						}
+					}
+					labels = append(labels, callee.getLabel())
+					return &clusterError{clusters: names,
+						err: fmt.Errorf("subgraph call cycle:%s", strings.Join(labels, " -> "))}
+				case visited:
+					continue
+				}
+				if err := visit(callee); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[g] = visited
+		return nil
+	}
+	for _, g := range sortedGraphs(clusters) {
+		if state[g] == 0 {
+			if err := visit(g); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// subGraphScope caller scope overlaid with subgraph vertex args, '$' string args are resolved by caller scope,
+// unresolved ones are kept with the error of the first one
+func subGraphScope(callerScope *param.Params, args param.Params) (*param.Params, error) {
+	scope := callerScope.Clone()
+	if scope == nil {
+		scope = &param.Params{}
+	}
+	var err error
+	for k, arg := range args {
+		if str, ok := arg.(string); ok {
+			resolved, resolveErr := resolveVariable(str, callerScope)
+			if resolveErr != nil && err == nil {
+				err = fmt.Errorf("arg:%s %w", k, resolveErr)
+			}
+			arg = resolved
+		}
+		(*scope)[k] = arg
+	}
+	return scope, err
+}
+
+// staticScope scope of subgraph checked at build time, variables resolved at runtime are kept
+func staticScope(callerScope *param.Params, args param.Params) *param.Params {
+	scope, _ := subGraphScope(callerScope, args)
+	return scope
+}
+
+func unitIDs(data *Unit) []string {
+	if len(data.Aggregate) > 0 {
+		return data.Aggregate
+	}
+	return []string{data.ID}
+}
+
+// collectOutputs collect data ids produced by graph and the subgraphs it calls
+func collectOutputs(clusters map[string]*Cluster, g *Graph, scope *param.Params, outputs map[string]bool) {
+	for i := range g.Vertex {
+		v := &g.Vertex[i]
+		// foreach subgraphs run with child data contexts, only the gathered outputs are visible
+		if callees := getCallees(clusters, v); len(callees) > 0 && v.Foreach == nil {
+			for _, callee := range callees {
+				collectOutputs(clusters, callee, staticScope(scope, v.Params), outputs)
+			}
+			continue
+		}
+		for idx := range v.Output {
+			id, _ := lookupVariable(v.Output[idx].ID, scope)
+			outputs[id] = true
+		}
+	}
+}
+
+// validateExternInputs check extern inputs of every called graph are produced by some caller,
+// graphs executed directly get extern inputs from the data context, they are not checked
+func validateExternInputs(clusters map[string]*Cluster) error {
+	satisfied := make(map[string]bool)
+	missing := make(map[string]error)
+	// checkCallee check extern inputs of callee called by caller g with callers outputs
+	checkCallee := func(g *Graph, callee *Graph, calleeScope *param.Params, callers []map[string]bool) {
+		for j := range callee.Vertex {
+			w := &callee.Vertex[j]
+			for idx := range w.Input {
+				data := &w.Input[idx]
+				if !data.IsExtern || data.Optional {
+					continue
+				}
+				for _, name := range unitIDs(data) {
+					id, ok := lookupVariable(name, calleeScope)
+					if !ok && isVariable(id) {
+						// resolved by scope of execution at runtime
+						continue
+					}
+					key := fmt.Sprintf("%s/%s/%s", callee.getLabel(), w.ID, id)
+					if satisfied[key] {
+						continue
+					}
+					produced := false
+					for _, caller := range callers {
+						if caller[id] {
+							produced = true
+							break
+						}
+					}
+					if produced {
+						satisfied[key] = true
+						delete(missing, key)
+					} else if _, exist := missing[key]; !exist {
+						missing[key] = &clusterError{clusters: []string{callee.cluster.Name, g.cluster.Name},
+							err: fmt.Errorf("Vertex:%s/%s extern input:%s is not produced by caller:%s",
+								callee.getLabel(), w.ID, id, g.getLabel())}
+					}
+				}
+			}
+		}
+	}
+	var walk func(g *Graph, scope *param.Params, callers []map[string]bool)
+	walk = func(g *Graph, scope *param.Params, callers []map[string]bool) {
+		outputs := make(map[string]bool)
+		collectOutputs(clusters, g, scope, outputs)
+		callers = append(callers, outputs)
+		for i := range g.Vertex {
+			v := &g.Vertex[i]
+			for _, callee := range getCallees(clusters, v) {
+				calleeScope := staticScope(scope, v.Params)
+				checkCallee(g, callee, calleeScope, callers)
+				walk(callee, calleeScope, callers)
+			}
+		}
+	}
+	for _, g := range sortedGraphs(clusters) {
+		walk(g, nil, nil)
+	}
+	keys := make([]string, 0, len(missing))
+	for key := range missing {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errs := make([]error, 0, len(keys))
+	for _, key := range keys {
+		errs = append(errs, missing[key])
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+package graph
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"xxxx/dagengine/engine/processor"
+)
+
+func TestManager_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		clusters []string
+		wantErr  string
+	}{
+		{name: "ok",
+			clusters: []string{"subgraph_test.toml", "subgraph_args_test.toml", "subgraph_extern_test.toml"}},
+		{name: "missing_ref",
+			clusters: []string{"subgraph_ref_err_test.toml"},
+			wantErr:  "missing cluster:not_exist.toml"},
+		{name: "missing_graph",
+			clusters: []string{"subgraph_ref_err_test.toml"},
+			wantErr:  "missing graph:subgraph_ref_err_test.toml::not_exist"},
+		{name: "cycle",
+			clusters: []string{"subgraph_cycle_test.toml"},
+			wantErr:  "subgraph call cycle:subgraph_cycle_test.toml::enter -> subgraph_cycle_test.toml::loop -> subgraph_cycle_test.toml::enter"},
+		{name: "extern_not_produced",
+			clusters: []string{"subgraph_extern_err_test.toml"},
+			wantErr:  "extern input:Mid is not produced by caller:subgraph_extern_err_test.toml::enter"},
+	}
+	processor.Register("phase0", func() processor.Processor { return &phase0{} })
+	processor.Register("phase1", func() processor.Processor { return &phase1{} })
+	processor.Register("phase2", func() processor.Processor { return &phase2{} })
+	processor.Register("phase6", func() processor.Processor { return &phase6{} })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New()
+			for _, name := range tt.clusters {
+				if err := m.loadFile("../../cmd/"+name, &TomlCodec{}); err != nil {
+					t.Fatalf("Manager.loadFile() error = %v", err)
+				}
+			}
+			err := m.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Manager.Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Manager.Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_MaxSubGraphDepth(t *testing.T) {
+	m := New()
+	if err := m.loadFile("../../cmd/subgraph_cycle_test.toml", &TomlCodec{}); err != nil {
+		t.Fatalf("Manager.loadFile() error = %v", err)
+	}
+	m.SetMaxSubGraphDepth(3)
+	result, err := m.execute(context.Background(), "subgraph_cycle_test.toml", "enter", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Manager.execute() error = %v", err)
+	}
+	levels := 0
+	for result != nil {
+		levels++
+		var vr *VertexResult
+		for _, r := range result.Vertexes {
+			vr = r
+		}
+		if vr.SubGraph == nil && (vr.Err == nil || !strings.Contains(vr.Err.Error(), "nesting depth exceeds 3")) {
+			t.Errorf("Manager.execute() innermost vertex err = %v", vr.Err)
+		}
+		result = vr.SubGraph
+	}
+	if levels != 4 {
+		t.Errorf("Manager.execute() nested levels = %v, want 4", levels)
+	}
+}
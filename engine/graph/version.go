@@ -0,0 +1,116 @@
+package graph
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+
+	"xxxx/dagengine/engine/param"
+)
+
+// graphVersions all versions of one graph name
+type graphVersions struct {
+	// graphs sorted by priority desc, declaration order for equal priority
+	graphs      []*Graph
+	splitKey    string
+	totalWeight int
+}
+
+func (gv *graphVersions) build() error {
+	sort.SliceStable(gv.graphs, func(i, j int) bool {
+		return gv.graphs[i].Priority > gv.graphs[j].Priority
+	})
+	for _, g := range gv.graphs {
+		if g.Weight == 0 {
+			continue
+		}
+		if len(g.VersionCond) > 0 {
+			return fmt.Errorf("Graph:%s version:%s can NOT set both weight and version_cond", g.Name, g.ExpectVersion)
+		}
+		gv.totalWeight += g.Weight
+		if len(g.SplitKey) == 0 {
+			continue
+		}
+		if len(gv.splitKey) > 0 && gv.splitKey != g.SplitKey {
+			return fmt.Errorf("Graph:%s versions have different split_key:%s,%s", g.Name, gv.splitKey, g.SplitKey)
+		}
+		gv.splitKey = g.SplitKey
+	}
+	return nil
+}
+
+// getDefault highest priority version without version_cond
+func (gv *graphVersions) getDefault() *Graph {
+	for _, g := range gv.graphs {
+		if g.versionProgram == nil {
+			return g
+		}
+	}
+	return gv.graphs[0]
+}
+
+func (gv *graphVersions) getVersion(version string) *Graph {
+	for _, g := range gv.graphs {
+		if g.ExpectVersion == version {
+			return g
+		}
+	}
+	return nil
+}
+
+// splitTraffic choose version by weight, the same split key value always gets the same version
+func (gv *graphVersions) splitTraffic(params *param.Params) *Graph {
+	if gv.totalWeight == 0 || len(gv.splitKey) == 0 || params == nil {
+		return nil
+	}
+	key, ok := (*params)[gv.splitKey]
+	if !ok {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(gv.graphs[0].Name + "/" + fmt.Sprint(key)))
+	point := int(h.Sum32() % uint32(gv.totalWeight))
+	for _, g := range gv.graphs {
+		if g.Weight == 0 {
+			continue
+		}
+		if point < g.Weight {
+			return g
+		}
+		point -= g.Weight
+	}
+	return nil
+}
+
+// selectGraph choose graph version for one request: explicit version first,
+// then the first true version_cond by priority, then weighted split, then default
+func (c *Cluster) selectGraph(name string, version string, params *param.Params) (*Graph, error) {
+	gv, ok := c.versions[name]
+	if !ok {
+		return nil, fmt.Errorf("not find graph:%v", name)
+	}
+	if len(version) > 0 {
+		if g := gv.getVersion(version); g != nil {
+			return g, nil
+		}
+		return nil, fmt.Errorf("not find graph:%v version:%v", name, version)
+	}
+	if params != nil {
+		for _, g := range gv.graphs {
+			if g.versionProgram == nil {
+				continue
+			}
+			output, err := runExpr(g.versionProgram, params)
+			if err != nil {
+				log.Printf("graph:%v version:%v version_cond:%v err:%v", name, g.ExpectVersion, g.VersionCond, err)
+			} else if match, ok := output.(bool); ok && match {
+				return g, nil
+			}
+		}
+	}
+	if g := gv.splitTraffic(params); g != nil {
+		return g, nil
+	}
+	return gv.getDefault(), nil
+}
@@ -2,11 +2,15 @@ package graph
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
+	"time"
 
 	"xxxx/dagengine/engine/param"
 	"xxxx/dagengine/engine/processor"
 	"xxxx/innererror"
+
+	"github.com/antonmedv/expr/vm"
 )
 
 // CondParams condition param
@@ -15,6 +19,23 @@ type CondParams struct {
 	Args  param.Params `toml:"args"`
 }
 
+// RetryPolicy retry policy for processor vertex
+type RetryPolicy struct {
+	Max     int     `toml:"max" json:"max"`
+	Backoff string  `toml:"backoff" json:"backoff"`
+	OnCodes []int32 `toml:"on_codes" json:"on_codes"`
+}
+
+// ForeachPolicy run vertex once per element of a slice data, per element outputs are gathered
+type ForeachPolicy struct {
+	Input       string `toml:"input" json:"input"`
+	Concurrency int    `toml:"concurrency" json:"concurrency"`
+	FailPolicy  string `toml:"fail_policy" json:"fail_policy"`
+	Gather      string `toml:"gather" json:"gather"`
+	// Key field of struct elements keying the gathered map, the element itself if empty
+	Key string `toml:"key" json:"key"`
+}
+
 // Unit min unit for vertex input/output
 type Unit struct {
 	ID         string   `toml:"id" json:"id"`
@@ -25,8 +46,12 @@ type Unit struct {
 	Optional   bool     `toml:"optional" json:"optional"`
 	Move       bool     `toml:"move" json:"move"`
 	IsExtern   bool     `toml:"extern" json:"extern"`
+	Result     bool     `toml:"result" json:"result"`
 	IsInOut    bool
 	IsMapInput bool
+
+	program    *vm.Program
+	isImplicit bool
 }
 
 // Vertex vertex detail struct
@@ -34,6 +59,9 @@ type Vertex struct {
 	ID           string       `toml:"id" json:"id"`
 	Processor    string       `toml:"processor" json:"processor"`
 	Cond         string       `toml:"cond" json:"cond"`
+	Switch       string       `toml:"switch" json:"switch"`
+	Cases        []SwitchCase `toml:"cases" json:"cases"`
+	Default      []string     `toml:"default" json:"default"`
 	Expect       string       `toml:"expect" json:"expect"`
 	ExpectConfig string       `toml:"expect_config" json:"expect_config"`
 	SelectArgs   []CondParams `toml:"select_args" json:"select_args"`
@@ -52,21 +80,57 @@ type Vertex struct {
 	Output []Unit `toml:"output" json:"output"`
 	Start  bool   `toml:"start" json:"start"`
 
+	Critical bool   `toml:"critical" json:"critical"`
+	Timeout  string `toml:"timeout" json:"timeout"`
+
+	Retry        *RetryPolicy `toml:"retry" json:"retry"`
+	Fallback     string       `toml:"fallback" json:"fallback"`
+	FallbackArgs param.Params `toml:"fallback_args" json:"fallback_args"`
+
+	Foreach *ForeachPolicy `toml:"foreach" json:"foreach"`
+	Join    *JoinPolicy    `toml:"join" json:"join"`
+
 	successorVertex map[string]*Vertex
 	depsResults     map[string]int
 	isIDGenerated   bool
 	isGenerated     bool
-	g               *Graph
+	timeout         time.Duration
+	retryBackoff    time.Duration
+	// spares initialized processors replacing ones abandoned by timeout
+	spares         *processorSpares
+	fallbackVertex *Vertex
+	isFallback     bool
+	expectProgram  *vm.Program
+	condProgram    *vm.Program
+	switchProgram  *vm.Program
+	switchBranches map[string]map[int]bool
+	foreachType    reflect.Type
+	foreachKeyType reflect.Type
+	joinCancelable bool
+	index          int
+	g              *Graph
 }
 
-func (v *Vertex) dumpDotDefine(s *strings.Builder) {
+func (v *Vertex) dumpDotDefine(s *strings.Builder, r *dotResult) {
 	s.WriteString("    ")
 	s.WriteString(v.getDotID())
 	s.WriteString(" [label=\"")
+	if v.Foreach != nil {
+		s.WriteString("foreach " + v.Foreach.Input + "\\n")
+	}
 	s.WriteString(v.getDotLabel())
+	if r != nil {
+		s.WriteString(r.getVertexLabel(v))
+		s.WriteString("\"")
+		s.WriteString(r.getVertexStyle(v))
+		s.WriteString("];\n")
+		return
+	}
 	s.WriteString("\"")
-	if len(v.Cond) > 0 {
+	if len(v.Cond) > 0 || len(v.Switch) > 0 {
 		s.WriteString(" shape=diamond color=black fillcolor=aquamarine style=filled")
+	} else if v.Foreach != nil {
+		s.WriteString(" shape=folder color=black fillcolor=khaki style=filled peripheries=2")
 	} else if len(v.Graph) > 0 {
 		s.WriteString(" shape=box3d, color=blue fillcolor=aquamarine style=filled")
 	} else {
@@ -75,27 +139,34 @@ func (v *Vertex) dumpDotDefine(s *strings.Builder) {
 	s.WriteString("];\n")
 }
 
-func (v *Vertex) dumpDotEdge(s *strings.Builder) {
+func (v *Vertex) dumpDotEdge(s *strings.Builder, r *dotResult) {
 	if len(v.ExpectConfig) > 0 {
-		expectConfigID := v.g.Name + "_" + v.ExpectConfig
-		expectConfigID = strings.ReplaceAll(expectConfigID, "!", "")
+		name, flag, negate := parseSettingRef(v.ExpectConfig)
+		expectConfigID := v.g.getDotName() + "_" + name
+		label := "ok"
+		if negate {
+			label = "err"
+		}
+		if len(flag) > 0 {
+			label = flag + " " + label
+		}
 		s.WriteString("    ")
 		s.WriteString(expectConfigID)
 		s.WriteString(" -> ")
 		s.WriteString(v.getDotID())
-		if v.ExpectConfig[0] == '!' {
-			s.WriteString(" [style=dashed color=red label=\"err\"];\n")
+		if negate {
+			s.WriteString(" [style=dashed color=red label=\"" + label + "\"];\n")
 		} else {
-			s.WriteString(" [style=bold label=\"ok\"];\n")
+			s.WriteString(" [style=bold label=\"" + label + "\"];\n")
 		}
 
 		s.WriteString("    ")
-		s.WriteString(v.g.Name + "__START__")
+		s.WriteString(v.g.getDotName() + "__START__")
 		s.WriteString(" -> ")
 		s.WriteString(expectConfigID + ";\n")
 	}
 	if len(v.Expect) > 0 {
-		expect := v.g.Name + "_" + v.Expect
+		expect := v.g.getDotName() + "_" + v.Expect
 		expect = strings.ReplaceAll(expect, "\"", "")
 		expect = strings.ReplaceAll(expect, "'", "")
 		expect = strings.ReplaceAll(expect, "[", "")
@@ -125,24 +196,44 @@ func (v *Vertex) dumpDotEdge(s *strings.Builder) {
 		}
 
 		s.WriteString("    ")
-		s.WriteString(v.g.Name + "__START__")
+		s.WriteString(v.g.getDotName() + "__START__")
 		s.WriteString(" -> ")
 		s.WriteString(expect + ";\n")
 	}
+	if v.fallbackVertex != nil {
+		s.WriteString("    " + v.getDotID() + " -> " + v.fallbackVertex.getDotID() +
+			" [style=dashed color=orange label=\"fallback\"];\n")
+	}
+	if v.isFallback {
+		return
+	}
 	if v.isSuccessorsEmpty() {
-		s.WriteString("    " + v.getDotID() + " -> " + v.g.Name + "__STOP__;\n")
+		s.WriteString("    " + v.getDotID() + " -> " + v.g.getDotName() + "__STOP__")
+		s.WriteString(r.getTerminalStyle(v, true) + ";\n")
+	}
+	if len(v.Switch) > 0 {
+		v.dumpSwitchEdge(s, r)
 	}
 	if v.isDepsEmpty() {
-		s.WriteString("    " + v.g.Name + "__START__ -> " + v.getDotID() + ";\n")
+		s.WriteString("    " + v.g.getDotName() + "__START__ -> " + v.getDotID())
+		s.WriteString(r.getTerminalStyle(v, false) + ";\n")
 	}
-	v.dumpDepsResult(s)
+	v.dumpDepsResult(s, r)
 }
 
-func (v *Vertex) dumpDepsResult(s *strings.Builder) {
+func (v *Vertex) dumpDepsResult(s *strings.Builder, r *dotResult) {
 	if v.depsResults != nil && len(v.depsResults) > 0 {
 		for id, expect := range v.depsResults {
 			dep := v.g.getVertexByID(id)
+			if _, ok := v.switchBranches[id]; ok {
+				// drawn with case labels by the switch vertex
+				continue
+			}
 			s.WriteString("    " + dep.getDotID() + " -> " + v.getDotID())
+			if r != nil {
+				s.WriteString(r.getEdgeStyle(dep, v, expect) + ";\n")
+				continue
+			}
 			switch expect {
 			case innererror.VResultOk:
 				s.WriteString(" [style=dashed label=\"ok\"];\n")
@@ -194,12 +285,15 @@ func (v *Vertex) verify() error {
 }
 
 func (v *Vertex) getDotID() string {
-	return v.g.Name + "_" + v.ID
+	return v.g.getDotName() + "_" + v.ID
 }
 func (v *Vertex) getDotLabel() string {
 	if len(v.Cond) > 0 {
 		return strings.ReplaceAll(v.Cond, "\"", "\\\"")
 	}
+	if len(v.Switch) > 0 {
+		return "switch " + strings.ReplaceAll(v.Switch, "\"", "\\\"")
+	}
 	if len(v.Processor) > 0 {
 		if !v.isIDGenerated {
 			return v.ID
@@ -214,7 +308,10 @@ func (v *Vertex) getDotLabel() string {
 
 func (v *Vertex) buildInputOutput() error {
 	meta := v.g.cluster.getOpMeta(v.Processor)
-	if meta == nil && v.Cluster == "" && v.Cond == "" {
+	if meta == nil && v.Cluster == "" && v.Cond == "" && v.Switch == "" {
+		if len(v.Processor) > 0 {
+			return fmt.Errorf("Vertex:%s/%s processor:%s not found", v.g.Name, v.ID, v.Processor)
+		}
 		return fmt.Errorf("ID:%v No Processor found", v.ID)
 	}
 	if meta == nil {
@@ -241,6 +338,9 @@ func (v *Vertex) buildDeps(deps []string, expectedResult int) error {
 		if dep == nil {
 			return fmt.Errorf("[%s/%s]No dep vertex id:%s", v.g.Name, v.getDotLabel(), id)
 		}
+		if dep.isFallback {
+			return fmt.Errorf("[%s/%s]Can NOT depend on fallback vertex id:%s", v.g.Name, v.getDotLabel(), id)
+		}
 		v.depend(dep, expectedResult)
 	}
 	return nil
@@ -252,36 +352,142 @@ func (v *Vertex) buildSuccessor(sucessors []string, expectedResult int) error {
 		if successor == nil {
 			return fmt.Errorf("[%s]No successor id:%s", v.getDotLabel(), id)
 		}
+		if successor.isFallback {
+			return fmt.Errorf("[%s]Can NOT use fallback vertex id:%s as successor", v.getDotLabel(), id)
+		}
 		successor.depend(v, expectedResult)
 	}
 	return nil
 }
 
-func (v *Vertex) buildInputDeps(dep *Vertex, data Unit) error {
+func (v *Vertex) buildInputDeps(dep *Vertex, data Unit, id string) error {
 	if dep == nil {
 		if !data.IsExtern && !data.Optional {
 			return fmt.Errorf("[%s/%s]No dep input id:%s", v.g.Name, v.getDotLabel(), data.ID)
 		}
 		return nil
 	}
+	if err := v.checkInputType(dep, data, id); err != nil {
+		return err
+	}
 	v.depend(dep, innererror.VResultAll)
 	return nil
 }
 
+// getFieldType go type of processor input or output field, nil if unknown
+func (v *Vertex) getFieldType(field string, isOutput bool) reflect.Type {
+	meta := v.g.cluster.getOpMeta(v.Processor)
+	if meta == nil {
+		return nil
+	}
+	fields := meta.Input
+	if isOutput {
+		fields = meta.Output
+	}
+	for _, f := range fields {
+		if f.Name == field {
+			if isOutput && v.Foreach != nil && f.Type != nil {
+				// per element outputs are gathered
+				return v.Foreach.gatherType(v.foreachKeyType, f.Type)
+			}
+			return f.Type
+		}
+	}
+	return nil
+}
+
+func (v *Vertex) getOutputField(id string) string {
+	for _, output := range v.Output {
+		if output.ID == id {
+			return output.Field
+		}
+	}
+	return ""
+}
+
+// checkInputType check output data id of dep is injectable to input data,
+// data context keys are typed, a mismatched type injects nothing at runtime
+func (v *Vertex) checkInputType(dep *Vertex, data Unit, id string) error {
+	inType := v.getFieldType(data.Field, false)
+	outField := dep.getOutputField(id)
+	outType := dep.getFieldType(outField, true)
+	if inType == nil || outType == nil {
+		return nil
+	}
+	if len(data.Aggregate) > 0 || data.IsMapInput {
+		if inType.Kind() != reflect.Map {
+			return fmt.Errorf("Vertex:%s/%s aggregate input:%s type:%v is not a map",
+				v.g.Name, v.ID, data.Field, inType)
+		}
+		inType = inType.Elem()
+	}
+	if GetNoPtrType(inType) != GetNoPtrType(outType) {
+		return fmt.Errorf("Vertex:%s/%s input:%s type:%v mismatch output:%s type:%v of vertex:%s/%s data id:%s",
+			v.g.Name, v.ID, data.Field, inType, outField, outType, dep.g.Name, dep.ID, id)
+	}
+	return nil
+}
+
+// bindFallbackOutput publish fallback outputs with the data ids of primary vertex
+func (v *Vertex) bindFallbackOutput(primary *Vertex) error {
+	for idx := range v.Output {
+		data := &v.Output[idx]
+		match := false
+		for _, output := range primary.Output {
+			if output.Field == data.Field {
+				data.ID = output.ID
+				match = true
+				break
+			}
+		}
+		if !match {
+			return fmt.Errorf("Vertex:%s/%s fallback output field:%s not found in vertex:%s",
+				v.g.Name, v.ID, data.Field, primary.ID)
+		}
+		outType := v.getFieldType(data.Field, true)
+		primaryType := primary.getFieldType(data.Field, true)
+		if outType != nil && primaryType != nil && GetNoPtrType(outType) != GetNoPtrType(primaryType) {
+			return fmt.Errorf("Vertex:%s/%s fallback output:%s type:%v mismatch type:%v of vertex:%s",
+				v.g.Name, v.ID, data.Field, outType, primaryType, primary.ID)
+		}
+	}
+	return nil
+}
+
+func (v *Vertex) shouldRetry(attempt int, err error) bool {
+	if v.Retry == nil || attempt >= v.Retry.Max {
+		return false
+	}
+	if len(v.Retry.OnCodes) == 0 {
+		return true
+	}
+	code := innererror.Code(err)
+	for _, c := range v.Retry.OnCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
 func (v *Vertex) buildDataDeps() error {
-	for _, data := range v.Input {
+	return v.buildInputDataDeps(v.Input)
+}
+
+func (v *Vertex) buildInputDataDeps(inputs []Unit) error {
+	for _, data := range inputs {
 		if len(data.Aggregate) == 0 && !data.IsMapInput {
 			dep := v.g.getVertexByData(data.ID)
 			if data.IsInOut && dep == v {
 				continue
 			}
-			if err := v.buildInputDeps(dep, data); err != nil {
+			if err := v.buildInputDeps(dep, data, data.ID); err != nil {
 				return err
 			}
 		} else {
 			for _, id := range data.Aggregate {
 				dep := v.g.getVertexByData(id)
-				if err := v.buildInputDeps(dep, data); err != nil {
+				if err := v.buildInputDeps(dep, data, id); err != nil {
 					return err
 				}
 			}
@@ -290,12 +496,101 @@ func (v *Vertex) buildDataDeps() error {
 	return nil
 }
 
+func (v *Vertex) buildExpr() error {
+	if len(v.Expect) > 0 {
+		program, err := compileExpr(v.Expect)
+		if err != nil {
+			return fmt.Errorf("Vertex:%s/%s invalid expect:%v", v.g.Name, v.ID, err)
+		}
+		v.expectProgram = program
+	}
+	if len(v.Cond) > 0 {
+		program, err := compileExpr(v.Cond)
+		if err != nil {
+			return fmt.Errorf("Vertex:%s/%s invalid cond:%v", v.g.Name, v.ID, err)
+		}
+		v.condProgram = program
+	}
+	for idx := range v.Output {
+		data := &v.Output[idx]
+		if len(data.Cond) == 0 {
+			continue
+		}
+		program, err := compileExpr(data.Cond)
+		if err != nil {
+			return fmt.Errorf("Vertex:%s/%s invalid output:%s cond:%v", v.g.Name, v.ID, data.Field, err)
+		}
+		data.program = program
+	}
+	return nil
+}
+
+// checkUnitAttrs check required/optional/move/cond attributes of input and output
+func (v *Vertex) checkUnitAttrs() error {
+	for _, data := range v.Input {
+		if data.Required && data.Optional {
+			return fmt.Errorf("Vertex:%s/%s input:%s can NOT be both required and optional", v.g.Name, v.ID, data.Field)
+		}
+		if len(data.Cond) > 0 {
+			return fmt.Errorf("Vertex:%s/%s input:%s cond is only supported on output", v.g.Name, v.ID, data.Field)
+		}
+	}
+	for _, data := range v.Output {
+		if data.Required || data.Move {
+			return fmt.Errorf("Vertex:%s/%s output:%s required/move is only supported on input",
+				v.g.Name, v.ID, data.Field)
+		}
+	}
+	return nil
+}
+
 func (v *Vertex) build() error {
+	if err := v.checkUnitAttrs(); err != nil {
+		return err
+	}
+	v.spares = new(processorSpares)
+	if err := v.buildExpr(); err != nil {
+		return err
+	}
+	if len(v.Timeout) > 0 {
+		timeout, err := time.ParseDuration(v.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("Vertex:%s/%s invalid timeout:%s", v.g.Name, v.getDotLabel(), v.Timeout)
+		}
+		v.timeout = timeout
+	}
+	if v.Retry != nil {
+		if v.Retry.Max < 0 {
+			return fmt.Errorf("Vertex:%s/%s invalid retry max:%d", v.g.Name, v.getDotLabel(), v.Retry.Max)
+		}
+		if len(v.Retry.Backoff) > 0 {
+			backoff, err := time.ParseDuration(v.Retry.Backoff)
+			if err != nil || backoff < 0 {
+				return fmt.Errorf("Vertex:%s/%s invalid retry backoff:%s", v.g.Name, v.getDotLabel(), v.Retry.Backoff)
+			}
+			v.retryBackoff = backoff
+		}
+	}
 	for _, cond := range v.SelectArgs {
 		if !v.g.cluster.ContainsConfigSetting(cond.Match) {
 			return fmt.Errorf("No config_setting with name:%s defined", cond.Match)
 		}
 	}
+	if v.isFallback {
+		return nil
+	}
+	if err := v.buildForeach(); err != nil {
+		return err
+	}
+	if err := v.buildSwitch(); err != nil {
+		return err
+	}
+	if v.fallbackVertex != nil {
+		// fallback inputs must be ready before the primary vertex runs
+		if err := v.buildInputDataDeps(v.fallbackVertex.Input); err != nil {
+			return err
+		}
+	}
 	if err := v.buildDataDeps(); err != nil {
 		return err
 	}
@@ -329,6 +624,14 @@ func (v *Vertex) checkAndFitUnit(units []Unit) error {
 		if len(data.ID) == 0 {
 			data.ID = data.Field
 		}
+		if data.ID == "$" {
+			return fmt.Errorf("Empty variable name for data field:%s node:%s", data.Field, v.ID)
+		}
+		for _, agg := range data.Aggregate {
+			if agg == "$" {
+				return fmt.Errorf("Empty variable name for data field:%s node:%s", data.Field, v.ID)
+			}
+		}
 	}
 	return nil
 }
@@ -349,6 +652,7 @@ func (v *Vertex) buildInput(meta *processor.OperatorMeta) {
 				IsExtern:   opInput.Flags.Extern > 0,
 				IsInOut:    opInput.Flags.InOut > 0,
 				IsMapInput: opInput.Flags.Aggregate > 0,
+				isImplicit: true,
 			}
 			v.Input = append(v.Input, field)
 		}
@@ -366,8 +670,9 @@ func (v *Vertex) buildOutput(meta *processor.OperatorMeta) {
 		}
 		if !match {
 			field := Unit{
-				ID:    opOutput.Name,
-				Field: opOutput.Name,
+				ID:         opOutput.Name,
+				Field:      opOutput.Name,
+				isImplicit: true,
 			}
 			v.Output = append(v.Output, field)
 		}
@@ -2,8 +2,10 @@ package graph
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,13 +13,25 @@ import (
 	"xxxx/dagengine/engine/param"
 	"xxxx/dagengine/engine/processor"
 	"xxxx/innererror"
-
-	"github.com/antonmedv/expr"
+	"xxxx/util/safe"
 )
 
 type vertexResult struct {
 	conditionResult error
 	processorResult error
+	status          VertexStatus
+	duration        time.Duration
+	subGraphResult  *ExecuteResult
+	attempts        int
+	fallback        bool
+	elements        int
+	failedElements  int
+	droppedElements []int
+	// branch taken by switch vertex
+	branch int
+	// readyAt time all dependencies reported, queueWait time from ready to start
+	readyAt   time.Time
+	queueWait time.Duration
 }
 
 // VertexContext vertex context
@@ -27,15 +41,36 @@ type VertexContext struct {
 	Processor        processor.Processor
 	ProcessorDI      *ProcessorDI
 	Params           *param.Params
+	fallback         *VertexContext
 	vertexDepResults sync.Map
 	result           *vertexResult
 	waitNum          int32
+	// workers processor contexts of foreach elements
+	workers []*VertexContext
+	// dataContext data context of the foreach element being executed by a worker
+	dataContext *DataContext
+	// joined partial join vertex already scheduled
+	joined          int32
+	cancelLock      sync.Mutex
+	cancel          context.CancelFunc
+	cancelledByJoin bool
+	// tracedInputs injected inputs recorded by trace
+	tracedInputs []*TraceData
+	// abandonable processor is abandoned once its ctx is done, by vertex timeout or join cancel_rest
+	// of its own vertex or of the vertex it is fallback of
+	abandonable bool
 }
 
 // Reset reset inner var
 func (v *VertexContext) Reset() {
 	v.waitNum = int32(len(v.Vertex.depsResults))
 	v.result = new(vertexResult)
+	v.joined = 0
+	v.tracedInputs = nil
+	v.cancelLock.Lock()
+	v.cancel = nil
+	v.cancelledByJoin = false
+	v.cancelLock.Unlock()
 	v.vertexDepResults.Range(func(key interface{}, value interface{}) bool {
 		v.vertexDepResults.Delete(key)
 		return true
@@ -45,53 +80,78 @@ func (v *VertexContext) Reset() {
 // NewVertexContext new vertex context by graph context and vertex
 func NewVertexContext(g *Context, v *Vertex) (*VertexContext, error) {
 	vc := &VertexContext{GraphContext: g,
-		Vertex: v,
-		Params: &v.Params,
-	}
-	if vc.Vertex.Graph == "" && vc.Vertex.Processor != "" {
-		vc.Processor = processor.Get(vc.Vertex.Processor)
+		Vertex:      v,
+		Params:      &v.Params,
+		abandonable: v.timeout > 0 || v.joinCancelable,
 	}
-	if vc.Processor == nil && vc.Vertex.Processor != "" {
-		return nil, fmt.Errorf("processor name:%v not find", vc.Vertex.Processor)
+	if err := vc.initProcessor(); err != nil {
+		return nil, err
 	}
-	if vc.Processor != nil {
-		vc.ProcessorDI = &ProcessorDI{Processor: vc.Processor}
-		if err := vc.ProcessorDI.PrepareInput(vc.Vertex.Input); err != nil {
-			return nil, err
-		}
-		if err := vc.ProcessorDI.PrepareOutput(vc.Vertex.Output); err != nil {
+	if v.Foreach != nil && vc.Processor != nil {
+		if err := vc.newForeachWorkers(); err != nil {
 			return nil, err
 		}
 	}
-	if vc.Processor != nil {
-		vc.Processor.OnInit()
+	if v.fallbackVertex != nil {
+		fallback, err := NewVertexContext(g, v.fallbackVertex)
+		if err != nil {
+			return nil, fmt.Errorf("new fallback vertex:%v context err:%w", v.fallbackVertex.getDotLabel(), err)
+		}
+		fallback.abandonable = fallback.abandonable || vc.abandonable
+		vc.fallback = fallback
 	}
 	vc.waitNum = int32(len(vc.Vertex.depsResults))
 	vc.result = new(vertexResult)
 	return vc, nil
 }
 
+func (v *VertexContext) initProcessor() error {
+	if v.Vertex.Graph != "" || v.Vertex.Processor == "" {
+		return nil
+	}
+	p := processor.Get(v.Vertex.Processor)
+	if p == nil {
+		return fmt.Errorf("processor name:%v not find", v.Vertex.Processor)
+	}
+	di := &ProcessorDI{Processor: p}
+	if err := di.PrepareInput(v.Vertex.Input); err != nil {
+		return err
+	}
+	if err := di.PrepareOutput(v.Vertex.Output); err != nil {
+		return err
+	}
+	p.OnInit()
+	v.Processor = p
+	v.ProcessorDI = di
+	return nil
+}
+
+// waiting vertex waits for tasks of other vertexes, subgraph calls and foreach vertexes
+func (v *VertexContext) waiting() bool {
+	return v.Vertex.Foreach != nil || (v.Processor == nil && v.Vertex.Cluster != "")
+}
+
 // Ready check vertex ready to run
 func (v *VertexContext) Ready() bool {
 	return atomic.LoadInt32(&v.waitNum) == 0
 }
 
 func (v *VertexContext) checkConditionResult() error {
+	if v.Vertex.Join.isPartial() {
+		return v.checkJoinResult()
+	}
+	if v.Vertex.Join != nil && v.Vertex.Join.Mode == JoinAllSettled {
+		// run whatever dependencies returned
+		return nil
+	}
 	for id, expectR := range v.Vertex.depsResults {
 		r, ok := v.vertexDepResults.Load(id)
 		if !ok {
 			return innererror.Errorf(innererror.VResultErr, "depend vertex:%v not find result", id)
 		}
 		vr, _ := r.(*vertexResult)
-		err, ok := innererror.Upgrade(vr.conditionResult)
-		if !ok {
-			if expectR == innererror.VResultErr {
-				return innererror.Errorf(innererror.VResultErr, "expect error but not return err")
-			}
-			continue
-		}
-		if (err.Code() & int32(expectR)) == 0 {
-			return innererror.Errorf(innererror.VResultErr, "expect not match code:%v expect:%v", err.Code(), expectR)
+		if err := checkDepResult(expectR, vr); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -112,6 +172,8 @@ func (v *VertexContext) addDepProcessorResult(inputP *param.Params) *param.Param
 		vr, _ := r.(*vertexResult)
 		code := innererror.Code(vr.processorResult)
 		executeParams.SetInt64("RET_CODE_"+id, int64(code))
+		executeParams.SetInt64("RET_ATTEMPTS_"+id, int64(vr.attempts))
+		executeParams.SetBool("RET_FALLBACK_"+id, vr.fallback)
 	}
 	return executeParams
 }
@@ -121,7 +183,7 @@ func (v *VertexContext) evalExpect() error {
 		return nil
 	}
 	executeParams := v.addDepProcessorResult(v.GraphContext.ClusterContext.ExecuteParams)
-	output, err := expr.Eval(v.Vertex.Expect, *executeParams)
+	output, err := runExpr(v.Vertex.expectProgram, executeParams)
 	if err != nil {
 		log.Printf("expr name:%v expect:%v err:%v", v.Vertex.Expect, output, err)
 	} else if expect, ok := output.(bool); ok && !expect {
@@ -138,7 +200,7 @@ func (v *VertexContext) evalCond() error {
 		return innererror.Errorf(innererror.VResultErr,
 			"expect:%v no execute param skip vertex:%s", v.Vertex.Expect, v.Vertex.ID)
 	}
-	output, err := expr.Eval(v.Vertex.Cond, *v.GraphContext.ClusterContext.ExecuteParams)
+	output, err := runExpr(v.Vertex.condProgram, v.GraphContext.ClusterContext.ExecuteParams)
 	if err != nil {
 		log.Printf("expr name:%v cond:%v err:%v", v.Vertex.Cond, output, err)
 	} else if expect, ok := output.(bool); ok && !expect {
@@ -147,9 +209,9 @@ func (v *VertexContext) evalCond() error {
 	return nil
 }
 
-func (v *VertexContext) evalExpectConfig() error {
+func (v *VertexContext) evalExpectConfig(ctx context.Context) error {
 	if len(v.Vertex.ExpectConfig) > 0 {
-		expect := v.GraphContext.ExternDataContext.GetConfigSetting(v.Vertex.ExpectConfig)
+		expect := v.GraphContext.ClusterContext.evalConfigSetting(ctx, v.Vertex.ExpectConfig)
 		if !expect {
 			return innererror.Errorf(innererror.VResultErr, "expect config:%v skip vertex", v.Vertex.ExpectConfig)
 		}
@@ -157,24 +219,73 @@ func (v *VertexContext) evalExpectConfig() error {
 	return nil
 }
 
-func (v *VertexContext) conditionCheck() error {
+func (v *VertexContext) conditionCheck(ctx context.Context) error {
 	if err := v.checkConditionResult(); err != nil {
+		v.result.status = VertexSkippedByDeps
+		return err
+	}
+	if err := v.checkSwitchBranch(); err != nil {
+		v.result.status = VertexSkippedByDeps
 		return err
 	}
-	if err := v.evalExpectConfig(); err != nil {
+	if err := v.evalExpectConfig(ctx); err != nil {
+		v.result.status = VertexSkippedByExpectConfig
 		return err
 	}
 	if err := v.evalExpect(); err != nil {
+		v.result.status = VertexSkippedByExpect
 		return err
 	}
 	if err := v.evalCond(); err != nil {
+		v.result.status = VertexSkippedByCond
 		return err
 	}
+	if err := v.checkRequiredInput(); err != nil {
+		v.result.status = VertexSkippedByRequired
+		v.result.processorResult = innererror.Errorf(ResultCodeRequiredMissing, "vertex:%s %v", v.Vertex.ID, err)
+		return innererror.Errorf(innererror.VResultErr, "vertex:%s skipped err:%v", v.Vertex.ID, err)
+	}
 	return nil
 }
 
+func (v *VertexContext) checkRequiredInput() error {
+	if v.ProcessorDI == nil {
+		return nil
+	}
+	return v.ProcessorDI.CheckRequiredInput(v.GraphContext.ExternDataContext, v.Vertex.Input)
+}
+
+// outputFilter evaluate output cond with execute params and RET_CODE of the vertex,
+// nil if no output has cond
+func (v *VertexContext) outputFilter(outputs []Unit) func(field string) bool {
+	conds := make(map[string]*Unit)
+	for i := range outputs {
+		if outputs[i].program != nil {
+			conds[outputs[i].Field] = &outputs[i]
+		}
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+	executeParams := v.addDepProcessorResult(v.GraphContext.ClusterContext.ExecuteParams)
+	executeParams.SetInt64("RET_CODE", int64(innererror.Code(v.result.processorResult)))
+	return func(field string) bool {
+		data, ok := conds[field]
+		if !ok {
+			return true
+		}
+		output, err := runExpr(data.program, executeParams)
+		if err != nil {
+			log.Printf("expr output:%v cond:%v err:%v", data.ID, data.Cond, err)
+			return false
+		}
+		publish, ok := output.(bool)
+		return ok && publish
+	}
+}
+
 func (v *VertexContext) paramCheck() error {
-	if v.Vertex.Cluster == "" && v.Processor == nil && v.Vertex.Cond == "" {
+	if v.Vertex.Cluster == "" && v.Processor == nil && v.Vertex.Cond == "" && v.Vertex.Switch == "" {
 		return fmt.Errorf("Vertex:%v has empty processor and empty subgraph context",
 			v.Vertex.getDotLabel())
 	}
@@ -182,55 +293,364 @@ func (v *VertexContext) paramCheck() error {
 }
 
 // Execute execute one vertex
-func (v *VertexContext) Execute(ctx context.Context) error {
+func (v *VertexContext) Execute(ctx context.Context) (err error) {
+	defer v.traceVertex()
+	defer v.emitVertexEvent()
+	if t := v.GraphContext.getTracer(); t != nil {
+		var span Span
+		ctx, span = v.startVertexSpan(ctx, t)
+		defer v.endVertexSpan(span)
+	}
+	if !v.result.readyAt.IsZero() {
+		v.result.queueWait = time.Since(v.result.readyAt)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			v.result.status = VertexPanic
+			v.result.processorResult = fmt.Errorf("vertex:%v panic:%v", v.Vertex.getDotLabel(), r)
+			err = v.result.processorResult
+		}
+	}()
+	if v.Vertex.joinCancelable {
+		var cancel context.CancelFunc
+		ctx, cancel = v.withJoinCancel(ctx)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		v.result.status = VertexSkippedByCancel
+		v.result.conditionResult = innererror.Errorf(innererror.VResultErr,
+			"vertex:%s skipped err:%v", v.Vertex.ID, err)
+		return v.result.conditionResult
+	}
 	if err := v.paramCheck(); err != nil {
+		v.result.status = VertexErr
+		v.result.processorResult = err
 		return err
 	}
-	if err := v.conditionCheck(); err != nil {
+	if err := v.conditionCheck(ctx); err != nil {
 		v.result.conditionResult = err
 		return err
 	}
-	if v.Processor != nil {
-		return v.ExecuteProcessor(ctx)
+	// fallback of a vertex timed out by its own timeout runs under parent
+	parent := ctx
+	if v.Vertex.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.Vertex.timeout)
+		defer cancel()
 	}
-	if v.Vertex.Cluster != "" {
-		return v.ExecuteSubGraph(ctx)
+	if len(v.Vertex.Switch) > 0 {
+		v.evalSwitch()
+	} else if v.Vertex.Foreach != nil {
+		err = v.ExecuteForeach(ctx)
+		if ctx.Err() != nil {
+			err = v.setContextDone(ctx)
+		}
+	} else if v.Processor != nil {
+		err = v.executeProcessor(parent, ctx)
+	} else if v.Vertex.Cluster != "" {
+		err = v.ExecuteSubGraph(ctx)
+		if ctx.Err() != nil {
+			err = v.setContextDone(ctx)
+		}
 	}
-	return nil
+	if v.result.status != VertexNotRun {
+		return err
+	}
+	if v.result.processorResult != nil {
+		v.result.status = VertexErr
+	} else {
+		v.result.status = VertexOk
+	}
+	return err
+}
+
+// setContextDone record timeout or cancelled result, successors see it as error
+func (v *VertexContext) setContextDone(ctx context.Context) error {
+	if v.isCancelledByJoin() {
+		// result no longer needed by partial join, not a failure
+		v.result.status = VertexSkippedByCancel
+		v.result.processorResult = nil
+		v.result.conditionResult = innererror.Errorf(innererror.VResultErr,
+			"vertex:%s cancelled by join err:%v", v.Vertex.ID, ctx.Err())
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		v.result.status = VertexTimeout
+		v.result.processorResult = innererror.Errorf(ResultCodeTimeout, "vertex:%s err:%v", v.Vertex.ID, ctx.Err())
+	} else {
+		v.result.status = VertexCancelled
+		v.result.processorResult = innererror.Errorf(ResultCodeCancelled, "vertex:%s err:%v", v.Vertex.ID, ctx.Err())
+	}
+	v.result.conditionResult = innererror.Errorf(innererror.VResultErr,
+		"vertex:%s err:%v", v.Vertex.ID, ctx.Err())
+	return v.result.processorResult
+}
+
+type processorReturn struct {
+	err      error
+	panicked interface{}
+}
+
+// runProcessor run processor, under a vertex timeout, join cancel_rest or graph deadline return ctx err
+// as soon as ctx done. The processor still running in background is abandoned and replaced by a spare instance.
+func (v *VertexContext) runProcessor(ctx context.Context, params *param.Params) error {
+	if !v.abandonable && ctx.Value(graphDeadlineKey{}) == nil {
+		return v.Processor.OnExecute(ctx, params)
+	}
+	p := v.Processor
+	done := make(chan processorReturn, 1)
+	safe.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- processorReturn{panicked: r}
+			}
+		}()
+		done <- processorReturn{err: p.OnExecute(ctx, params)}
+	})
+	select {
+	case r := <-done:
+		if r.panicked != nil {
+			panic(r.panicked)
+		}
+		return r.err
+	case <-ctx.Done():
+		v.abandonProcessor(p, done)
+		return ctx.Err()
+	}
+}
+
+// abandonProcessor switch to a spare processor keeping the bound DI, the abandoned processor
+// keeps the slot of its cluster and becomes a spare once it returns
+func (v *VertexContext) abandonProcessor(p processor.Processor, done <-chan processorReturn) {
+	release := holdClusterSlot(v.GraphContext)
+	spares := v.Vertex.spares
+	safe.Go(func() {
+		defer release()
+		if r := <-done; r.panicked == nil {
+			spares.put(p)
+		}
+	})
+	spare := spares.get()
+	if spare == nil {
+		spare = processor.Get(v.Vertex.Processor)
+		spare.OnInit()
+	}
+	v.Processor = spare
+	v.ProcessorDI.Processor = spare
+}
+
+// processorSpares initialized processors of a vertex returned by abandoned executions
+type processorSpares struct {
+	lock  sync.Mutex
+	items []processor.Processor
+}
+
+func (s *processorSpares) get() processor.Processor {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.items) == 0 {
+		return nil
+	}
+	p := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return p
+}
+
+func (s *processorSpares) put(p processor.Processor) {
+	s.lock.Lock()
+	s.items = append(s.items, p)
+	s.lock.Unlock()
 }
 
 // ExecuteProcessor execute one processor
 func (v *VertexContext) ExecuteProcessor(ctx context.Context) error {
+	return v.executeProcessor(ctx, ctx)
+}
+
+// executeProcessor execute processor under ctx with the vertex timeout, fallback runs under parent
+// as long as the graph is not done, so a vertex timed out by its own timeout still falls back
+func (v *VertexContext) executeProcessor(parent, ctx context.Context) error {
 	start := time.Now()
 	defer func() {
-		AddEvent(&Event{
-			Processor: v.Vertex.Processor,
-			Duration:  time.Since(start),
-		})
+		v.result.duration = time.Since(start)
 	}()
+	executed := v
+	if replayed, err := v.replayProcessor(); replayed {
+		v.ProcessorDI.RemoveMovedInput(v.GraphContext.ExternDataContext, v.Vertex.Input)
+		if err != nil {
+			v.result.processorResult = err
+			return nil
+		}
+		v.ProcessorDI.CollectOutputIf(v.GraphContext.ExternDataContext, v.outputFilter(v.Vertex.Output))
+		return nil
+	}
+	err := v.executeWithRetry(ctx, v.getSelectedParams(ctx))
+	if err != nil && parent.Err() == nil {
+		if v.Vertex.fallbackVertex != nil {
+			ctx = parent
+			executed = v.fallback
+			v.result.fallback = true
+			err = executed.executeOnce(ctx, executed.getSelectedParams(ctx))
+		} else if len(v.Vertex.FallbackArgs) > 0 {
+			ctx = parent
+			v.result.fallback = true
+			err = v.executeOnce(ctx, v.withGlobalParams(&v.Vertex.FallbackArgs))
+		}
+	}
+	v.ProcessorDI.RemoveMovedInput(v.GraphContext.ExternDataContext, v.Vertex.Input)
+	if executed != v {
+		executed.ProcessorDI.RemoveMovedInput(v.GraphContext.ExternDataContext, executed.Vertex.Input)
+	}
+	if ctx.Err() != nil && (err == nil || errors.Is(err, ctx.Err())) {
+		// finished after graph deadline or cancel, the result is late
+		v.setContextDone(ctx)
+		return nil
+	}
+	v.result.processorResult = err
+	executed.ProcessorDI.CollectOutputIf(v.GraphContext.ExternDataContext, v.outputFilter(executed.Vertex.Output))
+	return nil
+}
+
+// getSelectedParams get params matched by select_args with global params
+func (v *VertexContext) getSelectedParams(ctx context.Context) *param.Params {
 	executeParams := v.GetExecuteParams()
 	for _, condParams := range v.Vertex.SelectArgs {
-		if expect := v.GraphContext.ExternDataContext.GetConfigSetting(condParams.Match); expect {
+		if expect := v.GraphContext.ClusterContext.evalConfigSetting(ctx, condParams.Match); expect {
 			executeParams = &(condParams.Args)
 			break
 		}
 	}
-	v.ProcessorDI.Reset()
-	v.ProcessorDI.InjectInput(v.GraphContext.ExternDataContext, v.Vertex.Input)
+	return v.withGlobalParams(executeParams)
+}
+
+func (v *VertexContext) withGlobalParams(executeParams *param.Params) *param.Params {
 	// set global params
 	if v.GraphContext.ClusterContext.ExecuteParams != nil {
 		executeParams = executeParams.Clone()
 		executeParams.Set("GLOBAL", *v.GraphContext.ClusterContext.ExecuteParams)
 	}
-	v.result.processorResult = v.Processor.OnExecute(ctx, executeParams)
-	v.ProcessorDI.CollectOutput(v.GraphContext.ExternDataContext)
-	return nil
+	return executeParams
+}
+
+// executeOnce execute processor with fresh injected input
+func (v *VertexContext) executeOnce(ctx context.Context, executeParams *param.Params) error {
+	v.ProcessorDI.Reset()
+	v.ProcessorDI.InjectInput(v.getDataContext(), v.Vertex.Input)
+	v.traceInputs()
+	return v.runProcessor(ctx, executeParams)
+}
+
+// getDataContext data context of the foreach element for workers, or of the graph
+func (v *VertexContext) getDataContext() *DataContext {
+	if v.dataContext != nil {
+		return v.dataContext
+	}
+	return v.GraphContext.ExternDataContext
+}
+
+func (v *VertexContext) executeWithRetry(ctx context.Context, executeParams *param.Params) error {
+	for attempt := 0; ; attempt++ {
+		v.result.attempts++
+		err := v.executeOnce(ctx, executeParams)
+		if err == nil || ctx.Err() != nil || !v.Vertex.shouldRetry(attempt, err) {
+			return err
+		}
+		if v.Vertex.retryBackoff > 0 {
+			select {
+			case <-time.After(v.Vertex.retryBackoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
 }
 
 // ExecuteSubGraph execute sub graph
 func (v *VertexContext) ExecuteSubGraph(ctx context.Context) error {
-	return Execute(ctx, v.Vertex.Cluster, v.Vertex.Graph,
-		v.GraphContext.ExternDataContext, v.GraphContext.ClusterContext.ExecuteParams)
+	start := time.Now()
+	if t := v.GraphContext.getTracer(); t != nil {
+		var span Span
+		ctx, span = t.Start(ctx, SpanSubGraph,
+			SpanAttribute{Key: "dag.vertex", Value: v.Vertex.ID},
+			SpanAttribute{Key: "dag.cluster", Value: v.Vertex.Cluster},
+			SpanAttribute{Key: "dag.graph", Value: v.Vertex.Graph})
+		defer func() {
+			endSpan(span, v.result.processorResult)
+		}()
+	}
+	scope, err := v.getSubGraphScope()
+	var result *ExecuteResult
+	if err == nil {
+		result, err = v.runSubGraph(ctx, v.GraphContext.ExternDataContext, scope, -1)
+	}
+	v.result.duration = time.Since(start)
+	v.result.subGraphResult = result
+	v.result.processorResult = err
+	return err
+}
+
+// runSubGraph execute the called graph with data context and scope in the clusters generation of the caller,
+// element is the index of foreach element or -1
+func (v *VertexContext) runSubGraph(ctx context.Context, dataContext *DataContext,
+	scope *param.Params, element int) (*ExecuteResult, error) {
+	m := v.GraphContext.ClusterContext.Cluster.GraphManager
+	if m == nil {
+		m = DefaultManager
+	}
+	cc := v.GraphContext.ClusterContext
+	clusters := cc.clusters
+	if clusters == nil {
+		clusters = m.snapshot()
+	}
+	return m.executeIn(ctx, clusters, v.Vertex.Cluster, v.Vertex.Graph,
+		dataContext, cc.ExecuteParams, scope, cc.depth+1,
+		executeOptions{step: cc.step, trace: cc.trace, replay: cc.replay,
+			parentID: cc.executionID, parentVertex: v.Vertex.ID, caller: cc, callPath: v.subGraphCallPath(element)})
+}
+
+// subGraphCallPath call path of the subgraph called by vertex, the same on record and replay
+func (v *VertexContext) subGraphCallPath(element int) string {
+	cc := v.GraphContext.ClusterContext
+	call := cc.Cluster.Name + "::" + v.GraphContext.Graph.Name + "/" + v.Vertex.ID
+	if element >= 0 {
+		call += "[" + strconv.Itoa(element) + "]"
+	}
+	if len(cc.callPath) == 0 {
+		return call
+	}
+	return cc.callPath + ">" + call
+}
+
+// getSubGraphScope caller scope overlaid with the subgraph vertex args,
+// '$' string args are resolved by caller scope
+func (v *VertexContext) getSubGraphScope() (*param.Params, error) {
+	scope, err := subGraphScope(v.GraphContext.ClusterContext.Scope, v.Vertex.Params)
+	if err != nil {
+		return nil, fmt.Errorf("vertex:%s subgraph %w", v.Vertex.ID, err)
+	}
+	return scope, nil
+}
+
+func (v *VertexContext) getResult() *VertexResult {
+	vr := &VertexResult{
+		ID:              v.Vertex.ID,
+		Processor:       v.Vertex.Processor,
+		Status:          v.result.status,
+		Duration:        v.result.duration,
+		Critical:        v.Vertex.Critical,
+		SubGraph:        v.result.subGraphResult,
+		Attempts:        v.result.attempts,
+		Fallback:        v.result.fallback,
+		Elements:        v.result.elements,
+		FailedElements:  v.result.failedElements,
+		DroppedElements: v.result.droppedElements,
+	}
+	vr.Err = v.result.processorResult
+	if vr.Err == nil && v.result.status.IsSkipped() {
+		vr.Err = v.result.conditionResult
+	}
+	vr.Code = innererror.Code(vr.Err)
+	return vr
 }
 
 // GetExecuteParams get vertex context execute params
@@ -244,6 +664,9 @@ func (v *VertexContext) SetDependencyResult(vertex *Vertex, r interface{}) int32
 	v.vertexDepResults.Store(vertex.ID, r)
 	if !ok {
 		newWaitNum := atomic.AddInt32(&v.waitNum, -1)
+		if v.Vertex.Join.isPartial() {
+			return v.setJoinDependencyResult(newWaitNum)
+		}
 		return newWaitNum
 	}
 	return atomic.LoadInt32(&v.waitNum)
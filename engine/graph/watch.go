@@ -0,0 +1,189 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"xxxx/util/safe"
+)
+
+const defaultWatchInterval = time.Second
+
+// FileStatus load status of one watched cluster file
+type FileStatus struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+	// Generation the generation current version of the file is published in
+	Generation  int64
+	LastErr     error
+	LastErrTime time.Time
+
+	// pending built but not published because cross cluster validation failed
+	pending *Cluster
+}
+
+// WatchDir load every .toml/.json cluster in dir, then reload changed files every interval until ctx done.
+// A reload is published only if all changed files build and cross cluster references of changed clusters
+// and their callers are valid, otherwise the previous version stays live.
+func (m *Manager) WatchDir(ctx context.Context, dir string, interval time.Duration) error {
+	if err := m.reloadDir(dir); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	safe.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.reloadDir(dir); err != nil {
+					log.Printf("reload dir:%v err:%v", dir, err)
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// FileStatus get load status of watched files by cluster name
+func (m *Manager) FileStatus() map[string]FileStatus {
+	m.watchLock.Lock()
+	defer m.watchLock.Unlock()
+	status := make(map[string]FileStatus, len(m.watchFiles))
+	for name, fs := range m.watchFiles {
+		status[name] = *fs
+	}
+	return status
+}
+
+func isClusterFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".toml" || ext == ".json"
+}
+
+func (m *Manager) buildClusterFile(file string) (*Cluster, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(file)
+	if filepath.Ext(file) == ".toml" {
+		return m.buildCluster(name, content, &TomlCodec{})
+	}
+	return m.buildCluster(name, content, &JSONCodec{})
+}
+
+func (fs *FileStatus) setErr(err error) {
+	fs.LastErr = err
+	fs.LastErrTime = time.Now()
+}
+
+// reloadDir build changed files and publish them with a new generation
+func (m *Manager) reloadDir(dir string) error {
+	m.watchLock.Lock()
+	defer m.watchLock.Unlock()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	changed := false
+	updated := make(map[string]*Cluster)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isClusterFile(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		seen[name] = true
+		fs, exist := m.watchFiles[name]
+		if !exist {
+			fs = &FileStatus{Path: filepath.Join(dir, name)}
+			m.watchFiles[name] = fs
+		} else if fs.ModTime.Equal(info.ModTime()) && fs.Size == info.Size() {
+			if fs.pending != nil {
+				updated[name] = fs.pending
+			}
+			continue
+		}
+		changed = true
+		fs.ModTime = info.ModTime()
+		fs.Size = info.Size()
+		fs.pending = nil
+		cluster, err := m.buildClusterFile(fs.Path)
+		if err != nil {
+			// keep previous version live
+			err = fmt.Errorf("load file:%v err:%w", fs.Path, err)
+			fs.setErr(err)
+			errs = append(errs, err)
+			continue
+		}
+		updated[name] = cluster
+	}
+	var removed []string
+	for name := range m.watchFiles {
+		if !seen[name] {
+			removed = append(removed, name)
+			changed = true
+		}
+	}
+	if !changed || len(updated)+len(removed) == 0 {
+		return errors.Join(errs...)
+	}
+	candidate := mergeClusters(m.snapshot(), updated, removed)
+	changedNames := append([]string(nil), removed...)
+	for name := range updated {
+		changedNames = append(changedNames, name)
+	}
+	err, others := validateChanged(candidate, changedNames)
+	if err != nil {
+		for name, cluster := range updated {
+			m.watchFiles[name].pending = cluster
+			m.watchFiles[name].setErr(err)
+		}
+		errs = append(errs, err)
+		return errors.Join(errs...)
+	}
+	generation := m.publish(updated, removed)
+	for _, name := range removed {
+		delete(m.watchFiles, name)
+	}
+	for name := range updated {
+		fs := m.watchFiles[name]
+		fs.Generation = generation
+		fs.LastErr = nil
+		fs.pending = nil
+	}
+	// problems of clusters not touched by the reload are reported without blocking it
+	invalid := make(map[string]bool)
+	for _, ce := range others {
+		log.Printf("reload dir:%v err:%v", dir, ce)
+		for _, name := range ce.clusters {
+			invalid[name] = true
+			if fs, exist := m.watchFiles[name]; exist {
+				fs.setErr(ce)
+			}
+		}
+	}
+	for name, fs := range m.watchFiles {
+		if _, ok := fs.LastErr.(*clusterError); ok && !invalid[name] {
+			fs.LastErr = nil
+		}
+	}
+	return errors.Join(errs...)
+}
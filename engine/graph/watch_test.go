@@ -0,0 +1,167 @@
+package graph
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const watchTestCluster = `
+[[graph]]
+name = "enter"
+
+[[graph.vertex]]
+start = true
+processor = "phase3"
+`
+
+const watchTestBadCluster = `
+[[graph]]
+name = "enter"
+
+[[graph.vertex]]
+start = true
+processor = "not_exist_processor"
+`
+
+const watchTestCallerCluster = `
+[[graph]]
+name = "enter"
+
+[[graph.vertex]]
+id = "call_c"
+start = true
+cluster = "c.toml"
+graph = "enter"
+`
+
+func TestManager_WatchDir(t *testing.T) {
+	registerSchedulerTestProcessors()
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.toml", watchTestCluster)
+	m := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.WatchDir(ctx, dir, time.Hour); err != nil {
+		t.Fatalf("Manager.WatchDir() error = %v", err)
+	}
+	tests := []struct {
+		name           string
+		update         func()
+		wantErr        bool
+		wantGeneration int64
+		wantLive       map[string]bool
+		wantLastErr    map[string]bool
+	}{
+		{
+			name:           "build_err_keep_old",
+			update:         func() { write("a.toml", watchTestBadCluster) },
+			wantErr:        true,
+			wantGeneration: 1,
+			wantLive:       map[string]bool{"a.toml": true},
+			wantLastErr:    map[string]bool{"a.toml": true},
+		},
+		{
+			name:           "missing_ref_not_published",
+			update:         func() { write("b.toml", watchTestCallerCluster) },
+			wantErr:        true,
+			wantGeneration: 1,
+			wantLive:       map[string]bool{"a.toml": true, "b.toml": false},
+			wantLastErr:    map[string]bool{"a.toml": true, "b.toml": true},
+		},
+		{
+			name:           "ref_resolved",
+			update:         func() { write("c.toml", watchTestCluster) },
+			wantGeneration: 2,
+			wantLive:       map[string]bool{"a.toml": true, "b.toml": true, "c.toml": true},
+			wantLastErr:    map[string]bool{"a.toml": true, "b.toml": false, "c.toml": false},
+		},
+		{
+			name: "fix_and_remove",
+			update: func() {
+				write("a.toml", watchTestCluster)
+				if err := os.Remove(filepath.Join(dir, "b.toml")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantGeneration: 3,
+			wantLive:       map[string]bool{"a.toml": true, "b.toml": false, "c.toml": true},
+			wantLastErr:    map[string]bool{"a.toml": false, "c.toml": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.update()
+			if err := m.reloadDir(dir); (err != nil) != tt.wantErr {
+				t.Fatalf("Manager.reloadDir() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := m.Generation(); got != tt.wantGeneration {
+				t.Errorf("Manager.Generation() = %v, want %v", got, tt.wantGeneration)
+			}
+			for name, live := range tt.wantLive {
+				_, err := m.execute(context.Background(), name, "enter", newTestDataContext(), nil, nil)
+				if (err == nil) != live {
+					t.Errorf("Manager.execute() cluster:%v error = %v, want live %v", name, err, live)
+				}
+			}
+			status := m.FileStatus()
+			if len(status) != len(tt.wantLastErr) {
+				t.Errorf("Manager.FileStatus() = %v, want %v files", status, len(tt.wantLastErr))
+			}
+			for name, wantErr := range tt.wantLastErr {
+				if (status[name].LastErr != nil) != wantErr {
+					t.Errorf("Manager.FileStatus() %v LastErr = %v, want err %v", name, status[name].LastErr, wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_WatchDirOtherInvalid(t *testing.T) {
+	registerSchedulerTestProcessors()
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := New()
+	// live cluster outside of dir calling missing c.toml
+	if err := m.load("x.toml", []byte(watchTestCallerCluster), &TomlCodec{}); err != nil {
+		t.Fatalf("Manager.load() error = %v", err)
+	}
+	write("a.toml", watchTestCluster)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.WatchDir(ctx, dir, time.Hour); err != nil {
+		t.Fatalf("Manager.WatchDir() error = %v", err)
+	}
+	write("a.toml", watchTestCluster+"\n")
+	if err := m.reloadDir(dir); err != nil {
+		t.Fatalf("Manager.reloadDir() error = %v", err)
+	}
+	if got := m.Generation(); got != 3 {
+		t.Errorf("Manager.Generation() = %v, want 3", got)
+	}
+	// removing a cluster called by x.toml is still blocked
+	write("c.toml", watchTestCluster)
+	if err := m.reloadDir(dir); err != nil {
+		t.Fatalf("Manager.reloadDir() error = %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "c.toml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.reloadDir(dir); err == nil {
+		t.Errorf("Manager.reloadDir() removing c.toml, want error")
+	}
+	if got := m.Generation(); got != 4 {
+		t.Errorf("Manager.Generation() = %v, want 4", got)
+	}
+}
@@ -33,11 +33,14 @@ func Register(name string, p Creator) {
 	lock.Unlock()
 }
 
-// Get get processor。
+// Get get processor。nil if name is not registered
 func Get(name string) Processor {
 	lock.RLock()
-	p := processors[name]
+	p, ok := processors[name]
 	lock.RUnlock()
+	if !ok {
+		return nil
+	}
 	return p()
 }
 
@@ -52,6 +55,8 @@ type FieldFlags struct {
 type FieldMeta struct {
 	Name  string     `json:"name"`
 	Flags FieldFlags `json:"flags"`
+	// Type go type of the field, nil if meta is loaded from file
+	Type reflect.Type `json:"-"`
 }
 
 // OperatorMeta processor.OperatorMeta
@@ -75,19 +80,13 @@ func GenerateMetas() []OperatorMeta {
 // GenerateMeta generate one processor input output meta
 func GenerateMeta(name string, p Processor) OperatorMeta {
 	var input, output []FieldMeta
-	rType := reflect.TypeOf(p)
-	if rType.Kind() == reflect.Ptr {
-		rType = rType.Elem()
-	}
-	for i := 0; i < rType.NumField(); i++ {
-		t := rType.Field(i)
-		tag := t.Tag.Get("graph")
-		if tag == "input" {
-			input = append(input, FieldMeta{Name: t.Name})
-		} else if tag == "multi_input" {
-			input = append(input, FieldMeta{Name: t.Name, Flags: FieldFlags{Aggregate: 1}})
-		} else if tag == "output" {
-			output = append(output, FieldMeta{Name: t.Name})
+	for _, f := range Fields(reflect.TypeOf(p)) {
+		if f.Tag == "input" {
+			input = append(input, FieldMeta{Name: f.Name, Type: f.Type})
+		} else if f.Tag == "multi_input" {
+			input = append(input, FieldMeta{Name: f.Name, Flags: FieldFlags{Aggregate: 1}, Type: f.Type})
+		} else if f.Tag == "output" {
+			output = append(output, FieldMeta{Name: f.Name, Type: f.Type})
 		}
 	}
 	return OperatorMeta{Name: name, Input: input, Output: output}
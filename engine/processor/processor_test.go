@@ -39,20 +39,56 @@ func TestGenerateMetas(t *testing.T) {
 	}{
 		{name: "t0", want: []OperatorMeta{
 			{Name: "phase0",
-				Input:  []FieldMeta{{Name: "Input"}},
-				Output: []FieldMeta{{Name: "Output"}}},
+				Input:  []FieldMeta{{Name: "Input", Type: reflect.TypeOf(0)}},
+				Output: []FieldMeta{{Name: "Output", Type: reflect.TypeOf(0)}}},
 			{Name: "phase1",
-				Input:  []FieldMeta{{Name: "MultiInput1", Flags: FieldFlags{Aggregate: 1}}},
-				Output: []FieldMeta{{Name: "Output1"}}},
+				Input:  []FieldMeta{{Name: "MultiInput1", Flags: FieldFlags{Aggregate: 1}, Type: reflect.TypeOf(0)}},
+				Output: []FieldMeta{{Name: "Output1", Type: reflect.TypeOf(0)}}},
 		}},
 	}
 	Register("phase0", func() Processor { return &phase0{} })
 	Register("phase1", func() Processor { return &phase1{} })
+	unregisterOnCleanup(t, "phase0", "phase1")
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := GenerateMetas(); !reflect.DeepEqual(got, tt.want) {
+			if got := metasOf(GenerateMetas(), "phase0", "phase1"); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("GenerateMetas() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestGet(t *testing.T) {
+	Register("phase0", func() Processor { return &phase0{} })
+	unregisterOnCleanup(t, "phase0")
+	if p := Get("phase0"); p == nil {
+		t.Errorf("Get(phase0) = nil, want processor")
+	}
+	if p := Get("not_registered"); p != nil {
+		t.Errorf("Get(not_registered) = %v, want nil", p)
+	}
+}
+
+// unregisterOnCleanup remove processors registered by test when it finishes
+func unregisterOnCleanup(t *testing.T, names ...string) {
+	t.Cleanup(func() {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, name := range names {
+			delete(processors, name)
+		}
+	})
+}
+
+// metasOf metas of names in order, processors registered by other tests are ignored
+func metasOf(metas []OperatorMeta, names ...string) []OperatorMeta {
+	var got []OperatorMeta
+	for _, name := range names {
+		for _, meta := range metas {
+			if meta.Name == name {
+				got = append(got, meta)
+			}
+		}
+	}
+	return got
+}
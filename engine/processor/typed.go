@@ -0,0 +1,275 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+
+	"xxxx/dagengine/engine/param"
+)
+
+// tags of the input and output structs of typed processor
+const (
+	tagIn  = "in"
+	tagOut = "out"
+)
+
+// Field data field of processor, Index is the path from processor struct
+type Field struct {
+	Index []int
+	Name  string
+	Tag   string
+	Type  reflect.Type
+}
+
+// Fields data fields of processor struct type tagged by graph, fields of the input and output
+// structs of typed processor are flattened as inputs and outputs
+func Fields(rType reflect.Type) []Field {
+	if rType.Kind() == reflect.Ptr {
+		rType = rType.Elem()
+	}
+	var fields []Field
+	for i := 0; i < rType.NumField(); i++ {
+		t := rType.Field(i)
+		tag := t.Tag.Get("graph")
+		if len(tag) == 0 {
+			continue
+		}
+		if (tag != tagIn && tag != tagOut) || t.Type.Kind() != reflect.Struct {
+			fields = append(fields, Field{Index: []int{i}, Name: t.Name, Tag: tag, Type: t.Type})
+			continue
+		}
+		for j := 0; j < t.Type.NumField(); j++ {
+			sub := t.Type.Field(j)
+			subTag := sub.Tag.Get("graph")
+			if !sub.IsExported() || subTag == "-" {
+				continue
+			}
+			if tag == tagOut {
+				subTag = "output"
+			} else if len(subTag) == 0 {
+				subTag = "input"
+			}
+			fields = append(fields, Field{Index: []int{i, j}, Name: sub.Name, Tag: subTag, Type: sub.Type})
+		}
+	}
+	return fields
+}
+
+// typedProcessor processor running a typed function, In and Out are flattened by Fields
+type typedProcessor[In, Out, Args any] struct {
+	In  In  `graph:"in"`
+	Out Out `graph:"out"`
+
+	fn   func(ctx context.Context, in In, args Args) (Out, error)
+	name string
+}
+
+func (p *typedProcessor[In, Out, Args]) OnInit() {
+}
+
+// OnExecute decode args and run function, outputs are set even if it returns error
+func (p *typedProcessor[In, Out, Args]) OnExecute(ctx context.Context, params *param.Params) error {
+	var args Args
+	if err := DecodeArgs(params, &args); err != nil {
+		return fmt.Errorf("processor:%s %w", p.name, err)
+	}
+	out, err := p.fn(ctx, p.In, args)
+	p.Out = out
+	return err
+}
+
+// RegisterTyped register processor defined by a function.
+// Fields of In are inputs, tag multi_input, extern_input, element or index changes the kind, "-" skips the field;
+// fields of Out are outputs; Args is decoded from args of vertex by DecodeArgs.
+// Typed processors have the same meta as tagged ones, both can be used in one graph.
+func RegisterTyped[In, Out, Args any](name string, fn func(ctx context.Context, in In, args Args) (Out, error)) {
+	for _, t := range []reflect.Type{reflect.TypeOf((*In)(nil)).Elem(), reflect.TypeOf((*Out)(nil)).Elem()} {
+		if t.Kind() != reflect.Struct {
+			panic(fmt.Sprintf("processor:%s typed input/output:%v is not a struct", name, t))
+		}
+	}
+	if t := reflect.TypeOf((*Args)(nil)).Elem(); t.Kind() != reflect.Struct && t != paramsType {
+		panic(fmt.Sprintf("processor:%s typed args:%v is not a struct or param.Params", name, t))
+	}
+	Register(name, func() Processor {
+		return &typedProcessor[In, Out, Args]{fn: fn, name: name}
+	})
+}
+
+var paramsType = reflect.TypeOf(param.Params{})
+
+// argField field of args struct decoded from params
+type argField struct {
+	index    int
+	key      string
+	required bool
+}
+
+// argFields fields of every args struct type
+var argFields sync.Map
+
+// getArgFields fields of args struct, key is the 'param' tag or field name, 'required' option
+// requires the key present
+func getArgFields(rType reflect.Type) []argField {
+	if fields, ok := argFields.Load(rType); ok {
+		return fields.([]argField)
+	}
+	var fields []argField
+	for i := 0; i < rType.NumField(); i++ {
+		t := rType.Field(i)
+		if !t.IsExported() {
+			continue
+		}
+		tag := t.Tag.Get("param")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if len(name) == 0 {
+			name = t.Name
+		}
+		fields = append(fields, argField{index: i, key: name, required: opts == "required"})
+	}
+	argFields.Store(rType, fields)
+	return fields
+}
+
+// DecodeArgs decode params into args struct pointed by out, numbers are converted between int and float
+// without loss, lists and tables into slices, maps and structs. Args implementing Validate() error are validated.
+func DecodeArgs(params *param.Params, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("invalid args:%T", out)
+	}
+	if err := decodeArg(rv.Elem(), "args", map[string]interface{}(derefParams(params))); err != nil {
+		return err
+	}
+	if v, ok := out.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("invalid args:%w", err)
+		}
+	}
+	return nil
+}
+
+func derefParams(params *param.Params) param.Params {
+	if params == nil {
+		return param.Params{}
+	}
+	return *params
+}
+
+// decodeArg decode value of arg name into f
+func decodeArg(f reflect.Value, name string, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(f.Type()) {
+		f.Set(v)
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch {
+		case v.CanInt():
+			i = v.Int()
+		case v.CanUint() && v.Uint() <= math.MaxInt64:
+			i = int64(v.Uint())
+		case v.CanFloat() && v.Float() == math.Trunc(v.Float()) && math.Abs(v.Float()) < math.MaxInt64:
+			i = int64(v.Float())
+		default:
+			return fmt.Errorf("arg:%s value:%v is not an integer", name, value)
+		}
+		if f.OverflowInt(i) {
+			return fmt.Errorf("arg:%s value:%v overflows %v", name, value, f.Type())
+		}
+		f.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch {
+		case v.CanInt() && v.Int() >= 0:
+			u = uint64(v.Int())
+		case v.CanUint():
+			u = v.Uint()
+		case v.CanFloat() && v.Float() >= 0 && v.Float() == math.Trunc(v.Float()) && v.Float() < math.MaxUint64:
+			u = uint64(v.Float())
+		default:
+			return fmt.Errorf("arg:%s value:%v is not an unsigned integer", name, value)
+		}
+		if f.OverflowUint(u) {
+			return fmt.Errorf("arg:%s value:%v overflows %v", name, value, f.Type())
+		}
+		f.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case v.CanFloat():
+			f.SetFloat(v.Float())
+		case v.CanInt():
+			f.SetFloat(float64(v.Int()))
+		case v.CanUint():
+			f.SetFloat(float64(v.Uint()))
+		default:
+			return fmt.Errorf("arg:%s value:%v is not a number", name, value)
+		}
+	case reflect.Slice:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return fmt.Errorf("arg:%s value:%v is not a list", name, value)
+		}
+		s := reflect.MakeSlice(f.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := decodeArg(s.Index(i), fmt.Sprintf("%s[%d]", name, i), v.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+	case reflect.Map:
+		if v.Kind() != reflect.Map || f.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("arg:%s value:%v is not a table", name, value)
+		}
+		m := reflect.MakeMapWithSize(f.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			elem := reflect.New(f.Type().Elem()).Elem()
+			if err := decodeArg(elem, name+"."+key, iter.Value().Interface()); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(f.Type().Key()), elem)
+		}
+		f.Set(m)
+	case reflect.Struct:
+		if v.Kind() != reflect.Map {
+			return fmt.Errorf("arg:%s value:%v is not a table", name, value)
+		}
+		for _, af := range getArgFields(f.Type()) {
+			elem := v.MapIndex(reflect.ValueOf(af.key).Convert(v.Type().Key()))
+			if !elem.IsValid() {
+				if af.required {
+					return fmt.Errorf("arg:%s.%s is required", name, af.key)
+				}
+				continue
+			}
+			if err := decodeArg(f.Field(af.index), name+"."+af.key, elem.Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		p := reflect.New(f.Type().Elem())
+		if err := decodeArg(p.Elem(), name, value); err != nil {
+			return err
+		}
+		f.Set(p)
+	default:
+		if !v.Type().ConvertibleTo(f.Type()) {
+			return fmt.Errorf("arg:%s value:%v can NOT convert to %v", name, value, f.Type())
+		}
+		f.Set(v.Convert(f.Type()))
+	}
+	return nil
+}
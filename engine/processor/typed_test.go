@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"xxxx/dagengine/engine/param"
+)
+
+type typedIn struct {
+	Input  int
+	Inputs map[string]int `graph:"multi_input"`
+	Skip   int            `graph:"-"`
+	hidden int
+}
+
+type typedOut struct {
+	Output  int
+	Outputs []string
+}
+
+type typedArgs struct {
+	Name  string             `param:"name,required"`
+	Limit int                `param:"limit"`
+	Rate  float64            `param:"rate"`
+	IDs   []int32            `param:"ids"`
+	Tags  map[string]bool    `param:"tags"`
+	Inner struct{ Size int } `param:"inner"`
+	Ptr   *uint              `param:"ptr"`
+	Skip  int                `param:"-"`
+}
+
+func (a *typedArgs) Validate() error {
+	if a.Limit < 0 {
+		return fmt.Errorf("limit:%d < 0", a.Limit)
+	}
+	return nil
+}
+
+type tagged struct {
+	Input   int            `graph:"input"`
+	Inputs  map[string]int `graph:"multi_input"`
+	Output  int            `graph:"output"`
+	Outputs []string       `graph:"output"`
+}
+
+func (p *tagged) OnInit() {
+}
+
+func (p *tagged) OnExecute(_ context.Context, params *param.Params) error {
+	return nil
+}
+
+func TestRegisterTyped(t *testing.T) {
+	RegisterTyped("typed", func(_ context.Context, in typedIn, args typedArgs) (typedOut, error) {
+		return typedOut{Output: in.Input + args.Limit, Outputs: []string{args.Name}}, nil
+	})
+	unregisterOnCleanup(t, "typed", "typed_bad_in", "typed_bad_args", "typed_params")
+	got := GenerateMeta("typed", Get("typed"))
+	if want := GenerateMeta("typed", &tagged{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("GenerateMeta() = %v, want %v", got, want)
+	}
+
+	p := Get("typed").(*typedProcessor[typedIn, typedOut, typedArgs])
+	p.In.Input = 1
+	if err := p.OnExecute(context.Background(), &param.Params{"name": "a", "limit": int64(2)}); err != nil {
+		t.Fatalf("OnExecute() error = %v", err)
+	}
+	if p.Out.Output != 3 || !reflect.DeepEqual(p.Out.Outputs, []string{"a"}) {
+		t.Errorf("OnExecute() out = %+v", p.Out)
+	}
+	if err := p.OnExecute(context.Background(), &param.Params{"limit": int64(2)}); err == nil {
+		t.Errorf("OnExecute() without required arg, want error")
+	}
+
+	for _, fn := range []func(){
+		func() {
+			RegisterTyped("typed_bad_in", func(context.Context, int, typedArgs) (typedOut, error) { return typedOut{}, nil })
+		},
+		func() {
+			RegisterTyped("typed_bad_args", func(context.Context, typedIn, int) (typedOut, error) { return typedOut{}, nil })
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterTyped() with non struct type, want panic")
+				}
+			}()
+			fn()
+		}()
+	}
+	RegisterTyped("typed_params", func(_ context.Context, in typedIn, args param.Params) (typedOut, error) {
+		return typedOut{Output: int(args.GetInt64("limit"))}, nil
+	})
+}
+
+func TestDecodeArgs(t *testing.T) {
+	size := uint(4)
+	tests := []struct {
+		name    string
+		params  param.Params
+		want    typedArgs
+		wantErr bool
+	}{
+		{name: "convert", params: param.Params{
+			"name": "a", "limit": 3.0, "rate": int64(2), "ids": []interface{}{int64(1), 2.0},
+			"tags": map[string]interface{}{"x": true}, "inner": map[string]interface{}{"Size": int64(5)},
+			"ptr": int64(4), "Skip": 1, "GLOBAL": param.Params{}},
+			want: typedArgs{Name: "a", Limit: 3, Rate: 2, IDs: []int32{1, 2}, Tags: map[string]bool{"x": true},
+				Inner: struct{ Size int }{Size: 5}, Ptr: &size}},
+		{name: "required", params: param.Params{"limit": int64(1)}, wantErr: true},
+		{name: "type", params: param.Params{"name": "a", "limit": "1"}, wantErr: true},
+		{name: "fraction", params: param.Params{"name": "a", "limit": 1.5}, wantErr: true},
+		{name: "overflow", params: param.Params{"name": "a", "ids": []interface{}{int64(1) << 40}}, wantErr: true},
+		{name: "negative", params: param.Params{"name": "a", "ptr": int64(-1)}, wantErr: true},
+		{name: "validate", params: param.Params{"name": "a", "limit": int64(-1)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got typedArgs
+			err := DecodeArgs(&tt.params, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeArgs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
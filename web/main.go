@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"xxxx/dagengine/engine"
+	"xxxx/dagengine/engine/graph"
 )
 
 // WebRes response
@@ -21,6 +23,7 @@ func main() {
 		http.ServeFile(w, r, "edit.html")
 	})
 	http.Handle("/pngs/", http.StripPrefix("/pngs/", http.FileServer(http.Dir("./pngs"))))
+	http.Handle("/metrics", graph.NewMetrics(graph.DefaultManager))
 	var cursor int64
 	http.HandleFunc("/gen_png", func(w http.ResponseWriter, r *http.Request) {
 		_ = r.ParseForm()
@@ -42,7 +45,16 @@ func main() {
 			return
 		}
 		path := fmt.Sprintf("/pngs/%d", cursor)
-		err = dag.GenPng("." + path)
+		if trace := strings.TrimSpace(r.FormValue("trace")); len(trace) > 0 {
+			// render the recorded execution
+			var t *graph.Trace
+			t, err = graph.ReadTrace(strings.NewReader(trace))
+			if err == nil {
+				err = dag.GenResultPng("."+path, t.ExecuteResult())
+			}
+		} else {
+			err = dag.GenPng("." + path)
+		}
 		if nil != err {
 			log.Printf("Error:%v", err)
 			rs.Err = fmt.Sprintf("%v", err)